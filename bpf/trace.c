@@ -19,6 +19,16 @@ BPF_HASH(active_read_args_map, __u64, struct data_args_t);
 struct config
 {
     u8 debug_log;
+    u8 use_ringbuf;
+    u8 has_func_ip;
+    u8 stack_trace;
+    u16 max_path_depth;
+    u8 io_events;
+    u32 page_size;
+    // 同一进程访问同一文件时，每个间隔内只输出一次事件，0 表示不去重
+    u64 dedup_interval;
+    // 元数据操作的父目录、加锁文件在间隔内只解析一次路径，与 dedup_interval 无关
+    u64 path_cache_interval;
 } __attribute__((packed));
 static volatile const struct config CFG;
 
@@ -30,7 +40,102 @@ static volatile const struct config CFG;
 #ifndef RPC_TASK_VAR
 #define RPC_TASK_VAR nfs_pgio_header
 #endif
-#define MAX_PATH_DEPTH 10
+// 路径遍历深度的上限，实际深度由 cfg->max_path_depth 决定
+#define MAX_PATH_DEPTH 1024
+// 5.3 以前的内核不支持有界循环，以 -DPATH_WALK_UNROLL 编译展开的遍历，深度上限与 LegacyMaxPathDepth 一致
+#ifdef PATH_WALK_UNROLL
+#define PATH_WALK_DEPTH 10
+#else
+#define PATH_WALK_DEPTH MAX_PATH_DEPTH
+#endif
+#define PATH_NAME_LEN 128
+// 文件名按 PATH_NAME_LEN - 1 字节分片，NAME_MAX 为 255
+#define MAX_NAME_CHUNKS 3
+#ifndef RPC_TASK_ASYNC
+#define RPC_TASK_ASYNC 0x0001
+#endif
+#ifndef O_DIRECT
+#if defined(__TARGET_ARCH_arm64)
+#define O_DIRECT 0200000
+#else
+#define O_DIRECT 040000
+#endif
+#endif
+#define NFS_SUPER_MAGIC 0x6969
+#define EIOCBQUEUED 529
+#define VM_FAULT_MAJOR 0x0004
+// VM_FAULT_OOM | VM_FAULT_SIGBUS | VM_FAULT_HWPOISON | VM_FAULT_HWPOISON_LARGE | VM_FAULT_SIGSEGV
+#define VM_FAULT_ERROR 0x0073
+// 事件通道默认大小，用户态会根据配置重写 ringbuf 大小，回退 perf 时改写 map 类型
+#define EVENT_RINGBUF_SIZE (256 * 1024)
+
+// 事件通道，作为 submit_errors 的下标，与 internal/output 中 eventChannelMaps 保持一致
+enum event_channel
+{
+    EVENT_CHAN_NFS_TRACE,
+    EVENT_CHAN_PATH,
+    EVENT_CHAN_IO,
+    EVENT_CHAN_RPC_ERROR,
+    EVENT_CHAN_XPRT,
+    EVENT_CHAN_META,
+    EVENT_CHAN_NFS4,
+    EVENT_CHAN_LOCK,
+    EVENT_CHAN_SERVER,
+    EVENT_CHAN_DNS,
+    EVENT_CHAN_MAX,
+};
+
+// ringbuf 写满时丢弃的事件数，按通道统计。perf event array 丢弃的事件由内核写入 PERF_RECORD_LOST，在用户态统计
+struct
+{
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __type(key, u32);
+    __type(value, u64);
+    __uint(max_entries, EVENT_CHAN_MAX);
+} submit_errors SEC(".maps");
+
+// 内核支持 ringbuf 时使用 bpf_ringbuf_output 写入共享缓冲区，保证跨 CPU 的事件顺序，
+// 否则回退到 perf event array。bpf_core_type_exists 在旧内核上为常量 0，校验器会裁剪 ringbuf 分支
+static __always_inline long submit_event(void *ctx, void *map, u32 channel, void *data, u64 size)
+{
+    if (bpf_core_type_exists(struct bpf_ringbuf) && cfg->use_ringbuf)
+    {
+        long ret = bpf_ringbuf_output(map, data, size, 0);
+        if (ret < 0)
+        {
+            u64 *errors = bpf_map_lookup_elem(&submit_errors, &channel);
+            if (errors)
+                *errors += 1;
+        }
+        return ret;
+    }
+
+    return bpf_perf_event_output(ctx, map, BPF_F_CURRENT_CPU, data, size);
+}
+
+// 按偏移读取 tracepoint 字段，偏移为 0 表示字段不存在
+static __always_inline u32 tp_read_u32(void *ctx, u16 offset)
+{
+    u32 value = 0;
+    if (offset)
+        bpf_probe_read_kernel(&value, sizeof(value), ctx + offset);
+    return value;
+}
+
+static __always_inline u16 tp_read_u16(void *ctx, u16 offset)
+{
+    u16 value = 0;
+    if (offset)
+        bpf_probe_read_kernel(&value, sizeof(value), ctx + offset);
+    return value;
+}
+
+// 文件标识，dev_id 为完整的 dev_t，file_id 为 64 位的 NFS fileid
+struct file_key
+{
+    u64 dev_id;
+    u64 file_id;
+};
 
 struct raw_metrics
 {
@@ -40,6 +145,10 @@ struct raw_metrics
     u64 write_count;
     u64 write_size;
     u64 write_lat;
+    u64 read_queue_lat;
+    u64 read_rtt;
+    u64 write_queue_lat;
+    u64 write_rtt;
     char pod[100];
     char container[100];
 };
@@ -47,22 +156,25 @@ struct raw_metrics
 struct rpc_task_info
 {
     u64 timestamp;
+    struct file_key key;
     u32 pid;
     u32 tid;
 };
 
-struct rpc_task_state
+// rpc_task_begin/rpc_task_end（rpc_task_running 类）tracepoint 的字段偏移，由用户态解析 tracefs 中的
+// format 文件后写入，默认值为 4.x 以来的格式：task_id 和 client_id 均为 unsigned int
+struct rpc_task_layout
 {
-    /* The first 8 bytes is not allowed to read */
-    unsigned long pad;
-
-    u64 task_id;
-    u64 client_id;
-    const void *action;
-    unsigned long runstate;
-    int status;
-    unsigned short flags;
+    u16 task_id;
+    u16 client_id;
+    u16 status;
+    u16 flags;
 } __attribute__((packed));
+static volatile const struct rpc_task_layout RPC_TASK_LAYOUT = {
+    .task_id = 8,
+    .client_id = 12,
+    .status = 32,
+    .flags = 36};
 
 struct nfs_file_fields
 {
@@ -77,6 +189,24 @@ struct nfs_file_fields
     u32 res_count;
 };
 
+// nfs_readpage_done/nfs_writeback_done tracepoint 的字段偏移，各内核版本格式不同，
+// 由用户态解析 tracefs 中的 format 文件后写入
+struct nfs_done_layout
+{
+    u16 dev;
+    u16 fileid;
+    u16 count;
+    u16 status;
+    // 以下字段为 0 表示 tracepoint 中不存在
+    u16 offset;
+    u16 arg_count;
+    u16 stable;
+    // 旧内核没有 res_count 字段，status 非负时即为读写字节数
+    u8 count_from_status;
+} __attribute__((packed));
+static volatile const struct nfs_done_layout READ_DONE_LAYOUT;
+static volatile const struct nfs_done_layout WRITE_DONE_LAYOUT;
+
 struct nfs_init_fields
 {
     /* The first 8 bytes is not allowed to read */
@@ -89,6 +219,7 @@ struct nfs_init_fields
     u32 count;
 };
 
+// 记录线程发起 NFS 读写的时间，线程随后同步创建 RPC 请求时取走
 struct
 {
     __uint(type, BPF_MAP_TYPE_HASH);
@@ -97,27 +228,45 @@ struct
     __uint(max_entries, 1024);
 } link_begin SEC(".maps");
 
+// 进行中的 RPC 请求，key 为 client id 和 task id 组合，见 make_task_key
 struct
 {
-    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
     __type(key, u64);
     __type(value, struct rpc_task_info);
-    __uint(max_entries, 1024);
+    __uint(max_entries, 4096);
 } waiting_RPC SEC(".maps");
 
+struct task_done_info
+{
+    u64 task_key;
+    u16 tk_flags;
+};
+
+// 线程上最近结束的 RPC 请求。rpc_task_end 之后同一线程调用 rpc_call_done，
+// 读写完成 tracepoint 通过它找到对应的请求
+struct
+{
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, u32);
+    __type(value, struct task_done_info);
+    __uint(max_entries, 4096);
+} task_done SEC(".maps");
+
+// 记录线程最近一次发起 NFS 读写的文件 key，用于 RPC 错误归属
 struct
 {
     __uint(type, BPF_MAP_TYPE_HASH);
     __type(key, u32);
-    __type(value, u64);
+    __type(value, struct file_key);
     __uint(max_entries, 1024);
-} link_end SEC(".maps");
+} link_file SEC(".maps");
 
 struct raw_metrics *unused_raw_metrics __attribute__((unused));
 struct
 {
     __uint(type, BPF_MAP_TYPE_LRU_HASH);
-    __type(key, u64);
+    __type(key, struct file_key);
     __type(value, struct raw_metrics);
     __uint(max_entries, 4096);
 } io_metrics SEC(".maps");
@@ -127,17 +276,38 @@ struct rpc_task_fields
     int mount_id;
     char pod[100];
     char container[100];
+    // 调用被追踪函数的返回地址，fentry 没有 pt_regs，为 0
     u64 caller_addr;
-    u32 dev_id;
-    u32 file_id;
-    u64 key;
+    // 被追踪函数的地址，fentry 下需要 bpf_get_func_ip，否则为 0
+    u64 func_ip;
+    struct file_key key;
+    // 上一次输出事件后被去重的访问次数
+    u64 suppressed;
+    int stack_id;
+    // 服务端地址，原始的 sockaddr_in/sockaddr_in6，取自 nfs_server->client->cl_xprt
+    u8 server_addr[28];
+    u8 nfs_version;
+    u8 nfs_minor;
+    u8 pad[6];
 };
 
 struct rpc_task_fields *unused_event __attribute__((unused));
 
+#define MAX_STACK_DEPTH 127
+
+// 内核调用栈，key 为 bpf_get_stackid 返回的 stack id，用户态读取后删除
+struct
+{
+    __uint(type, BPF_MAP_TYPE_STACK_TRACE);
+    __uint(key_size, sizeof(u32));
+    __uint(value_size, MAX_STACK_DEPTH * sizeof(u64));
+    __uint(max_entries, 1024);
+} stack_traces SEC(".maps");
+
 struct
 {
-    __uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, EVENT_RINGBUF_SIZE);
 } nfs_trace_map SEC(".maps");
 
 struct metadata
@@ -155,14 +325,24 @@ struct
     __uint(max_entries, 2048);
 } pid_cgroup_map SEC(".maps");
 
+enum path_segment_flag
+{
+    PATH_COMPLETE = 1,
+    // 超过深度限制或文件名过长，路径不完整
+    PATH_TRUNCATED = 2,
+};
+
+// 路径中的一个文件名分片，depth 从文件自身的 0 开始向上递增，
+// 最后一个分片带有 PATH_COMPLETE 标记
 struct path_segment
 {
     u64 file_id;
     u64 dev_id;
     u32 len;
-    u8 is_complete;
-    u8 depth;
-    u8 name[100];
+    u16 depth;
+    u8 chunk;
+    u8 flags;
+    u8 name[PATH_NAME_LEN];
 };
 
 struct path_segment *unused_segment __attribute__((unused));
@@ -179,456 +359,2816 @@ struct dns_event *unused_dns_event __attribute__((unused));
 
 struct
 {
-    __uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, EVENT_RINGBUF_SIZE);
 } dns_events SEC(".maps");
 
 struct
 {
-    __uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, EVENT_RINGBUF_SIZE);
 } path_ringbuf SEC(".maps");
 
-static __always_inline int process_dentry(struct pt_regs *ctx, struct dentry **dentry, struct dentry *root, u64 file_id, u64 dev_id, u8 depth)
+enum rpc_error_op
 {
-    struct dentry *parent;
-    struct qstr dname;
-    struct path_segment segment = {};
-
-    if (bpf_probe_read_kernel(&dname, sizeof(dname), &(*dentry)->d_name) < 0)
-        return -1;
-
-    segment.file_id = file_id;
-    segment.dev_id = dev_id;
-    segment.len = dname.len;
-    segment.depth = depth;
-    segment.is_complete = (*dentry == root || depth >= MAX_PATH_DEPTH - 1) ? 1 : 0;
-
-    if (bpf_probe_read_kernel_str(segment.name, sizeof(segment.name), dname.name) < 0)
-    {
-        return -1;
-    }
-
-    if (cfg->debug_log)
-    {
-        bpf_printk("segment->name: %s\n", segment.name);
-    }
+    RPC_ERROR_OP_UNKNOWN,
+    RPC_ERROR_OP_READ,
+    RPC_ERROR_OP_WRITE,
+};
 
-    bpf_perf_event_output(ctx, &path_ringbuf, BPF_F_CURRENT_CPU, &segment, sizeof(segment));
+// rpc_task_op 根据 NFS 过程名判断读写，NFSv3 和 NFSv4 的读写过程名均为 READ/WRITE
+static __always_inline u8 rpc_task_op(struct rpc_task *task)
+{
+    char prog[4] = {};
+    bpf_probe_read_kernel_str(prog, sizeof(prog), BPF_CORE_READ(task, tk_client, cl_program, name));
+    if (prog[0] != 'n' || prog[1] != 'f' || prog[2] != 's' || prog[3])
+        return RPC_ERROR_OP_UNKNOWN;
+
+    char proc[6] = {};
+    bpf_probe_read_kernel_str(proc, sizeof(proc), BPF_CORE_READ(task, tk_msg.rpc_proc, p_name));
+    if (proc[0] == 'R' && proc[1] == 'E' && proc[2] == 'A' && proc[3] == 'D' && !proc[4])
+        return RPC_ERROR_OP_READ;
+    if (proc[0] == 'W' && proc[1] == 'R' && proc[2] == 'I' && proc[3] == 'T' && proc[4] == 'E' && !proc[5])
+        return RPC_ERROR_OP_WRITE;
+
+    return RPC_ERROR_OP_UNKNOWN;
+}
 
-    if (bpf_probe_read_kernel(&parent, sizeof(parent), &(*dentry)->d_parent) < 0)
-        return -1;
+enum rpc_error_layer
+{
+    RPC_ERROR_LAYER_RPC,
+    RPC_ERROR_LAYER_NFS,
+};
 
-    if (*dentry == parent || *dentry == root)
-        return -1;
+struct rpc_error_event
+{
+    u64 task_id;
+    u64 client_id;
+    struct file_key key;
+    int pid;
+    int status;
+    u8 op;
+    u8 layer;
+    char pod[100];
+    char container[100];
+    char proc[32];
+};
 
-    *dentry = parent;
-    return 0;
-}
+struct rpc_error_event *unused_rpc_error_event __attribute__((unused));
 
-static __always_inline int get_full_path(struct pt_regs *ctx, struct dentry *dentry, struct dentry *root, u64 file_id, u64 dev_id)
+struct
 {
-    u8 depth = 0;
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, EVENT_RINGBUF_SIZE);
+} rpc_error_events SEC(".maps");
 
-#pragma unroll
-    for (int i = 0; i < MAX_PATH_DEPTH; i++)
-    {
-        if (process_dentry(ctx, &dentry, root, file_id, dev_id, depth) < 0)
-            break;
-        depth++;
-    }
+static __always_inline struct file_key make_file_key(u64 dev, u64 fileid)
+{
+    struct file_key key = {
+        .dev_id = dev,
+        .file_id = fileid};
 
-    return 0;
+    return key;
 }
 
-static __always_inline int
-kprobe_nfs_kiocb(struct kiocb *iocb, struct pt_regs *ctx)
+#define FILTER_COMM_LEN 16
+
+// 过滤维度，与 internal/bpf/filter_maps.go 保持一致
+enum filter_type
 {
-    struct rpc_task_fields event = {};
+    FILTER_CGROUP,
+    FILTER_DEV,
+    FILTER_MOUNT,
+    FILTER_PID,
+    FILTER_COMM,
+    FILTER_MAX,
+};
 
-    // 获取 PID
-    event.pid = bpf_get_current_pid_tgid() >> 32;
+enum filter_action
+{
+    FILTER_ALLOW = 1,
+    FILTER_DENY = 2,
+};
 
-    // 使用 pid 到 pid_cgroup_map 中搜索
-    struct metadata *metadata = bpf_map_lookup_elem(&pid_cgroup_map, &event.pid);
-    if (metadata)
-    {
-        bpf_probe_read_kernel(&event.pod, sizeof(event.pod), metadata->pod);
-        bpf_probe_read_kernel(&event.container, sizeof(event.container), metadata->container);
-    }
+// enabled 为 0 时跳过所有过滤；allow[i] 为维度 i 中 allow 条目的数量，大于 0 时未命中的事件被丢弃
+struct filter_state
+{
+    u32 enabled;
+    u32 allow[FILTER_MAX];
+};
 
-    // 从 kiocb 结构体中获取文件路径
-    struct file *file = BPF_CORE_READ(iocb, ki_filp);
-    if (!file)
-        return 0;
+struct comm_key
+{
+    u32 prefixlen;
+    char comm[FILTER_COMM_LEN];
+};
 
-    struct inode *inode = BPF_CORE_READ(file, f_inode);
-    if (!inode)
-        return 0;
+struct
+{
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, u32);
+    __type(value, struct filter_state);
+    __uint(max_entries, 1);
+} filter_state SEC(".maps");
 
-    event.dev_id = BPF_CORE_READ(inode, i_sb, s_dev);
-    event.file_id = BPF_CORE_READ(inode, i_ino);
+struct
+{
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, u64);
+    __type(value, u8);
+    __uint(max_entries, 1024);
+} filter_cgroup SEC(".maps");
 
-    if (cfg->debug_log)
-    {
-        bpf_printk("Details - dev: %llu, file: %llu\n", event.dev_id, event.file_id);
-    }
+struct
+{
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, u32);
+    __type(value, u8);
+    __uint(max_entries, 1024);
+} filter_dev SEC(".maps");
 
-    event.key = (((u64)event.dev_id) << 32) | (event.file_id & 0xFFFFFFFF);
+struct
+{
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, u32);
+    __type(value, u8);
+    __uint(max_entries, 1024);
+} filter_mount SEC(".maps");
 
-    struct path fp = BPF_CORE_READ(file, f_path);
-    if (!fp.mnt)
-        return 0;
+struct
+{
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, u32);
+    __type(value, u8);
+    __uint(max_entries, 1024);
+} filter_pid SEC(".maps");
 
-    struct dentry *de = BPF_CORE_READ(&fp, dentry);
-    if (!de)
-        return 0;
+// 按最长前缀匹配进程名
+struct
+{
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __type(key, struct comm_key);
+    __type(value, u8);
+    __uint(max_entries, 256);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+} filter_comm SEC(".maps");
+
+// 进程上下文中得到的过滤结果，供 rpciod 等非进程上下文中按 owner pid 查询，过滤规则变化时由用户态清空
+struct
+{
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, u32);
+    __type(value, u8);
+    __uint(max_entries, 8192);
+} filter_task_cache SEC(".maps");
 
-    // 获取 stack point
-    if (bpf_probe_read_kernel(&event.caller_addr, sizeof(event.caller_addr), (void *)PT_REGS_SP(ctx)))
-    {
-        return 0;
-    }
+static __always_inline struct filter_state *get_filter_state(void)
+{
+    u32 zero = 0;
+    struct filter_state *state = bpf_map_lookup_elem(&filter_state, &zero);
+    if (!state || !state->enabled)
+        return NULL;
 
-    // 获取 mount id
-    struct vfsmount *vfsmnt = BPF_CORE_READ(&fp, mnt);
-    if (!vfsmnt)
-        return 0;
+    return state;
+}
 
-    struct dentry *rootDentry = BPF_CORE_READ(vfsmnt, mnt_root);
-    if (!rootDentry)
-        return 0;
+// 命中 deny 或者存在 allow 条目但未命中时返回 true，表示事件应被丢弃
+static __always_inline bool filter_match(struct filter_state *state, u32 type, u8 *action)
+{
+    if (action)
+        return *action == FILTER_DENY;
 
-    // 获取文件的完整路径
-    get_full_path(ctx, de, rootDentry, event.file_id, event.dev_id);
+    if (type >= FILTER_MAX)
+        return false;
 
-    struct mount *mnt = container_of(vfsmnt, struct mount, mnt);
-    if (!mnt)
-        return 0;
+    return state->allow[type] > 0;
+}
 
-    event.mount_id = BPF_CORE_READ(mnt, mnt_id);
+static __always_inline bool filter_task(struct filter_state *state, u32 pid)
+{
+    if (filter_match(state, FILTER_PID, bpf_map_lookup_elem(&filter_pid, &pid)))
+        return true;
 
-    // 输出件到 perf 事件数组
-    bpf_perf_event_output(ctx, &nfs_trace_map, BPF_F_CURRENT_CPU, &event, sizeof(event));
+    u64 cgroup_id = bpf_get_current_cgroup_id();
+    if (filter_match(state, FILTER_CGROUP, bpf_map_lookup_elem(&filter_cgroup, &cgroup_id)))
+        return true;
 
-    return BPF_OK;
+    struct comm_key key = {.prefixlen = FILTER_COMM_LEN * 8};
+    bpf_get_current_comm(&key.comm, sizeof(key.comm));
+    return filter_match(state, FILTER_COMM, bpf_map_lookup_elem(&filter_comm, &key));
 }
 
-#define CONCAT(a, b) a##b
-#define EXPAND_AND_CONCAT(a, b) CONCAT(a, b)
-
-#ifdef HAS_KPROBE_MULTI
-#define NFSTRACE_KPROBE_TYPE "kprobe.multi"
-#define NFSTRACE_HAS_GET_FUNC_IP true
-#else
-#define NFSTRACE_KPROBE_TYPE "kprobe"
-#define NFSTRACE_HAS_GET_FUNC_IP false
-#endif /* HAS_KPROBE_MULTI */
+// 检查当前进程，只能在进程上下文中调用
+static __always_inline bool filter_current(void)
+{
+    struct filter_state *state = get_filter_state();
+    if (!state)
+        return false;
 
-#define NFSTRACE_ADD_KPROBE(X)                                                  \
-    SEC(NFSTRACE_KPROBE_TYPE "/skb-" #X)                                        \
-    int kprobe_skb_##X(struct pt_regs *ctx)                                     \
-    {                                                                           \
-        struct RPC_TASK_VAR *hdr = (struct RPC_TASK_VAR *)PT_REGS_PARM##X(ctx); \
-        return EXPAND_AND_CONCAT(kprobe_nfs_, RPC_TASK_VAR)(hdr, ctx);          \
-    }
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    u8 filtered = filter_task(state, pid);
+    bpf_map_update_elem(&filter_task_cache, &pid, &filtered, BPF_ANY);
 
-NFSTRACE_ADD_KPROBE(1)
-NFSTRACE_ADD_KPROBE(2)
-NFSTRACE_ADD_KPROBE(3)
-NFSTRACE_ADD_KPROBE(4)
-NFSTRACE_ADD_KPROBE(5)
+    return filtered;
+}
 
-// tracepoint 增加 nfs_readpage_done/nfs_writeback_done 挂载函数用于统计 IOPS
-SEC("tracepoint/nfs/nfs_initiate_read")
-int nfs_init_read(struct nfs_init_fields *ctx)
+// 在非进程上下文中按 rpc_task 的 owner pid 检查，cgroup 和进程名使用进程上下文中缓存的结果
+static __always_inline bool filter_owner(u32 pid)
 {
-    u32 pid = bpf_get_current_pid_tgid() >> 32;
-    u32 tid = (u32)bpf_get_current_pid_tgid();
-    u64 timestamp = bpf_ktime_get_ns();
+    struct filter_state *state = get_filter_state();
+    if (!state)
+        return false;
 
-    if (cfg->debug_log)
-    {
-        bpf_printk("nfs_init_read: %llu, pid: %u, tid: %u\n", timestamp, pid, tid);
-    }
+    u8 *filtered = bpf_map_lookup_elem(&filter_task_cache, &pid);
+    if (filtered)
+        return *filtered;
 
-    bpf_map_update_elem(&link_begin, &pid, &timestamp, BPF_ANY);
+    // 没有缓存时无法判断 cgroup 和进程名，存在对应的 allow 规则时视为未命中
+    if (state->allow[FILTER_CGROUP] > 0 || state->allow[FILTER_COMM] > 0)
+        return true;
 
-    return 0;
+    return filter_match(state, FILTER_PID, bpf_map_lookup_elem(&filter_pid, &pid));
 }
 
-SEC("tracepoint/nfs/nfs_initiate_write")
-int nfs_init_write(struct nfs_init_fields *ctx)
+static __always_inline bool filter_device(u32 dev)
 {
-    u32 pid = bpf_get_current_pid_tgid() >> 32;
-    u32 tid = (u32)bpf_get_current_pid_tgid();
-    u64 timestamp = bpf_ktime_get_ns();
+    struct filter_state *state = get_filter_state();
+    if (!state)
+        return false;
 
-    if (cfg->debug_log)
-    {
-        bpf_printk("nfs_init_write: %llu, pid: %u, tid: %u\n", timestamp, pid, tid);
-    }
+    return filter_match(state, FILTER_DEV, bpf_map_lookup_elem(&filter_dev, &dev));
+}
 
-    bpf_map_update_elem(&link_begin, &pid, &timestamp, BPF_ANY);
+static __always_inline bool filter_mount_id(u32 mnt_id)
+{
+    struct filter_state *state = get_filter_state();
+    if (!state)
+        return false;
 
-    return 0;
+    return filter_match(state, FILTER_MOUNT, bpf_map_lookup_elem(&filter_mount, &mnt_id));
 }
 
-SEC("tracepoint/sunrpc/rpc_task_begin")
-int rpc_task_begin(struct rpc_task_state *ctx)
+// 输出非零的 RPC/NFS 状态码，status 为内核中的负 errno 或 -NFS4ERR_*
+static __always_inline void submit_rpc_error(void *ctx, u64 task_id, u64 client_id, int pid, int status,
+                                             struct file_key key, u8 op, u8 layer, const char *proc)
 {
-    u32 pid = bpf_get_current_pid_tgid() >> 32;
-    u32 tid = (u32)bpf_get_current_pid_tgid();
-    u64 rpc_task_id = (u64)ctx->task_id;
+    struct rpc_error_event event = {};
+
+    event.task_id = task_id;
+    event.client_id = client_id;
+    event.pid = pid;
+    event.status = status;
+    event.key = key;
+    event.op = op;
+    event.layer = layer;
+
+    u64 pid_key = (u64)pid;
+    struct metadata *metadata = bpf_map_lookup_elem(&pid_cgroup_map, &pid_key);
+    if (metadata)
+    {
+        bpf_probe_read_kernel(&event.pod, sizeof(event.pod), metadata->pod);
+        bpf_probe_read_kernel(&event.container, sizeof(event.container), metadata->container);
+    }
 
-    if (cfg->debug_log)
+    if (proc)
     {
-        bpf_printk("rpc_task_begin: %llu, pid: %u, tid: %u\n", rpc_task_id, pid, tid);
+        bpf_probe_read_kernel_str(&event.proc, sizeof(event.proc), proc);
     }
 
-    u64 *timestamp = bpf_map_lookup_elem(&link_begin, &pid);
-    if (timestamp)
+    if (cfg->debug_log)
     {
-        struct rpc_task_info info = {
-            .timestamp = *timestamp,
-            .tid = tid,
-            .pid = pid};
-        bpf_map_update_elem(&waiting_RPC, &rpc_task_id, &info, BPF_ANY);
+        bpf_printk("rpc_error: task: %llu, pid: %d, status: %d\n", task_id, pid, status);
     }
 
-    return 0;
+    submit_event(ctx, &rpc_error_events, EVENT_CHAN_RPC_ERROR, &event, sizeof(event));
 }
 
-SEC("tracepoint/sunrpc/rpc_task_end")
-int rpc_task_done(struct rpc_task_state *ctx)
+enum io_flag
 {
-    u64 rpc_task_id = (u64)ctx->task_id;
+    IO_FLAG_ASYNC = 1,
+    IO_FLAG_DIRECT = 2,
+    // 写请求要求服务端同步落盘（DATA_SYNC/FILE_SYNC）
+    IO_FLAG_STABLE = 4,
+};
 
-    if (cfg->debug_log)
+// 读写请求所处的阶段，访问模式按发起顺序判断，请求大小分布在完成时统计
+enum io_phase
+{
+    IO_PHASE_DONE,
+    IO_PHASE_SUBMIT,
+};
+
+// 单个读写请求的位置和大小，用于分析文件的访问模式
+struct io_event
+{
+    struct file_key key;
+    u64 offset;
+    u64 latency;
+    u32 req_count;
+    u32 res_count;
+    int pid;
+    int status;
+    u8 op;
+    u8 flags;
+    u8 phase;
+    char pod[100];
+    char container[100];
+};
+
+struct io_event *unused_io_event __attribute__((unused));
+
+struct
+{
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, EVENT_RINGBUF_SIZE);
+} io_events SEC(".maps");
+
+static __always_inline void submit_io_event(void *ctx, struct file_key key, int pid, int status, u8 op, u8 phase,
+                                            u64 offset, u32 req_count, u32 res_count, u8 flags, u64 latency)
+{
+    struct io_event event = {};
+
+    event.key = key;
+    event.offset = offset;
+    event.latency = latency;
+    event.req_count = req_count;
+    event.res_count = res_count;
+    event.pid = pid;
+    event.status = status;
+    event.op = op;
+    event.flags = flags;
+    event.phase = phase;
+
+    u64 pid_key = (u64)pid;
+    struct metadata *metadata = bpf_map_lookup_elem(&pid_cgroup_map, &pid_key);
+    if (metadata)
+    {
+        bpf_probe_read_kernel(&event.pod, sizeof(event.pod), metadata->pod);
+        bpf_probe_read_kernel(&event.container, sizeof(event.container), metadata->container);
+    }
+
+    submit_event(ctx, &io_events, EVENT_CHAN_IO, &event, sizeof(event));
+}
+
+static __always_inline int process_dentry(void *ctx, struct dentry **dentry, struct dentry *root, u64 file_id, u64 dev_id, u16 depth)
+{
+    struct dentry *parent;
+    struct qstr dname;
+    struct path_segment segment = {};
+
+    if (bpf_probe_read_kernel(&dname, sizeof(dname), &(*dentry)->d_name) < 0)
+        return -1;
+
+    if (bpf_probe_read_kernel(&parent, sizeof(parent), &(*dentry)->d_parent) < 0)
+        return -1;
+
+    // 到达挂载点根目录或文件系统根目录时结束，达到深度限制时标记为截断
+    bool done = *dentry == root || *dentry == parent;
+    u8 flags = 0;
+    if (!done && (depth + 1 >= cfg->max_path_depth || depth + 1 >= PATH_WALK_DEPTH))
+        flags = PATH_COMPLETE | PATH_TRUNCATED;
+    else if (done)
+        flags = PATH_COMPLETE;
+
+    if (dname.len > MAX_NAME_CHUNKS * (PATH_NAME_LEN - 1))
+        flags |= PATH_TRUNCATED;
+
+    segment.file_id = file_id;
+    segment.dev_id = dev_id;
+    segment.len = dname.len;
+    segment.depth = depth;
+
+    for (int i = 0; i < MAX_NAME_CHUNKS; i++)
+    {
+        u32 offset = i * (PATH_NAME_LEN - 1);
+        bool last = i == MAX_NAME_CHUNKS - 1 || offset + PATH_NAME_LEN - 1 >= dname.len;
+
+        segment.chunk = i;
+        segment.flags = last ? flags : 0;
+        if (bpf_probe_read_kernel_str(segment.name, sizeof(segment.name), dname.name + offset) < 0)
+            return -1;
+
+        if (cfg->debug_log)
+        {
+            bpf_printk("segment->name: %s\n", segment.name);
+        }
+
+        submit_event(ctx, &path_ringbuf, EVENT_CHAN_PATH, &segment, sizeof(segment));
+
+        if (last)
+            break;
+    }
+
+    if (flags & PATH_COMPLETE)
+        return -1;
+
+    *dentry = parent;
+    return 0;
+}
+
+static __always_inline int get_full_path(void *ctx, struct dentry *dentry, struct dentry *root, u64 file_id, u64 dev_id)
+{
+    // 有界循环需要 5.3+ 内核，旧内核使用展开的版本
+#ifdef PATH_WALK_UNROLL
+#pragma unroll
+#else
+#pragma clang loop unroll(disable)
+#endif
+    for (u16 depth = 0; depth < PATH_WALK_DEPTH; depth++)
+    {
+        if (process_dentry(ctx, &dentry, root, file_id, dev_id, depth) < 0)
+            break;
+    }
+
+    return 0;
+}
+
+struct seen_key
+{
+    struct file_key key;
+    u32 pid;
+    u32 pad;
+};
+
+struct seen_value
+{
+    u64 last_emit;
+    u64 suppressed;
+};
+
+// 最近输出过事件的 (pid, 文件)，用于在内核中对文件访问事件去重
+struct
+{
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, struct seen_key);
+    __type(value, struct seen_value);
+    __uint(max_entries, 16384);
+} seen_files SEC(".maps");
+
+// 被去重的访问总数，进程退出或 seen_files 淘汰时 seen_value 中的计数会丢失，由用户态导出为指标
+struct
+{
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __type(key, u32);
+    __type(value, u64);
+    __uint(max_entries, 1);
+} suppressed_events SEC(".maps");
+
+// should_emit 判断本次访问是否需要输出事件，不需要时累加被去重的次数
+static __always_inline bool should_emit(struct rpc_task_fields *event)
+{
+    if (!cfg->dedup_interval)
+        return true;
+
+    struct seen_key key = {.key = event->key, .pid = event->pid};
+    u64 now = bpf_ktime_get_ns();
+    struct seen_value *seen = bpf_map_lookup_elem(&seen_files, &key);
+    if (seen && now - seen->last_emit < cfg->dedup_interval)
+    {
+        __sync_fetch_and_add(&seen->suppressed, 1);
+
+        u32 zero = 0;
+        u64 *total = bpf_map_lookup_elem(&suppressed_events, &zero);
+        if (total)
+            *total += 1;
+        return false;
+    }
+
+    struct seen_value value = {.last_emit = now};
+    if (seen)
+        event->suppressed = seen->suppressed;
+    bpf_map_update_elem(&seen_files, &key, &value, BPF_ANY);
+
+    return true;
+}
+
+// key: 挂载点 s_dev, value: rpc_xprt 指针，用于关联挂载与传输层。
+// 文件访问时总会更新，不依赖读写完成或传输层统计是否启用
+struct
+{
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, u32);
+    __type(value, u64);
+    __uint(max_entries, 256);
+} dev_xprt SEC(".maps");
+
+// fill_server_info 从 nfs_server 的 RPC 客户端读取服务端地址和 NFS 版本
+static __always_inline void fill_server_info(struct rpc_task_fields *event, struct super_block *sb)
+{
+    if (BPF_CORE_READ(sb, s_magic) != NFS_SUPER_MAGIC)
+        return;
+
+    struct nfs_server *server = BPF_CORE_READ(sb, s_fs_info);
+    if (!server)
+        return;
+
+    struct rpc_clnt *clnt = BPF_CORE_READ(server, client);
+    if (!clnt)
+        return;
+
+    event->nfs_version = BPF_CORE_READ(clnt, cl_vers);
+    event->nfs_minor = BPF_CORE_READ(server, nfs_client, cl_minorversion);
+
+    struct rpc_xprt *xprt = BPF_CORE_READ(clnt, cl_xprt);
+    if (!xprt)
+        return;
+
+    bpf_probe_read_kernel(&event->server_addr, sizeof(event->server_addr), &xprt->addr);
+
+    u32 dev_id = event->key.dev_id;
+    u64 xprt_id = (u64)xprt;
+    bpf_map_update_elem(&dev_xprt, &dev_id, &xprt_id, BPF_ANY);
+}
+
+// track_task_dev 从 NFS 读写请求记录挂载与传输层的对应关系，读写请求的 tk_calldata 为 nfs_pgio_header
+static __always_inline void track_task_dev(struct rpc_task *task, struct rpc_xprt *xprt)
+{
+    if (rpc_task_op(task) == RPC_ERROR_OP_UNKNOWN)
+        return;
+
+    struct nfs_pgio_header *hdr = BPF_CORE_READ(task, tk_calldata);
+    u32 dev_id = BPF_CORE_READ(hdr, inode, i_sb, s_dev);
+    if (!dev_id)
+        return;
+
+    u64 xprt_id = (u64)xprt;
+    bpf_map_update_elem(&dev_xprt, &dev_id, &xprt_id, BPF_ANY);
+}
+
+// 被追踪函数通过进程和设备过滤后的命中次数，key 为函数地址（kprobe 下为探测点地址），用户态按符号聚合。
+// fentry 模式下内核不支持 bpf_get_func_ip 时无法区分函数，不统计
+struct
+{
+    __uint(type, BPF_MAP_TYPE_LRU_PERCPU_HASH);
+    __type(key, u64);
+    __type(value, u64);
+    __uint(max_entries, 8192);
+} probe_hits SEC(".maps");
+
+static __always_inline void count_probe_hit(u64 ip)
+{
+    u64 *hits = bpf_map_lookup_elem(&probe_hits, &ip);
+    if (hits)
+    {
+        (*hits)++;
+        return;
+    }
+
+    u64 one = 1;
+    bpf_map_update_elem(&probe_hits, &ip, &one, BPF_NOEXIST);
+}
+
+static __always_inline int
+kprobe_nfs_kiocb(struct kiocb *iocb, void *ctx, bool fentry)
+{
+    struct rpc_task_fields event = {};
+
+    // 获取被追踪函数的地址，fentry 没有 pt_regs，需要 5.15+ 的 bpf_get_func_ip
+    u64 ip = 0;
+    if (fentry)
+    {
+        if (cfg->has_func_ip)
+            ip = bpf_get_func_ip(ctx);
+    }
+    else
+    {
+        ip = PT_REGS_IP((struct pt_regs *)ctx);
+    }
+
+    // 在读取数据和遍历路径之前过滤
+    if (filter_current())
+        return 0;
+
+    // 获取 PID
+    event.pid = bpf_get_current_pid_tgid() >> 32;
+
+    // 使用 pid 到 pid_cgroup_map 中搜索
+    struct metadata *metadata = bpf_map_lookup_elem(&pid_cgroup_map, &event.pid);
+    if (metadata)
+    {
+        bpf_probe_read_kernel(&event.pod, sizeof(event.pod), metadata->pod);
+        bpf_probe_read_kernel(&event.container, sizeof(event.container), metadata->container);
+    }
+
+    // 从 kiocb 结构体中获取文件路径
+    struct file *file = BPF_CORE_READ(iocb, ki_filp);
+    if (!file)
+        return 0;
+
+    struct inode *inode = BPF_CORE_READ(file, f_inode);
+    if (!inode)
+        return 0;
+
+    event.key = make_file_key(BPF_CORE_READ(inode, i_sb, s_dev), BPF_CORE_READ(inode, i_ino));
+
+    if (filter_device(event.key.dev_id))
+        return 0;
+
+    // 命中计数放在开销较低的过滤之后，避免被过滤的 IO 也更新 map
+    if (ip)
+        count_probe_hit(ip);
+
+    fill_server_info(&event, BPF_CORE_READ(inode, i_sb));
+
+    if (cfg->debug_log)
+    {
+        bpf_printk("Details - dev: %llu, file: %llu\n", event.key.dev_id, event.key.file_id);
+    }
+
+    struct path fp = BPF_CORE_READ(file, f_path);
+    if (!fp.mnt)
+        return 0;
+
+    struct dentry *de = BPF_CORE_READ(&fp, dentry);
+    if (!de)
+        return 0;
+
+    event.func_ip = ip;
+    if (!fentry)
+        BPF_KPROBE_READ_RET_IP(event.caller_addr, (struct pt_regs *)ctx);
+
+    // 获取 mount id
+    struct vfsmount *vfsmnt = BPF_CORE_READ(&fp, mnt);
+    if (!vfsmnt)
+        return 0;
+
+    struct dentry *rootDentry = BPF_CORE_READ(vfsmnt, mnt_root);
+    if (!rootDentry)
+        return 0;
+
+    struct mount *mnt = container_of(vfsmnt, struct mount, mnt);
+    if (!mnt)
+        return 0;
+
+    event.mount_id = BPF_CORE_READ(mnt, mnt_id);
+    if (filter_mount_id(event.mount_id))
+        return 0;
+
+    // 间隔内重复访问的文件只计数，不再遍历路径和输出事件。在 mount id 过滤之后判断，
+    // 被过滤的访问不会更新 seen_files，也不会计入被去重的次数
+    if (!should_emit(&event))
+        return 0;
+
+    // 记录完整的内核调用栈，用于区分 read/mmap/splice/direct IO 等访问路径。
+    // 在所有过滤之后获取，相同的调用栈复用同一个 stack id，避免占满 stack_traces
+    event.stack_id = -1;
+    if (cfg->stack_trace)
+        event.stack_id = bpf_get_stackid(ctx, &stack_traces, BPF_F_REUSE_STACKID);
+
+    // 获取文件的完整路径
+    get_full_path(ctx, de, rootDentry, event.key.file_id, event.key.dev_id);
+
+    // 输出事件到 ringbuf 或 perf 事件数组
+    submit_event(ctx, &nfs_trace_map, EVENT_CHAN_NFS_TRACE, &event, sizeof(event));
+
+    return BPF_OK;
+}
+
+#define CONCAT(a, b) a##b
+#define EXPAND_AND_CONCAT(a, b) CONCAT(a, b)
+
+#ifdef HAS_KPROBE_MULTI
+#define NFSTRACE_KPROBE_TYPE "kprobe.multi"
+#define NFSTRACE_HAS_GET_FUNC_IP true
+#else
+#define NFSTRACE_KPROBE_TYPE "kprobe"
+#define NFSTRACE_HAS_GET_FUNC_IP false
+#endif /* HAS_KPROBE_MULTI */
+
+#define NFSTRACE_ADD_KPROBE(X)                                                  \
+    SEC(NFSTRACE_KPROBE_TYPE "/skb-" #X)                                        \
+    int kprobe_skb_##X(struct pt_regs *ctx)                                     \
+    {                                                                           \
+        struct RPC_TASK_VAR *hdr = (struct RPC_TASK_VAR *)PT_REGS_PARM##X(ctx); \
+        return EXPAND_AND_CONCAT(kprobe_nfs_, RPC_TASK_VAR)(hdr, ctx, false);   \
+    }
+
+NFSTRACE_ADD_KPROBE(1)
+NFSTRACE_ADD_KPROBE(2)
+NFSTRACE_ADD_KPROBE(3)
+NFSTRACE_ADD_KPROBE(4)
+NFSTRACE_ADD_KPROBE(5)
+
+// fentry 程序在用户态按目标函数重写 AttachTo 后单独加载，参数位置与 kprobe_skb_X 一致
+#define NFSTRACE_ADD_FENTRY(X)                                                     \
+    SEC("fentry/skb-" #X)                                                          \
+    int fentry_skb_##X(u64 *ctx)                                                   \
+    {                                                                              \
+        struct RPC_TASK_VAR *hdr = (struct RPC_TASK_VAR *)ctx[X - 1];              \
+        return EXPAND_AND_CONCAT(kprobe_nfs_, RPC_TASK_VAR)(hdr, ctx, true);       \
+    }
+
+NFSTRACE_ADD_FENTRY(1)
+NFSTRACE_ADD_FENTRY(2)
+NFSTRACE_ADD_FENTRY(3)
+NFSTRACE_ADD_FENTRY(4)
+NFSTRACE_ADD_FENTRY(5)
+
+// 记录线程发起 NFS 读写的时间，读写完成见 nfs_read_done/nfs_tp_done
+SEC("tracepoint/nfs/nfs_initiate_read")
+int nfs_init_read(struct nfs_init_fields *ctx)
+{
+    if (filter_current() || filter_device(ctx->dev))
+        return 0;
+
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    u32 tid = (u32)bpf_get_current_pid_tgid();
+    u64 timestamp = bpf_ktime_get_ns();
+
+    if (cfg->debug_log)
+    {
+        bpf_printk("nfs_init_read: %llu, pid: %u, tid: %u\n", timestamp, pid, tid);
+    }
+
+    bpf_map_update_elem(&link_begin, &tid, &timestamp, BPF_ANY);
+
+    struct file_key key = make_file_key(ctx->dev, ctx->fileid);
+    bpf_map_update_elem(&link_file, &tid, &key, BPF_ANY);
+
+    // 按发起顺序输出请求的位置，完成顺序受并发影响，无法用于判断访问模式
+    if (cfg->io_events)
+        submit_io_event(ctx, key, pid, 0, RPC_ERROR_OP_READ, IO_PHASE_SUBMIT, ctx->offset, ctx->count, 0, 0, 0);
+
+    return 0;
+}
+
+SEC("tracepoint/nfs/nfs_initiate_write")
+int nfs_init_write(struct nfs_init_fields *ctx)
+{
+    if (filter_current() || filter_device(ctx->dev))
+        return 0;
+
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    u32 tid = (u32)bpf_get_current_pid_tgid();
+    u64 timestamp = bpf_ktime_get_ns();
+
+    if (cfg->debug_log)
+    {
+        bpf_printk("nfs_init_write: %llu, pid: %u, tid: %u\n", timestamp, pid, tid);
+    }
+
+    bpf_map_update_elem(&link_begin, &tid, &timestamp, BPF_ANY);
+
+    struct file_key key = make_file_key(ctx->dev, ctx->fileid);
+    bpf_map_update_elem(&link_file, &tid, &key, BPF_ANY);
+
+    // 按发起顺序输出请求的位置，完成顺序受并发影响，无法用于判断访问模式
+    if (cfg->io_events)
+        submit_io_event(ctx, key, pid, 0, RPC_ERROR_OP_WRITE, IO_PHASE_SUBMIT, ctx->offset, ctx->count, 0, 0, 0);
+
+    return 0;
+}
+
+// task id 只在同一个 rpc_clnt 内唯一，和 client id 组合后作为请求的 key
+static __always_inline u64 make_task_key(u64 client_id, u64 task_id)
+{
+    return (client_id << 32) | (task_id & 0xffffffff);
+}
+
+// 线程发起 NFS 读写后同步创建 RPC 请求，将发起时间从线程转移到请求上。
+// 异步请求后续唤醒时 link_begin 已被取走，BPF_NOEXIST 保证不会覆盖请求的开始时间
+static __always_inline void track_rpc_task(u64 task_key, u32 pid, u32 tid)
+{
+    u64 *timestamp = bpf_map_lookup_elem(&link_begin, &tid);
+    if (!timestamp)
+        return;
+
+    struct rpc_task_info info = {
+        .timestamp = *timestamp,
+        .tid = tid,
+        .pid = pid};
+
+    struct file_key *key = bpf_map_lookup_elem(&link_file, &tid);
+    if (key)
+        info.key = *key;
+
+    bpf_map_update_elem(&waiting_RPC, &task_key, &info, BPF_NOEXIST);
+    bpf_map_delete_elem(&link_begin, &tid);
+}
+
+struct request_latency
+{
+    u64 total;
+    u64 queue;
+    u64 rtt;
+};
+
+// 计算单个请求的延迟：total 为发起读写到完成，queue 为 RPC 开始到最后一次发送，
+// rtt 为最后一次发送到收到服务端回复。未记录发起时间时 total 以 RPC 开始时间计算
+static __always_inline void get_request_latency(struct rpc_task *task, u64 now, struct request_latency *lat)
+{
+    u64 tk_start = BPF_CORE_READ(task, tk_start);
+    u64 task_key = make_task_key(BPF_CORE_READ(task, tk_client, cl_clid), BPF_CORE_READ(task, tk_pid));
+
+    u64 start = tk_start;
+    struct rpc_task_info *info = bpf_map_lookup_elem(&waiting_RPC, &task_key);
+    if (info)
+    {
+        start = info->timestamp;
+        bpf_map_delete_elem(&waiting_RPC, &task_key);
+    }
+
+    if (start && now > start)
+        lat->total = now - start;
+
+    struct rpc_rqst *rqst = BPF_CORE_READ(task, tk_rqstp);
+    if (!rqst)
+        return;
+
+    u64 xtime = BPF_CORE_READ(rqst, rq_xtime);
+    if (tk_start && xtime > tk_start)
+        lat->queue = xtime - tk_start;
+    lat->rtt = BPF_CORE_READ(rqst, rq_rtt);
+}
+
+SEC("tracepoint/sunrpc/rpc_task_begin")
+int rpc_task_begin(void *ctx)
+{
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    u32 tid = (u32)bpf_get_current_pid_tgid();
+    u64 rpc_task_id = tp_read_u32(ctx, RPC_TASK_LAYOUT.task_id);
+    u32 client_id = tp_read_u32(ctx, RPC_TASK_LAYOUT.client_id);
+
+    if (cfg->debug_log)
+    {
+        bpf_printk("rpc_task_begin: %llu, pid: %u, tid: %u\n", rpc_task_id, pid, tid);
+    }
+
+    track_rpc_task(make_task_key(client_id, rpc_task_id), pid, tid);
+
+    return 0;
+}
+
+SEC("tracepoint/sunrpc/rpc_task_end")
+int rpc_task_done(void *ctx)
+{
+    u64 rpc_task_id = tp_read_u32(ctx, RPC_TASK_LAYOUT.task_id);
+    u32 client_id = tp_read_u32(ctx, RPC_TASK_LAYOUT.client_id);
+    int status = tp_read_u32(ctx, RPC_TASK_LAYOUT.status);
+
+    if (cfg->debug_log)
+    {
+        bpf_printk("rpc_task_done: %llu\n", rpc_task_id);
+    }
+
+    u64 task_key = make_task_key(client_id, rpc_task_id);
+    u32 tid = (u32)bpf_get_current_pid_tgid();
+    struct task_done_info done = {.task_key = task_key, .tk_flags = tp_read_u16(ctx, RPC_TASK_LAYOUT.flags)};
+    bpf_map_update_elem(&task_done, &tid, &done, BPF_ANY);
+
+    // waiting_RPC 中只有 NFS 读写发起的请求，其错误由 NFS 层按读写输出，这里跳过避免重复计数。
+    // tracepoint 中没有过程名，其余请求的 op 为 unknown
+    struct rpc_task_info *info = bpf_map_lookup_elem(&waiting_RPC, &task_key);
+    if (status < 0 && !info)
+    {
+        struct file_key key = {};
+        submit_rpc_error(ctx, rpc_task_id, client_id, bpf_get_current_pid_tgid() >> 32, status, key,
+                         RPC_ERROR_OP_UNKNOWN, RPC_ERROR_LAYER_RPC, NULL);
+    }
+
+    return 0;
+}
+
+SEC("kprobe/rpc_make_runnable")
+int rpc_execute(struct pt_regs *regs)
+{
+    struct rpc_task *task;
+    task = (struct rpc_task *)PT_REGS_PARM2(regs);
+
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    u32 tid = (u32)bpf_get_current_pid_tgid();
+    u64 rpc_task_id = BPF_CORE_READ(task, tk_pid);
+
+    if (cfg->debug_log)
+    {
+        bpf_printk("rpc_execute: %llu, pid: %u, tid: %u\n", rpc_task_id, pid, tid);
+    }
+
+    track_rpc_task(make_task_key(BPF_CORE_READ(task, tk_client, cl_clid), rpc_task_id), pid, tid);
+
+    return 0;
+}
+
+SEC("kprobe/rpc_exit_task")
+int rpc_exit_task(struct pt_regs *regs)
+{
+    struct rpc_task *task;
+    task = (struct rpc_task *)PT_REGS_PARM1(regs);
+
+    u64 rpc_task_id = BPF_CORE_READ(task, tk_pid);
+
+    if (cfg->debug_log)
+    {
+        bpf_printk("rpc_exit_task: %llu\n", rpc_task_id);
+    }
+
+    u64 client_id = BPF_CORE_READ(task, tk_client, cl_clid);
+    u64 task_key = make_task_key(client_id, rpc_task_id);
+    u32 tid = (u32)bpf_get_current_pid_tgid();
+    struct task_done_info done = {.task_key = task_key, .tk_flags = BPF_CORE_READ(task, tk_flags)};
+    bpf_map_update_elem(&task_done, &tid, &done, BPF_ANY);
+
+    // NFS 读写请求的错误由 NFS 层输出，这里跳过避免重复计数，其余请求按过程名输出
+    int status = BPF_CORE_READ(task, tk_status);
+    u8 op = rpc_task_op(task);
+    if (status < 0 && op == RPC_ERROR_OP_UNKNOWN)
+    {
+        struct rpc_task_info *info = bpf_map_lookup_elem(&waiting_RPC, &task_key);
+        int pid = info ? info->pid : bpf_get_current_pid_tgid() >> 32;
+        struct file_key key = {};
+        if (info)
+            key = info->key;
+        const char *proc = BPF_CORE_READ(task, tk_msg.rpc_proc, p_name);
+        submit_rpc_error(regs, rpc_task_id, client_id, pid, status, key, op, RPC_ERROR_LAYER_RPC, proc);
+    }
+
+    return 0;
+}
+
+enum xprt_event_type
+{
+    XPRT_EVENT_RETRANSMIT,
+    XPRT_EVENT_CONNECT,
+    XPRT_EVENT_CONNECT_ERROR,
+    XPRT_EVENT_DISCONNECT,
+    XPRT_EVENT_BACKLOG_WAIT,
+    XPRT_EVENT_SENDING_WAIT,
+};
+
+struct xprt_stats
+{
+    u64 retransmits;
+    u64 connects;
+    u64 connect_errors;
+    u64 disconnects;
+    u64 backlog_waits;
+    u64 sending_waits;
+    // 首个使用该传输层的 rpc_clnt，与 addr 一起作为导出指标的标识，不导出内核指针
+    u32 client_id;
+    u8 pad[4];
+    char addr[48];
+};
+
+struct xprt_stats *unused_xprt_stats __attribute__((unused));
+
+struct xprt_event
+{
+    u64 xprt;
+    u32 client_id;
+    u32 task_id;
+    int status;
+    u8 type;
+    char addr[48];
+};
+
+struct xprt_event *unused_xprt_event __attribute__((unused));
+
+struct rpc_status_fields
+{
+    /* The first 8 bytes is not allowed to read */
+    unsigned long pad;
+
+    unsigned int task_id;
+    unsigned int client_id;
+    int status;
+};
+
+// key: rpc_xprt 指针
+struct
+{
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, u64);
+    __type(value, struct xprt_stats);
+    __uint(max_entries, 256);
+} xprt_metrics SEC(".maps");
+
+// key: rpc_clnt cl_clid, value: rpc_xprt 指针
+struct
+{
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, u32);
+    __type(value, u64);
+    __uint(max_entries, 1024);
+} clnt_xprt SEC(".maps");
+
+struct reserve_xprt_arg
+{
+    u64 xprt;
+    u64 task;
+};
+
+// key: {tgid, pid}, value: xprt_reserve_xprt 的 rpc_xprt 和 rpc_task 参数
+struct
+{
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, u64);
+    __type(value, struct reserve_xprt_arg);
+    __uint(max_entries, 1024);
+} reserve_xprt_args SEC(".maps");
+
+struct
+{
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, EVENT_RINGBUF_SIZE);
+} xprt_events SEC(".maps");
+
+static __always_inline struct xprt_stats *get_xprt_stats(struct rpc_xprt *xprt, u32 client_id)
+{
+    u64 id = (u64)xprt;
+    struct xprt_stats *stats = bpf_map_lookup_elem(&xprt_metrics, &id);
+    if (stats)
+    {
+        if (!stats->client_id && client_id)
+            stats->client_id = client_id;
+        return stats;
+    }
+
+    struct xprt_stats new_stats = {.client_id = client_id};
+    const char *addr = BPF_CORE_READ(xprt, address_strings[RPC_DISPLAY_ADDR]);
+    if (addr)
+        bpf_probe_read_kernel_str(&new_stats.addr, sizeof(new_stats.addr), addr);
+
+    bpf_map_update_elem(&xprt_metrics, &id, &new_stats, BPF_NOEXIST);
+    return bpf_map_lookup_elem(&xprt_metrics, &id);
+}
+
+static __always_inline void submit_xprt_event(void *ctx, struct rpc_xprt *xprt, struct xprt_stats *stats,
+                                              u32 client_id, u32 task_id, int status, u8 type)
+{
+    struct xprt_event event = {};
+
+    event.xprt = (u64)xprt;
+    event.client_id = client_id;
+    event.task_id = task_id;
+    event.status = status;
+    event.type = type;
+    bpf_probe_read_kernel(&event.addr, sizeof(event.addr), stats->addr);
+
+    if (cfg->debug_log)
+    {
+        bpf_printk("xprt_event: type: %d, task: %u, status: %d\n", type, task_id, status);
+    }
+
+    submit_event(ctx, &xprt_events, EVENT_CHAN_XPRT, &event, sizeof(event));
+}
+
+// rq_ntrans 为请求已发送次数，大于 0 说明本次发送为重传
+SEC("kprobe/xprt_transmit")
+int kb_xprt_transmit(struct pt_regs *regs)
+{
+    struct rpc_task *task = (struct rpc_task *)PT_REGS_PARM1(regs);
+
+    int ntrans = BPF_CORE_READ(task, tk_rqstp, rq_ntrans);
+    if (ntrans <= 0)
+        return 0;
+
+    struct rpc_xprt *xprt = BPF_CORE_READ(task, tk_xprt);
+    if (!xprt)
+        return 0;
+
+    struct xprt_stats *stats = get_xprt_stats(xprt, BPF_CORE_READ(task, tk_client, cl_clid));
+    if (!stats)
+        return 0;
+
+    __sync_fetch_and_add(&stats->retransmits, 1);
+    track_task_dev(task, xprt);
+    submit_xprt_event(regs, xprt, stats, BPF_CORE_READ(task, tk_client, cl_clid), BPF_CORE_READ(task, tk_pid),
+                      ntrans, XPRT_EVENT_RETRANSMIT);
+
+    return 0;
+}
+
+SEC("kprobe/xprt_connect")
+int kb_xprt_connect(struct pt_regs *regs)
+{
+    struct rpc_task *task = (struct rpc_task *)PT_REGS_PARM1(regs);
+
+    struct rpc_xprt *xprt = BPF_CORE_READ(task, tk_xprt);
+    if (!xprt)
+        return 0;
+
+    u32 client_id = BPF_CORE_READ(task, tk_client, cl_clid);
+    u64 id = (u64)xprt;
+    bpf_map_update_elem(&clnt_xprt, &client_id, &id, BPF_ANY);
+
+    struct xprt_stats *stats = get_xprt_stats(xprt, client_id);
+    if (!stats)
+        return 0;
+
+    __sync_fetch_and_add(&stats->connects, 1);
+    track_task_dev(task, xprt);
+    submit_xprt_event(regs, xprt, stats, client_id, BPF_CORE_READ(task, tk_pid), 0, XPRT_EVENT_CONNECT);
+
+    return 0;
+}
+
+SEC("tracepoint/sunrpc/rpc_connect_status")
+int rpc_connect_status(struct rpc_status_fields *ctx)
+{
+    if (ctx->status == 0)
+        return 0;
+
+    u32 client_id = ctx->client_id;
+    u64 *id = bpf_map_lookup_elem(&clnt_xprt, &client_id);
+    if (!id)
+        return 0;
+
+    struct rpc_xprt *xprt = (struct rpc_xprt *)*id;
+    struct xprt_stats *stats = bpf_map_lookup_elem(&xprt_metrics, id);
+    if (!stats)
+        return 0;
+
+    __sync_fetch_and_add(&stats->connect_errors, 1);
+    submit_xprt_event(ctx, xprt, stats, client_id, ctx->task_id, ctx->status, XPRT_EVENT_CONNECT_ERROR);
+
+    return 0;
+}
+
+SEC("kprobe/xprt_disconnect_done")
+int kb_xprt_disconnect(struct pt_regs *regs)
+{
+    struct rpc_xprt *xprt = (struct rpc_xprt *)PT_REGS_PARM1(regs);
+
+    struct xprt_stats *stats = get_xprt_stats(xprt, 0);
+    if (!stats)
+        return 0;
+
+    __sync_fetch_and_add(&stats->disconnects, 1);
+    submit_xprt_event(regs, xprt, stats, 0, 0, 0, XPRT_EVENT_DISCONNECT);
+
+    return 0;
+}
+
+// 传输层释放时删除统计，用户态随之删除对应的指标序列
+SEC("kprobe/xprt_destroy")
+int kb_xprt_destroy(struct pt_regs *regs)
+{
+    u64 id = (u64)PT_REGS_PARM1(regs);
+    bpf_map_delete_elem(&xprt_metrics, &id);
+
+    return 0;
+}
+
+// 传输层 slot 耗尽时任务进入 backlog 队列等待
+SEC("kprobe/xprt_add_backlog")
+int kb_xprt_add_backlog(struct pt_regs *regs)
+{
+    struct rpc_xprt *xprt = (struct rpc_xprt *)PT_REGS_PARM1(regs);
+    struct rpc_task *task = (struct rpc_task *)PT_REGS_PARM2(regs);
+
+    struct xprt_stats *stats = get_xprt_stats(xprt, BPF_CORE_READ(task, tk_client, cl_clid));
+    if (!stats)
+        return 0;
+
+    __sync_fetch_and_add(&stats->backlog_waits, 1);
+    track_task_dev(task, xprt);
+    submit_xprt_event(regs, xprt, stats, BPF_CORE_READ(task, tk_client, cl_clid), BPF_CORE_READ(task, tk_pid),
+                      0, XPRT_EVENT_BACKLOG_WAIT);
+
+    return 0;
+}
+
+SEC("kprobe/xprt_reserve_xprt")
+int kb_xprt_reserve_xprt(struct pt_regs *regs)
+{
+    u64 id = bpf_get_current_pid_tgid();
+    struct reserve_xprt_arg arg = {
+        .xprt = (u64)PT_REGS_PARM1(regs),
+        .task = (u64)PT_REGS_PARM2(regs),
+    };
+
+    bpf_map_update_elem(&reserve_xprt_args, &id, &arg, BPF_ANY);
+
+    return 0;
+}
+
+// 获取传输锁失败（返回 0）时任务进入 xprt->sending 队列等待
+SEC("kretprobe/xprt_reserve_xprt")
+int kretb_xprt_reserve_xprt(struct pt_regs *regs)
+{
+    u64 id = bpf_get_current_pid_tgid();
+    struct reserve_xprt_arg *arg = bpf_map_lookup_elem(&reserve_xprt_args, &id);
+    if (!arg)
+        return 0;
+
+    struct rpc_xprt *xprt = (struct rpc_xprt *)arg->xprt;
+    struct rpc_task *task = (struct rpc_task *)arg->task;
+    bpf_map_delete_elem(&reserve_xprt_args, &id);
+
+    if ((int)PT_REGS_RC(regs) != 0)
+        return 0;
+
+    struct xprt_stats *stats = get_xprt_stats(xprt, BPF_CORE_READ(task, tk_client, cl_clid));
+    if (!stats)
+        return 0;
+
+    __sync_fetch_and_add(&stats->sending_waits, 1);
+    track_task_dev(task, xprt);
+    submit_xprt_event(regs, xprt, stats, BPF_CORE_READ(task, tk_client, cl_clid), BPF_CORE_READ(task, tk_pid),
+                      0, XPRT_EVENT_SENDING_WAIT);
+
+    return 0;
+}
+
+// 获取文件的读写指标，不存在时创建，并关联发起进程所在的 pod 和容器
+static __always_inline struct raw_metrics *lookup_io_metrics(struct file_key *key, int pid)
+{
+    struct raw_metrics *metrics = bpf_map_lookup_elem(&io_metrics, key);
+    if (!metrics)
+    {
+        struct raw_metrics new_metrics = {0};
+        bpf_map_update_elem(&io_metrics, key, &new_metrics, BPF_ANY);
+        metrics = bpf_map_lookup_elem(&io_metrics, key);
+        if (!metrics)
+            return NULL;
+    }
+
+    // 使用 pid 到 pid_cgroup_map 中搜索
+    u64 pid_ptr = (u64)pid;
+    struct metadata *metadata = bpf_map_lookup_elem(&pid_cgroup_map, &pid_ptr);
+    if (metadata)
+    {
+        bpf_probe_read_kernel(&metrics->pod, sizeof(metrics->pod), metadata->pod);
+        bpf_probe_read_kernel(&metrics->container, sizeof(metrics->container), metadata->container);
+    }
+
+    return metrics;
+}
+
+static __always_inline u8 get_io_flags(struct rpc_task *task, struct nfs_pgio_header *hdr, bool write)
+{
+    u8 flags = 0;
+
+    if (BPF_CORE_READ(task, tk_flags) & RPC_TASK_ASYNC)
+        flags |= IO_FLAG_ASYNC;
+    if (BPF_CORE_READ(hdr, dreq))
+        flags |= IO_FLAG_DIRECT;
+    if (write && BPF_CORE_READ(hdr, args.stable) != NFS_UNSTABLE)
+        flags |= IO_FLAG_STABLE;
+
+    return flags;
+}
+
+static __always_inline int nfs_read_done(void *regs, struct rpc_task *task, struct nfs_pgio_header *hdr, struct inode *inode)
+{
+    u64 current_time = bpf_ktime_get_ns();
+    int pid;
+
+    // 获取 rpc owner pid
+    if (bpf_probe_read_kernel(&pid, sizeof(pid), &task->tk_owner) < 0)
+    {
+        return 0;
+    }
+
+    // 获取 dev 和 fileid
+    u64 dev = BPF_CORE_READ(inode, i_sb, s_dev);
+    u64 fileid = BPF_CORE_READ(inode, i_ino);
+    struct file_key key = make_file_key(dev, fileid);
+
+    if (filter_owner(pid) || filter_device(dev))
+        return 0;
+
+    // 记录挂载与传输层的对应关系
+    u32 dev_id = dev;
+    u64 xprt_id = (u64)BPF_CORE_READ(task, tk_xprt);
+    if (xprt_id)
+        bpf_map_update_elem(&dev_xprt, &dev_id, &xprt_id, BPF_ANY);
+
+    // 记录 NFS 层返回的错误状态
+    int status = BPF_CORE_READ(task, tk_status);
+    if (status < 0)
+    {
+        submit_rpc_error(regs, BPF_CORE_READ(task, tk_pid), BPF_CORE_READ(task, tk_client, cl_clid), pid, status, key,
+                         RPC_ERROR_OP_READ, RPC_ERROR_LAYER_NFS, NULL);
+    }
+
+    // 获取读取字节数
+    u32 res_count = BPF_CORE_READ(hdr, res.count);
+
+    struct raw_metrics *metrics = lookup_io_metrics(&key, pid);
+    if (!metrics)
+        return 0;
+
+    // 计算读请求延迟
+    struct request_latency lat = {0};
+    get_request_latency(task, current_time, &lat);
+    __sync_fetch_and_add(&metrics->read_lat, lat.total);
+    __sync_fetch_and_add(&metrics->read_queue_lat, lat.queue);
+    __sync_fetch_and_add(&metrics->read_rtt, lat.rtt);
+
+    // 更新读操作计数和字节数
+    __sync_fetch_and_add(&metrics->read_count, 1);
+    __sync_fetch_and_add(&metrics->read_size, res_count);
+
+    // 更新 io_metrics map
+    bpf_map_update_elem(&io_metrics, &key, metrics, BPF_ANY);
+
+    // 输出请求的位置和大小
+    if (cfg->io_events)
+    {
+        submit_io_event(regs, key, pid, status, RPC_ERROR_OP_READ, IO_PHASE_DONE, BPF_CORE_READ(hdr, args.offset),
+                        BPF_CORE_READ(hdr, args.count), res_count, get_io_flags(task, hdr, false), lat.total);
+    }
+
+    if (cfg->debug_log)
+    {
+        bpf_printk("Read - dev: %llu, file: %llu\n", dev, fileid);
+        // bpf_printk("Read - dev: %llu, file: %llu, bytes: %u, count: %d, total_bytes: %d, latency: %d\n",
+        //            dev, fileid, res_count, metrics->read_count, metrics->read_size, metrics->read_lat);
+    }
+
+    return 0;
+}
+
+SEC("kprobe/nfs_readpage_done")
+int kb_nfs_read_d(struct pt_regs *regs)
+{
+    return nfs_read_done(regs, (struct rpc_task *)PT_REGS_PARM1(regs), (struct nfs_pgio_header *)PT_REGS_PARM2(regs),
+                         (struct inode *)PT_REGS_PARM3(regs));
+}
+
+// fentry 模式下使用 fexit 替代 kprobe，直接读取带类型的参数
+SEC("fexit/nfs_readpage_done")
+int BPF_PROG(fexit_nfs_read_d, struct rpc_task *task, struct nfs_pgio_header *hdr, struct inode *inode, int ret)
+{
+    return nfs_read_done(ctx, task, hdr, inode);
+}
+
+static __always_inline int nfs_write_done(void *regs, struct rpc_task *task, struct nfs_pgio_header *hdr, struct inode *inode)
+{
+    int pid;
+    u64 current_time = bpf_ktime_get_ns();
+
+    // 获取 rpc owner pid
+    if (bpf_probe_read_kernel(&pid, sizeof(pid), &task->tk_owner) < 0)
+    {
+        return 0;
+    }
+
+    // 获取 dev 和 fileid
+    u64 dev = BPF_CORE_READ(inode, i_sb, s_dev);
+    u64 fileid = BPF_CORE_READ(inode, i_ino);
+    struct file_key key = make_file_key(dev, fileid);
+
+    if (filter_owner(pid) || filter_device(dev))
+        return 0;
+
+    // 记录挂载与传输层的对应关系
+    u32 dev_id = dev;
+    u64 xprt_id = (u64)BPF_CORE_READ(task, tk_xprt);
+    if (xprt_id)
+        bpf_map_update_elem(&dev_xprt, &dev_id, &xprt_id, BPF_ANY);
+
+    // 记录 NFS 层返回的错误状态
+    int status = BPF_CORE_READ(task, tk_status);
+    if (status < 0)
+    {
+        submit_rpc_error(regs, BPF_CORE_READ(task, tk_pid), BPF_CORE_READ(task, tk_client, cl_clid), pid, status, key,
+                         RPC_ERROR_OP_WRITE, RPC_ERROR_LAYER_NFS, NULL);
+    }
+
+    // 获取写入字节数
+    u32 res_count = BPF_CORE_READ(hdr, res.count);
+
+    struct raw_metrics *metrics = lookup_io_metrics(&key, pid);
+    if (!metrics)
+        return 0;
+
+    // 计算写请求延迟
+    struct request_latency lat = {0};
+    get_request_latency(task, current_time, &lat);
+    __sync_fetch_and_add(&metrics->write_lat, lat.total);
+    __sync_fetch_and_add(&metrics->write_queue_lat, lat.queue);
+    __sync_fetch_and_add(&metrics->write_rtt, lat.rtt);
+
+    // 更新写操作计数和字节数
+    __sync_fetch_and_add(&metrics->write_count, 1);
+    __sync_fetch_and_add(&metrics->write_size, res_count);
+
+    // 更新 io_metrics map
+    bpf_map_update_elem(&io_metrics, &key, metrics, BPF_ANY);
+
+    // 输出请求的位置和大小
+    if (cfg->io_events)
+    {
+        submit_io_event(regs, key, pid, status, RPC_ERROR_OP_WRITE, IO_PHASE_DONE, BPF_CORE_READ(hdr, args.offset),
+                        BPF_CORE_READ(hdr, args.count), res_count, get_io_flags(task, hdr, true), lat.total);
+    }
+
+    if (cfg->debug_log)
+    {
+        bpf_printk("Write - dev: %llu, file: %llu\n", dev, fileid);
+        // bpf_printk("Write - dev: %llu, file: %llu, bytes: %u, count: %d, total_bytes: %d, latency: %d\n",
+        //            dev, fileid, res_count, metrics->write_count, metrics->write_size, metrics->write_lat);
+    }
+
+    return 0;
+}
+
+SEC("kprobe/nfs_writeback_done")
+int kb_nfs_write_d(struct pt_regs *regs)
+{
+    return nfs_write_done(regs, (struct rpc_task *)PT_REGS_PARM1(regs), (struct nfs_pgio_header *)PT_REGS_PARM2(regs),
+                          (struct inode *)PT_REGS_PARM3(regs));
+}
+
+// fentry 模式下使用 fexit 替代 kprobe，直接读取带类型的参数
+SEC("fexit/nfs_writeback_done")
+int BPF_PROG(fexit_nfs_write_d, struct rpc_task *task, struct nfs_pgio_header *hdr, struct inode *inode, int ret)
+{
+    return nfs_write_done(ctx, task, hdr, inode);
+}
+
+// 通过 tracepoint 统计读写完成，字段由 layout 定位，不依赖内核函数的参数顺序。
+// tracepoint 中没有 rpc_task，请求延迟通过同一线程的 rpc_task_end 关联，
+// 无法拆分排队时间和 RTT，也不会记录挂载与传输层的对应关系
+static __always_inline int nfs_tp_done(void *ctx, const volatile struct nfs_done_layout *layout, bool write)
+{
+    u64 current_time = bpf_ktime_get_ns();
+    u32 dev = 0;
+    u64 fileid = 0;
+    u32 res_count = 0;
+    int status = 0;
+
+    if (bpf_probe_read_kernel(&dev, sizeof(dev), ctx + layout->dev) < 0 ||
+        bpf_probe_read_kernel(&fileid, sizeof(fileid), ctx + layout->fileid) < 0 ||
+        bpf_probe_read_kernel(&status, sizeof(status), ctx + layout->status) < 0)
+        return 0;
+
+    if (layout->count_from_status)
+        res_count = status > 0 ? status : 0;
+    else if (bpf_probe_read_kernel(&res_count, sizeof(res_count), ctx + layout->count) < 0)
+        return 0;
+
+    struct file_key key = make_file_key(dev, fileid);
+
+    // 找到当前线程上结束的请求，取得发起进程和开始时间
+    u64 id = bpf_get_current_pid_tgid();
+    u32 tid = (u32)id;
+    int pid = id >> 32;
+    u64 task_key = 0;
+    u64 latency = 0;
+    u8 flags = 0;
+    struct task_done_info *done = bpf_map_lookup_elem(&task_done, &tid);
+    if (done)
+    {
+        task_key = done->task_key;
+        if (done->tk_flags & RPC_TASK_ASYNC)
+            flags |= IO_FLAG_ASYNC;
+        bpf_map_delete_elem(&task_done, &tid);
+
+        struct rpc_task_info *info = bpf_map_lookup_elem(&waiting_RPC, &task_key);
+        if (info)
+        {
+            pid = info->pid;
+            if (current_time > info->timestamp)
+                latency = current_time - info->timestamp;
+            bpf_map_delete_elem(&waiting_RPC, &task_key);
+        }
+    }
+
+    if (filter_owner(pid) || filter_device(dev))
+        return 0;
+
+    // 记录 NFS 层返回的错误状态
+    if (status < 0)
+    {
+        submit_rpc_error(ctx, task_key & 0xffffffff, task_key >> 32, pid, status, key,
+                         write ? RPC_ERROR_OP_WRITE : RPC_ERROR_OP_READ, RPC_ERROR_LAYER_NFS, NULL);
+    }
+
+    struct raw_metrics *metrics = lookup_io_metrics(&key, pid);
+    if (!metrics)
+        return 0;
+
+    if (write)
+    {
+        __sync_fetch_and_add(&metrics->write_lat, latency);
+        __sync_fetch_and_add(&metrics->write_count, 1);
+        __sync_fetch_and_add(&metrics->write_size, res_count);
+    }
+    else
+    {
+        __sync_fetch_and_add(&metrics->read_lat, latency);
+        __sync_fetch_and_add(&metrics->read_count, 1);
+        __sync_fetch_and_add(&metrics->read_size, res_count);
+    }
+
+    // 输出请求的位置和大小，tracepoint 中无法判断是否为 direct IO
+    if (cfg->io_events)
+    {
+        u64 offset = 0;
+        u32 arg_count = 0;
+        u32 stable = 0;
+        if (layout->offset)
+            bpf_probe_read_kernel(&offset, sizeof(offset), ctx + layout->offset);
+        if (layout->arg_count)
+            bpf_probe_read_kernel(&arg_count, sizeof(arg_count), ctx + layout->arg_count);
+        if (write && layout->stable && bpf_probe_read_kernel(&stable, sizeof(stable), ctx + layout->stable) == 0 &&
+            stable != NFS_UNSTABLE)
+            flags |= IO_FLAG_STABLE;
+
+        submit_io_event(ctx, key, pid, status, write ? RPC_ERROR_OP_WRITE : RPC_ERROR_OP_READ, IO_PHASE_DONE, offset,
+                        arg_count, res_count, flags, latency);
+    }
+
+    if (cfg->debug_log)
+    {
+        bpf_printk("%s done - dev: %u, file: %llu\n", write ? "Write" : "Read", dev, fileid);
+    }
+
+    return 0;
+}
+
+// 内核提供 nfs:nfs_readpage_done/nfs_writeback_done tracepoint 时替代 kb_nfs_read_d/kb_nfs_write_d
+SEC("tracepoint/nfs/nfs_readpage_done")
+int tp_nfs_read_d(void *ctx)
+{
+    return nfs_tp_done(ctx, &READ_DONE_LAYOUT, false);
+}
+
+SEC("tracepoint/nfs/nfs_writeback_done")
+int tp_nfs_write_d(void *ctx)
+{
+    return nfs_tp_done(ctx, &WRITE_DONE_LAYOUT, true);
+}
+
+/*
+以下代码按访问方式（buffered、direct、mmap、splice）统计文件读写
+*/
+
+enum access_method
+{
+    ACCESS_BUFFERED,
+    ACCESS_DIRECT,
+    ACCESS_MMAP,
+    ACCESS_SPLICE,
+};
+
+struct access_key
+{
+    struct file_key key;
+    u8 method;
+    u8 op;
+    u8 pad[6];
+};
+
+struct access_stats
+{
+    u64 count;
+    u64 bytes;
+    u64 lat;
+    u64 errors;
+};
+
+struct access_key *unused_access_key __attribute__((unused));
+struct access_stats *unused_access_stats __attribute__((unused));
+
+// 线程上进行中的文件访问，在函数返回时统计
+struct access_start
+{
+    u64 timestamp;
+    u64 req_bytes;
+    struct file_key key;
+    u8 method;
+    u8 op;
+};
+
+struct
+{
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, u32);
+    __type(value, struct access_start);
+    __uint(max_entries, 4096);
+} access_start SEC(".maps");
+
+// 缺页单独记录，读写过程中复制用户数据时可能在 mmap 的 NFS 文件上缺页
+struct
+{
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, u32);
+    __type(value, struct access_start);
+    __uint(max_entries, 4096);
+} fault_start SEC(".maps");
+
+// 线程正在执行 splice，期间调用的 nfs_file_read/nfs_file_write 计为 splice 访问
+struct
+{
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, u32);
+    __type(value, u8);
+    __uint(max_entries, 1024);
+} in_splice SEC(".maps");
+
+struct
+{
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, struct access_key);
+    __type(value, struct access_stats);
+    __uint(max_entries, 8192);
+} access_metrics SEC(".maps");
+
+static __always_inline void access_begin(void *starts, struct file *file, u8 method, u8 op, u64 req_bytes)
+{
+    struct inode *inode = BPF_CORE_READ(file, f_inode);
+    if (!inode)
+        return;
+
+    u64 dev = BPF_CORE_READ(inode, i_sb, s_dev);
+    if (filter_current() || filter_device(dev))
+        return;
+
+    struct access_start start = {
+        .timestamp = bpf_ktime_get_ns(),
+        .req_bytes = req_bytes,
+        .key = make_file_key(dev, BPF_CORE_READ(inode, i_ino)),
+        .method = method,
+        .op = op};
+
+    u32 tid = (u32)bpf_get_current_pid_tgid();
+    bpf_map_update_elem(starts, &tid, &start, BPF_ANY);
+}
+
+// 取出线程上的访问记录，keep 为 false 时只清理不统计
+static __always_inline void access_end(void *starts, u64 bytes, bool error, bool keep)
+{
+    u32 tid = (u32)bpf_get_current_pid_tgid();
+    struct access_start *start = bpf_map_lookup_elem(starts, &tid);
+    if (!start)
+        return;
+
+    // pad 需要置零，否则相同的文件和访问方式会成为不同的 key
+    struct access_key key = {
+        .key = start->key,
+        .method = start->method,
+        .op = start->op,
+        .pad = {0}};
+    u64 now = bpf_ktime_get_ns();
+    u64 latency = now > start->timestamp ? now - start->timestamp : 0;
+    bpf_map_delete_elem(starts, &tid);
+
+    if (!keep)
+        return;
+
+    struct access_stats *stats = bpf_map_lookup_elem(&access_metrics, &key);
+    if (!stats)
     {
-        bpf_printk("rpc_task_done: %llu\n", rpc_task_id);
+        struct access_stats new_stats = {0};
+        bpf_map_update_elem(&access_metrics, &key, &new_stats, BPF_NOEXIST);
+        stats = bpf_map_lookup_elem(&access_metrics, &key);
+        if (!stats)
+            return;
+    }
+
+    __sync_fetch_and_add(&stats->count, 1);
+    __sync_fetch_and_add(&stats->bytes, bytes);
+    __sync_fetch_and_add(&stats->lat, latency);
+    if (error)
+        __sync_fetch_and_add(&stats->errors, 1);
+}
+
+static __always_inline int file_rw_begin(struct kiocb *iocb, struct iov_iter *iter, u8 op)
+{
+    struct file *file = BPF_CORE_READ(iocb, ki_filp);
+    if (!file)
+        return 0;
+
+    // IOCB_DIRECT 的取值随内核版本变化，使用打开文件时的 O_DIRECT 判断
+    u8 method = ACCESS_BUFFERED;
+    u32 tid = (u32)bpf_get_current_pid_tgid();
+    if (BPF_CORE_READ(file, f_flags) & O_DIRECT)
+        method = ACCESS_DIRECT;
+    else if (bpf_map_lookup_elem(&in_splice, &tid))
+        method = ACCESS_SPLICE;
+
+    access_begin(&access_start, file, method, op, BPF_CORE_READ(iter, count));
+    return 0;
+}
+
+// 异步 direct IO 提交后返回 -EIOCBQUEUED，按请求的字节数统计，延迟只包含提交时间
+static __always_inline int file_rw_end(struct pt_regs *regs)
+{
+    long ret = PT_REGS_RC(regs);
+    if (ret == -EIOCBQUEUED)
+    {
+        u32 tid = (u32)bpf_get_current_pid_tgid();
+        struct access_start *start = bpf_map_lookup_elem(&access_start, &tid);
+        access_end(&access_start, start ? start->req_bytes : 0, false, true);
+        return 0;
+    }
+
+    access_end(&access_start, ret > 0 ? ret : 0, ret < 0, true);
+    return 0;
+}
+
+SEC("kprobe/nfs_file_read")
+int kb_nfs_file_read(struct pt_regs *regs)
+{
+    return file_rw_begin((struct kiocb *)PT_REGS_PARM1(regs), (struct iov_iter *)PT_REGS_PARM2(regs),
+                         RPC_ERROR_OP_READ);
+}
+
+SEC("kretprobe/nfs_file_read")
+int kretb_nfs_file_read(struct pt_regs *regs)
+{
+    return file_rw_end(regs);
+}
+
+SEC("kprobe/nfs_file_write")
+int kb_nfs_file_write(struct pt_regs *regs)
+{
+    return file_rw_begin((struct kiocb *)PT_REGS_PARM1(regs), (struct iov_iter *)PT_REGS_PARM2(regs),
+                         RPC_ERROR_OP_WRITE);
+}
+
+SEC("kretprobe/nfs_file_write")
+int kretb_nfs_file_write(struct pt_regs *regs)
+{
+    return file_rw_end(regs);
+}
+
+static __always_inline int splice_begin(void)
+{
+    u32 tid = (u32)bpf_get_current_pid_tgid();
+    u8 one = 1;
+    bpf_map_update_elem(&in_splice, &tid, &one, BPF_ANY);
+    return 0;
+}
+
+static __always_inline int splice_end(void)
+{
+    u32 tid = (u32)bpf_get_current_pid_tgid();
+    bpf_map_delete_elem(&in_splice, &tid);
+    return 0;
+}
+
+// 6.5 之前 splice 读经 generic_file_splice_read 调用 nfs_file_read，splice 写经 iter_file_splice_write
+// 调用 nfs_file_write，这两个通用函数只用于标记线程
+SEC("kprobe/generic_file_splice_read")
+int kb_splice_read_begin(struct pt_regs *regs)
+{
+    return splice_begin();
+}
+
+SEC("kretprobe/generic_file_splice_read")
+int kretb_splice_read_end(struct pt_regs *regs)
+{
+    return splice_end();
+}
+
+SEC("kprobe/iter_file_splice_write")
+int kb_splice_write_begin(struct pt_regs *regs)
+{
+    return splice_begin();
+}
+
+SEC("kretprobe/iter_file_splice_write")
+int kretb_splice_write_end(struct pt_regs *regs)
+{
+    return splice_end();
+}
+
+// 6.5 及以上 splice 读不再经过 nfs_file_read，直接统计 nfs_file_splice_read
+SEC("kprobe/nfs_file_splice_read")
+int kb_nfs_file_splice_read(struct pt_regs *regs)
+{
+    access_begin(&access_start, (struct file *)PT_REGS_PARM1(regs), ACCESS_SPLICE, RPC_ERROR_OP_READ,
+                 PT_REGS_PARM4(regs));
+    return 0;
+}
+
+SEC("kretprobe/nfs_file_splice_read")
+int kretb_nfs_file_splice_read(struct pt_regs *regs)
+{
+    long ret = PT_REGS_RC(regs);
+    access_end(&access_start, ret > 0 ? ret : 0, ret < 0, true);
+    return 0;
+}
+
+// mmap 读缺页，只统计需要读取文件的 major fault，每次按一页计算
+SEC("kprobe/filemap_fault")
+int kb_filemap_fault(struct pt_regs *regs)
+{
+    struct vm_fault *vmf = (struct vm_fault *)PT_REGS_PARM1(regs);
+    struct file *file = BPF_CORE_READ(vmf, vma, vm_file);
+    if (!file || BPF_CORE_READ(file, f_inode, i_sb, s_magic) != NFS_SUPER_MAGIC)
+        return 0;
+
+    access_begin(&fault_start, file, ACCESS_MMAP, RPC_ERROR_OP_READ, cfg->page_size);
+    return 0;
+}
+
+SEC("kretprobe/filemap_fault")
+int kretb_filemap_fault(struct pt_regs *regs)
+{
+    u32 ret = PT_REGS_RC(regs);
+    bool error = ret & VM_FAULT_ERROR;
+    access_end(&fault_start, cfg->page_size, error, error || (ret & VM_FAULT_MAJOR));
+    return 0;
+}
+
+// mmap 写入页面前的缺页，数据随后经 writeback 写回服务端
+SEC("kprobe/nfs_vm_page_mkwrite")
+int kb_nfs_vm_page_mkwrite(struct pt_regs *regs)
+{
+    struct vm_fault *vmf = (struct vm_fault *)PT_REGS_PARM1(regs);
+    access_begin(&fault_start, BPF_CORE_READ(vmf, vma, vm_file), ACCESS_MMAP, RPC_ERROR_OP_WRITE, cfg->page_size);
+    return 0;
+}
+
+SEC("kretprobe/nfs_vm_page_mkwrite")
+int kretb_nfs_vm_page_mkwrite(struct pt_regs *regs)
+{
+    u32 ret = PT_REGS_RC(regs);
+    access_end(&fault_start, cfg->page_size, ret & VM_FAULT_ERROR, true);
+    return 0;
+}
+
+/*
+以下代码为 NFS 元数据操作追踪
+*/
+
+#ifndef MAX_ERRNO
+#define MAX_ERRNO 4095
+#endif
+
+#ifndef ENOENT
+#define ENOENT 2
+#endif
+
+#define META_NAME_LEN 64
+
+// 与 internal/output/meta.go 中 metaOpNames 保持一致
+enum meta_op
+{
+    META_LOOKUP,
+    META_OPEN,
+    META_GETATTR,
+    META_SETATTR,
+    META_CREATE,
+    META_UNLINK,
+    META_RENAME,
+};
+
+// 被追踪的函数，与 internal/bpf/meta.go 中 MetaFuncs 的顺序保持一致
+enum meta_func
+{
+    META_FN_LOOKUP,
+    META_FN_ATOMIC_OPEN,
+    META_FN_OPEN,
+    META_FN_NFS4_FILE_OPEN,
+    META_FN_GETATTR,
+    META_FN_SETATTR,
+    META_FN_CREATE,
+    META_FN_UNLINK,
+    META_FN_RENAME,
+    META_FN_MAX,
+};
+
+enum meta_arg
+{
+    META_ARG_DENTRY,
+    META_ARG_PATH,
+    META_ARG_FILE,
+};
+
+// 各函数中 dentry/path/file 参数的位置（1-5），5.12+ 的 user_namespace 和 6.3+ 的 mnt_idmap
+// 参数会改变位置，由用户态根据内核 BTF 写入，0 表示未找到
+static volatile const u8 META_ARG_POS[META_FN_MAX];
+
+struct meta_start
+{
+    u64 timestamp;
+    u64 dentry;
+    u64 parent;
+    struct file_key dir;
+    struct file_key key;
+    u8 op;
+    char name[META_NAME_LEN];
+};
+
+struct meta_event
+{
+    struct file_key dir;
+    struct file_key key;
+    u64 latency;
+    int pid;
+    int status;
+    u8 op;
+    u8 pad[7];
+    char name[META_NAME_LEN];
+    char comm[16];
+    char pod[100];
+    char container[100];
+};
+
+struct meta_event *unused_meta_event __attribute__((unused));
+
+// key: 线程 id << 8 | meta_func，区分 nfs_atomic_open 中嵌套调用的 nfs_lookup
+// 进程在函数返回前被杀死时不会删除，使用 LRU 淘汰残留的记录
+struct
+{
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, u64);
+    __type(value, struct meta_start);
+    __uint(max_entries, 10240);
+} meta_start SEC(".maps");
+
+// 最近解析过路径的目录，按 cfg->path_cache_interval 去重
+struct
+{
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, struct file_key);
+    __type(value, u64);
+    __uint(max_entries, 4096);
+} meta_path_seen SEC(".maps");
+
+struct
+{
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, EVENT_RINGBUF_SIZE);
+} meta_events SEC(".maps");
+
+static __always_inline u64 meta_start_key(u8 func)
+{
+    return ((u64)(u32)bpf_get_current_pid_tgid() << 8) | func;
+}
+
+static __always_inline void *meta_arg(struct pt_regs *regs, u8 pos)
+{
+    switch (pos)
+    {
+    case 1:
+        return (void *)PT_REGS_PARM1(regs);
+    case 2:
+        return (void *)PT_REGS_PARM2(regs);
+    case 3:
+        return (void *)PT_REGS_PARM3(regs);
+    case 4:
+        return (void *)PT_REGS_PARM4(regs);
+    case 5:
+        return (void *)PT_REGS_PARM5(regs);
+    }
+    return NULL;
+}
+
+static __always_inline struct file_key inode_key(u64 dev, struct inode *inode)
+{
+    struct file_key key = {};
+    if (inode)
+        key = make_file_key(dev, BPF_CORE_READ(inode, i_ino));
+    return key;
+}
+
+// meta_begin 记录操作开始时的 dentry，rename 后 d_name 和 d_parent 会改变，需要在入口读取
+static __always_inline int meta_begin(struct pt_regs *regs, u8 func, u8 op, u8 kind)
+{
+    if (filter_current())
+        return 0;
+
+    void *arg = meta_arg(regs, META_ARG_POS[func]);
+    if (!arg)
+        return 0;
+
+    struct dentry *dentry = arg;
+    if (kind == META_ARG_PATH)
+        dentry = BPF_CORE_READ((struct path *)arg, dentry);
+    else if (kind == META_ARG_FILE)
+        dentry = BPF_CORE_READ((struct file *)arg, f_path.dentry);
+    if (!dentry)
+        return 0;
+
+    u64 dev = BPF_CORE_READ(dentry, d_sb, s_dev);
+    if (filter_device(dev))
+        return 0;
+
+    struct dentry *parent = BPF_CORE_READ(dentry, d_parent);
+    struct meta_start start = {
+        .timestamp = bpf_ktime_get_ns(),
+        .dentry = (u64)dentry,
+        .parent = (u64)parent,
+        .dir = inode_key(dev, BPF_CORE_READ(parent, d_inode)),
+        .key = inode_key(dev, BPF_CORE_READ(dentry, d_inode)),
+        .op = op};
+    bpf_probe_read_kernel_str(start.name, sizeof(start.name), BPF_CORE_READ(dentry, d_name.name));
+
+    u64 key = meta_start_key(func);
+    bpf_map_update_elem(&meta_start, &key, &start, BPF_ANY);
+
+    return 0;
+}
+
+// meta_resolve_dir 解析父目录的路径，间隔内已解析过的目录跳过
+static __always_inline void meta_resolve_dir(struct pt_regs *regs, struct dentry *parent, struct file_key dir)
+{
+    u64 now = bpf_ktime_get_ns();
+    u64 *last = bpf_map_lookup_elem(&meta_path_seen, &dir);
+    if (last && now - *last < cfg->path_cache_interval)
+        return;
+    bpf_map_update_elem(&meta_path_seen, &dir, &now, BPF_ANY);
+
+    // 没有 vfsmount，路径相对于文件系统的根目录
+    get_full_path(regs, parent, BPF_CORE_READ(parent, d_sb, s_root), dir.file_id, dir.dev_id);
+}
+
+static __always_inline int meta_end(struct pt_regs *regs, u8 func)
+{
+    u64 key = meta_start_key(func);
+    struct meta_start *start = bpf_map_lookup_elem(&meta_start, &key);
+    if (!start)
+        return 0;
+
+    struct meta_event event = {};
+    u64 now = bpf_ktime_get_ns();
+    event.latency = now > start->timestamp ? now - start->timestamp : 0;
+    event.dir = start->dir;
+    event.key = start->key;
+    event.op = start->op;
+    __builtin_memcpy(event.name, start->name, sizeof(event.name));
+
+    struct dentry *dentry = (struct dentry *)start->dentry;
+    struct dentry *parent = (struct dentry *)start->parent;
+    bpf_map_delete_elem(&meta_start, &key);
+
+    // nfs_lookup 返回 dentry 指针，按 IS_ERR_VALUE 判断完整的 64 位值；
+    // 其余函数返回 int，寄存器高 32 位未定义，需要截断后判断
+    long ret = PT_REGS_RC(regs);
+    if (event.op == META_LOOKUP)
+    {
+        if ((unsigned long)ret >= (unsigned long)-MAX_ERRNO)
+            event.status = ret;
+    }
+    else
+    {
+        ret = (int)ret;
+        if (ret < 0)
+            event.status = ret;
+    }
+
+    // lookup 和 create 在入口时 dentry 为负，返回后再读取 inode
+    if (!event.key.file_id)
+    {
+        struct inode *inode = BPF_CORE_READ(dentry, d_inode);
+        if (inode)
+            event.key = inode_key(event.dir.dev_id, inode);
+        else if (event.op == META_LOOKUP && !ret)
+            event.status = -ENOENT;
+    }
+
+    event.pid = bpf_get_current_pid_tgid() >> 32;
+    bpf_get_current_comm(&event.comm, sizeof(event.comm));
+
+    u64 pid_key = (u64)event.pid;
+    struct metadata *metadata = bpf_map_lookup_elem(&pid_cgroup_map, &pid_key);
+    if (metadata)
+    {
+        bpf_probe_read_kernel(&event.pod, sizeof(event.pod), metadata->pod);
+        bpf_probe_read_kernel(&event.container, sizeof(event.container), metadata->container);
+    }
+
+    if (event.dir.file_id)
+        meta_resolve_dir(regs, parent, event.dir);
+
+    submit_event(regs, &meta_events, EVENT_CHAN_META, &event, sizeof(event));
+
+    return 0;
+}
+
+#define NFSTRACE_META_PROBE(fn, func, op, kind)  \
+    SEC("kprobe/" #fn)                           \
+    int kb_meta_##fn(struct pt_regs *regs)       \
+    {                                            \
+        return meta_begin(regs, func, op, kind); \
+    }                                            \
+                                                 \
+    SEC("kretprobe/" #fn)                        \
+    int kretb_meta_##fn(struct pt_regs *regs)    \
+    {                                            \
+        return meta_end(regs, func);             \
+    }
+
+NFSTRACE_META_PROBE(nfs_lookup, META_FN_LOOKUP, META_LOOKUP, META_ARG_DENTRY)
+NFSTRACE_META_PROBE(nfs_atomic_open, META_FN_ATOMIC_OPEN, META_OPEN, META_ARG_DENTRY)
+NFSTRACE_META_PROBE(nfs_open, META_FN_OPEN, META_OPEN, META_ARG_FILE)
+NFSTRACE_META_PROBE(nfs4_file_open, META_FN_NFS4_FILE_OPEN, META_OPEN, META_ARG_FILE)
+NFSTRACE_META_PROBE(nfs_getattr, META_FN_GETATTR, META_GETATTR, META_ARG_PATH)
+NFSTRACE_META_PROBE(nfs_setattr, META_FN_SETATTR, META_SETATTR, META_ARG_DENTRY)
+NFSTRACE_META_PROBE(nfs_create, META_FN_CREATE, META_CREATE, META_ARG_DENTRY)
+NFSTRACE_META_PROBE(nfs_unlink, META_FN_UNLINK, META_UNLINK, META_ARG_DENTRY)
+NFSTRACE_META_PROBE(nfs_rename, META_FN_RENAME, META_RENAME, META_ARG_DENTRY)
+
+/*
+以下代码通过 nfs4 tracepoint 追踪 NFSv4 状态管理事件
+*/
+
+// 与 internal/output/nfs4.go 中 nfs4EventNames 保持一致
+enum nfs4_event_type
+{
+    NFS4_DELEG_GRANT,
+    NFS4_DELEG_RETURN,
+    NFS4_DELEG_RECALL,
+    NFS4_OPEN_RECLAIM,
+    NFS4_OPEN_EXPIRED,
+    NFS4_STATE_RECOVERY,
+    NFS4_STATE_RECOVERY_FAILED,
+    NFS4_LEASE_RENEW,
+    NFS4_SEQUENCE_ERROR,
+    NFS4_SLOT_EXHAUSTED,
+};
+
+// 追踪的 tracepoint，与 internal/bpf/nfs4.go 中 NFS4Tracepoints 的顺序保持一致
+enum nfs4_tp
+{
+    NFS4_TP_SET_DELEGATION,
+    NFS4_TP_DELEGRETURN,
+    NFS4_TP_CB_RECALL,
+    NFS4_TP_OPEN_RECLAIM,
+    NFS4_TP_OPEN_EXPIRED,
+    NFS4_TP_STATE_MGR,
+    NFS4_TP_STATE_MGR_FAILED,
+    NFS4_TP_RENEW,
+    NFS4_TP_RENEW_ASYNC,
+    NFS4_TP_SEQUENCE_DONE,
+    NFS4_TP_RPC_TASK_SLEEP,
+    NFS4_TP_MAX,
+};
+
+// nfs4 tracepoint 的字段偏移，各内核版本格式不同，由用户态解析 tracefs 中的 format 文件后写入，
+// 0 表示 tracepoint 中没有该字段
+struct nfs4_layout
+{
+    u16 error;
+    u16 dev;
+    u16 fileid;
+    // __data_loc char[] dstaddr 或 hostname
+    u16 server;
+    u16 slot;
+    u16 status_flags;
+    // session，rpc_task_sleep 中为 client_id
+    u16 session;
+    // rpc_task_sleep 的 __data_loc char[] q_name
+    u16 queue;
+} __attribute__((packed));
+static volatile const struct nfs4_layout NFS4_LAYOUTS[NFS4_TP_MAX];
+
+struct nfs4_event
+{
+    struct file_key key;
+    int error;
+    u32 slot;
+    u32 status_flags;
+    u32 session;
+    // 事件代表的次数，slot 耗尽按间隔合并
+    u32 count;
+    int pid;
+    u8 type;
+    u8 pad[7];
+    char server[48];
+    char pod[100];
+    char container[100];
+};
+
+struct nfs4_event *unused_nfs4_event __attribute__((unused));
+
+struct nfs4_slot_seen
+{
+    u64 last_emit;
+    u32 suppressed;
+};
+
+// key: rpc_clnt cl_clid，slot 耗尽时每个客户端按 cfg->dedup_interval 输出一次
+struct
+{
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, u32);
+    __type(value, struct nfs4_slot_seen);
+    __uint(max_entries, 256);
+} nfs4_slot_seen SEC(".maps");
+
+struct
+{
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, EVENT_RINGBUF_SIZE);
+} nfs4_events SEC(".maps");
+
+// NFSv4.1 会话中等待空闲 slot 的队列名
+#define NFS4_SLOT_QUEUE "ForeChannel Slot table"
+
+// tp_read_str 读取 __data_loc 字符串，低 16 位为相对 ctx 的偏移
+static __always_inline int tp_read_str(void *ctx, u16 offset, char *buf, u32 size)
+{
+    u32 loc = tp_read_u32(ctx, offset);
+    if (!loc)
+        return -1;
+    return bpf_probe_read_kernel_str(buf, size, ctx + (loc & 0xffff));
+}
+
+static __always_inline bool is_slot_queue(void *ctx, u16 offset)
+{
+    char queue[sizeof(NFS4_SLOT_QUEUE)] = {};
+    if (tp_read_str(ctx, offset, queue, sizeof(queue)) < 0)
+        return false;
+
+    const char expected[] = NFS4_SLOT_QUEUE;
+    for (int i = 0; i < sizeof(expected) - 1; i++)
+    {
+        if (queue[i] != expected[i])
+            return false;
+    }
+    return true;
+}
+
+// slot_exhausted_count 同一客户端在间隔内的 slot 耗尽只计数，返回本次事件代表的次数，0 表示不输出
+static __always_inline u32 slot_exhausted_count(u32 client_id)
+{
+    u64 now = bpf_ktime_get_ns();
+    struct nfs4_slot_seen *seen = bpf_map_lookup_elem(&nfs4_slot_seen, &client_id);
+    if (seen && cfg->dedup_interval && now - seen->last_emit < cfg->dedup_interval)
+    {
+        __sync_fetch_and_add(&seen->suppressed, 1);
+        return 0;
+    }
+
+    u32 count = seen ? seen->suppressed + 1 : 1;
+    struct nfs4_slot_seen value = {.last_emit = now};
+    bpf_map_update_elem(&nfs4_slot_seen, &client_id, &value, BPF_ANY);
+
+    return count;
+}
+
+static __always_inline int nfs4_tp(void *ctx, u8 tp, u8 type)
+{
+    const volatile struct nfs4_layout *layout = &NFS4_LAYOUTS[tp];
+    struct nfs4_event event = {.type = type, .count = 1};
+
+    event.session = tp_read_u32(ctx, layout->session);
+    if (type == NFS4_SLOT_EXHAUSTED)
+    {
+        if (!is_slot_queue(ctx, layout->queue))
+            return 0;
+        event.count = slot_exhausted_count(event.session);
+        if (!event.count)
+            return 0;
     }
 
-    struct rpc_task_info *info = bpf_map_lookup_elem(&waiting_RPC, &rpc_task_id);
-    if (info)
+    // 新内核中 error 为正数的 unsigned long，旧内核为负的 int，统一为负 errno
+    event.error = tp_read_u32(ctx, layout->error);
+    if (event.error > 0)
+        event.error = -event.error;
+
+    event.slot = tp_read_u32(ctx, layout->slot);
+    event.status_flags = tp_read_u32(ctx, layout->status_flags);
+
+    // sequence 只输出错误和服务端返回的状态标记
+    if (type == NFS4_SEQUENCE_ERROR && !event.error && !event.status_flags)
+        return 0;
+
+    u32 dev = tp_read_u32(ctx, layout->dev);
+    if (dev)
     {
-        // 更新 link_end map
-        int update_result = bpf_map_update_elem(&link_end, &info->pid, &info->timestamp, BPF_ANY);
-        if (update_result != 0)
-        {
+        if (filter_device(dev))
             return 0;
-        }
 
-        // 从 waiting_RPC map 中删除元素
-        int delete_result = bpf_map_delete_elem(&waiting_RPC, &rpc_task_id);
-        if (delete_result != 0)
-        {
-            return 0;
-        }
+        u64 fileid = 0;
+        if (layout->fileid)
+            bpf_probe_read_kernel(&fileid, sizeof(fileid), ctx + layout->fileid);
+        event.key = make_file_key(dev, fileid);
+    }
+
+    if (layout->server)
+        tp_read_str(ctx, layout->server, event.server, sizeof(event.server));
+
+    // 状态恢复等事件运行在内核线程中，Pod 只在进程上下文中有意义
+    event.pid = bpf_get_current_pid_tgid() >> 32;
+    u64 pid_key = (u64)event.pid;
+    struct metadata *metadata = bpf_map_lookup_elem(&pid_cgroup_map, &pid_key);
+    if (metadata)
+    {
+        bpf_probe_read_kernel(&event.pod, sizeof(event.pod), metadata->pod);
+        bpf_probe_read_kernel(&event.container, sizeof(event.container), metadata->container);
     }
 
+    submit_event(ctx, &nfs4_events, EVENT_CHAN_NFS4, &event, sizeof(event));
+
     return 0;
 }
 
-SEC("kprobe/rpc_make_runnable")
-int rpc_execute(struct pt_regs *regs)
+#define NFSTRACE_NFS4_TP(group, name, prog, tp, type) \
+    SEC("tracepoint/" #group "/" #name)              \
+    int prog(void *ctx)                              \
+    {                                                \
+        return nfs4_tp(ctx, tp, type);               \
+    }
+
+NFSTRACE_NFS4_TP(nfs4, nfs4_set_delegation, tp_nfs4_set_deleg, NFS4_TP_SET_DELEGATION, NFS4_DELEG_GRANT)
+NFSTRACE_NFS4_TP(nfs4, nfs4_delegreturn, tp_nfs4_delegreturn, NFS4_TP_DELEGRETURN, NFS4_DELEG_RETURN)
+NFSTRACE_NFS4_TP(nfs4, nfs4_cb_recall, tp_nfs4_cb_recall, NFS4_TP_CB_RECALL, NFS4_DELEG_RECALL)
+NFSTRACE_NFS4_TP(nfs4, nfs4_open_reclaim, tp_nfs4_open_reclaim, NFS4_TP_OPEN_RECLAIM, NFS4_OPEN_RECLAIM)
+NFSTRACE_NFS4_TP(nfs4, nfs4_open_expired, tp_nfs4_open_expired, NFS4_TP_OPEN_EXPIRED, NFS4_OPEN_EXPIRED)
+NFSTRACE_NFS4_TP(nfs4, nfs4_state_mgr, tp_nfs4_state_mgr, NFS4_TP_STATE_MGR, NFS4_STATE_RECOVERY)
+NFSTRACE_NFS4_TP(nfs4, nfs4_state_mgr_failed, tp_nfs4_state_mgr_failed, NFS4_TP_STATE_MGR_FAILED,
+                 NFS4_STATE_RECOVERY_FAILED)
+NFSTRACE_NFS4_TP(nfs4, nfs4_renew, tp_nfs4_renew, NFS4_TP_RENEW, NFS4_LEASE_RENEW)
+NFSTRACE_NFS4_TP(nfs4, nfs4_renew_async, tp_nfs4_renew_async, NFS4_TP_RENEW_ASYNC, NFS4_LEASE_RENEW)
+NFSTRACE_NFS4_TP(nfs4, nfs4_sequence_done, tp_nfs4_sequence_done, NFS4_TP_SEQUENCE_DONE, NFS4_SEQUENCE_ERROR)
+NFSTRACE_NFS4_TP(sunrpc, rpc_task_sleep, tp_nfs4_slot_wait, NFS4_TP_RPC_TASK_SLEEP, NFS4_SLOT_EXHAUSTED)
+
+/*
+以下代码为 NFS 文件锁追踪，nfs_lock 处理 fcntl 锁（v3 走 NLM，v4 走 LOCK/LOCKT），nfs_flock 处理 flock
+*/
+
+#define F_GETLK 5
+#define F_SETLKW 7
+#define F_OFD_GETLK 36
+#define F_OFD_SETLK 37
+#define F_OFD_SETLKW 38
+#define F_UNLCK 2
+
+// 与 internal/output/lock.go 中 lockKindNames、lockOpNames 保持一致
+enum lock_kind
 {
-    struct rpc_task *task;
-    task = (struct rpc_task *)PT_REGS_PARM2(regs);
+    LOCK_KIND_POSIX,
+    LOCK_KIND_OFD,
+    LOCK_KIND_FLOCK,
+};
 
-    u32 pid = bpf_get_current_pid_tgid() >> 32;
-    u32 tid = (u32)bpf_get_current_pid_tgid();
-    u64 rpc_task_id = BPF_CORE_READ(task, tk_pid);
+enum lock_op
+{
+    LOCK_OP_LOCK,
+    LOCK_OP_UNLOCK,
+    LOCK_OP_TEST,
+};
 
-    if (cfg->debug_log)
+enum lock_phase
+{
+    // 阻塞的加锁请求开始等待
+    LOCK_PHASE_WAIT,
+    // 请求返回
+    LOCK_PHASE_DONE,
+};
+
+// 6.9+ 将 fl_owner、fl_type、fl_pid 移到 struct file_lock_core 中
+struct file_lock_core___v69
+{
+    fl_owner_t flc_owner;
+    unsigned char flc_type;
+    int flc_pid;
+} __attribute__((preserve_access_index));
+
+struct file_lock___v69
+{
+    struct file_lock_core___v69 c;
+    loff_t fl_start;
+    loff_t fl_end;
+} __attribute__((preserve_access_index));
+
+struct lock_info
+{
+    u64 owner;
+    s64 start;
+    s64 end;
+    int pid;
+    u8 type;
+};
+
+struct lock_start
+{
+    u64 timestamp;
+    u64 fl;
+    struct file_key key;
+    u8 kind;
+    u8 op;
+    u8 blocking;
+};
+
+struct lock_event
+{
+    struct file_key key;
+    // posix 锁为 files_struct，OFD 锁和 flock 为 struct file
+    u64 owner;
+    s64 start;
+    s64 end;
+    u64 latency;
+    int pid;
+    int status;
+    // F_GETLK 返回的冲突锁的进程，只有本机的锁才有
+    int conflict_pid;
+    u8 kind;
+    u8 op;
+    u8 type;
+    u8 blocking;
+    u8 phase;
+    u8 pad[3];
+    char comm[16];
+    char pod[100];
+    char container[100];
+};
+
+struct lock_event *unused_lock_event __attribute__((unused));
+
+struct
+{
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, u32);
+    __type(value, struct lock_start);
+    __uint(max_entries, 10240);
+} lock_start SEC(".maps");
+
+// 最近解析过路径的文件，按 cfg->path_cache_interval 去重
+struct
+{
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, struct file_key);
+    __type(value, u64);
+    __uint(max_entries, 4096);
+} lock_path_seen SEC(".maps");
+
+struct
+{
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, EVENT_RINGBUF_SIZE);
+} lock_events SEC(".maps");
+
+static __always_inline struct lock_info read_file_lock(void *fl)
+{
+    struct lock_info info = {};
+    if (bpf_core_field_exists(((struct file_lock___v69 *)0)->c))
     {
-        bpf_printk("rpc_execute: %llu, pid: %u, tid: %u\n", rpc_task_id, pid, tid);
+        struct file_lock___v69 *lock = fl;
+        info.owner = (u64)BPF_CORE_READ(lock, c.flc_owner);
+        info.type = BPF_CORE_READ(lock, c.flc_type);
+        info.pid = BPF_CORE_READ(lock, c.flc_pid);
+        info.start = BPF_CORE_READ(lock, fl_start);
+        info.end = BPF_CORE_READ(lock, fl_end);
+    }
+    else
+    {
+        struct file_lock *lock = fl;
+        info.owner = (u64)BPF_CORE_READ(lock, fl_owner);
+        info.type = BPF_CORE_READ(lock, fl_type);
+        info.pid = BPF_CORE_READ(lock, fl_pid);
+        info.start = BPF_CORE_READ(lock, fl_start);
+        info.end = BPF_CORE_READ(lock, fl_end);
     }
+    return info;
+}
+
+static __always_inline void lock_fill_task(struct lock_event *event)
+{
+    event->pid = bpf_get_current_pid_tgid() >> 32;
+    bpf_get_current_comm(&event->comm, sizeof(event->comm));
 
-    u64 *timestamp = bpf_map_lookup_elem(&link_begin, &pid);
-    if (timestamp)
+    u64 pid_key = (u64)event->pid;
+    struct metadata *metadata = bpf_map_lookup_elem(&pid_cgroup_map, &pid_key);
+    if (metadata)
     {
-        struct rpc_task_info info = {
-            .timestamp = *timestamp,
-            .tid = tid,
-            .pid = pid};
-        bpf_map_update_elem(&waiting_RPC, &rpc_task_id, &info, BPF_ANY);
+        bpf_probe_read_kernel(&event->pod, sizeof(event->pod), metadata->pod);
+        bpf_probe_read_kernel(&event->container, sizeof(event->container), metadata->container);
     }
+}
 
-    return 0;
+// lock_resolve_path 解析加锁文件的路径，间隔内已解析过的文件跳过
+static __always_inline void lock_resolve_path(struct pt_regs *regs, struct file *filp, struct file_key key)
+{
+    u64 now = bpf_ktime_get_ns();
+    u64 *last = bpf_map_lookup_elem(&lock_path_seen, &key);
+    if (last && now - *last < cfg->path_cache_interval)
+        return;
+    bpf_map_update_elem(&lock_path_seen, &key, &now, BPF_ANY);
+
+    struct dentry *root = BPF_CORE_READ(filp, f_path.mnt, mnt_root);
+    struct dentry *dentry = BPF_CORE_READ(filp, f_path.dentry);
+    if (root && dentry)
+        get_full_path(regs, dentry, root, key.file_id, key.dev_id);
 }
 
-SEC("kprobe/rpc_exit_task")
-int rpc_exit_task(struct pt_regs *regs)
+// lock_begin 记录请求，阻塞的加锁请求先输出等待事件，用于查询当前的等待者
+static __always_inline int lock_begin(struct pt_regs *regs, bool flock)
 {
-    struct rpc_task *task;
-    task = (struct rpc_task *)PT_REGS_PARM1(regs);
+    if (filter_current())
+        return 0;
 
-    u64 rpc_task_id = BPF_CORE_READ(task, tk_pid);
+    struct file *filp = (struct file *)PT_REGS_PARM1(regs);
+    int cmd = (int)PT_REGS_PARM2(regs);
+    void *fl = (void *)PT_REGS_PARM3(regs);
+    if (!filp || !fl)
+        return 0;
 
-    if (cfg->debug_log)
-    {
-        bpf_printk("rpc_exit_task: %llu\n", rpc_task_id);
-    }
+    struct inode *inode = BPF_CORE_READ(filp, f_inode);
+    u64 dev = BPF_CORE_READ(inode, i_sb, s_dev);
+    if (filter_device(dev))
+        return 0;
 
-    struct rpc_task_info *info = bpf_map_lookup_elem(&waiting_RPC, &rpc_task_id);
-    if (info)
-    {
-        // 更新 link_end map
-        int update_result = bpf_map_update_elem(&link_end, &info->pid, &info->timestamp, BPF_ANY);
-        if (update_result != 0)
-        {
-            return 0;
-        }
+    struct lock_start start = {
+        .timestamp = bpf_ktime_get_ns(),
+        .fl = (u64)fl,
+        .key = inode_key(dev, inode),
+        .kind = LOCK_KIND_POSIX,
+        .op = LOCK_OP_LOCK,
+        .blocking = cmd == F_SETLKW || cmd == F_OFD_SETLKW};
+    if (flock)
+        start.kind = LOCK_KIND_FLOCK;
+    else if (cmd == F_OFD_GETLK || cmd == F_OFD_SETLK || cmd == F_OFD_SETLKW)
+        start.kind = LOCK_KIND_OFD;
+
+    struct lock_info info = read_file_lock(fl);
+    if (cmd == F_GETLK || cmd == F_OFD_GETLK)
+        start.op = LOCK_OP_TEST;
+    else if (info.type == F_UNLCK)
+        start.op = LOCK_OP_UNLOCK;
 
-        // 从 waiting_RPC map 中删除元素
-        int delete_result = bpf_map_delete_elem(&waiting_RPC, &rpc_task_id);
-        if (delete_result != 0)
-        {
-            return 0;
-        }
-    }
+    u32 tid = (u32)bpf_get_current_pid_tgid();
+    bpf_map_update_elem(&lock_start, &tid, &start, BPF_ANY);
+
+    if (start.op == LOCK_OP_UNLOCK)
+        return 0;
+
+    lock_resolve_path(regs, filp, start.key);
+    if (!start.blocking)
+        return 0;
+
+    struct lock_event event = {
+        .key = start.key,
+        .owner = info.owner,
+        .start = info.start,
+        .end = info.end,
+        .kind = start.kind,
+        .op = start.op,
+        .type = info.type,
+        .blocking = start.blocking,
+        .phase = LOCK_PHASE_WAIT};
+    lock_fill_task(&event);
+    submit_event(regs, &lock_events, EVENT_CHAN_LOCK, &event, sizeof(event));
 
     return 0;
 }
 
-SEC("kprobe/nfs_readpage_done")
-int kb_nfs_read_d(struct pt_regs *regs)
+static __always_inline int lock_end(struct pt_regs *regs)
 {
-    struct rpc_task *task;
-    struct inode *inode;
-    struct nfs_pgio_header *hdr;
-    u64 current_time = bpf_ktime_get_ns();
-    int pid;
+    u32 tid = (u32)bpf_get_current_pid_tgid();
+    struct lock_start *start = bpf_map_lookup_elem(&lock_start, &tid);
+    if (!start)
+        return 0;
 
-    task = (struct rpc_task *)PT_REGS_PARM1(regs);
+    struct lock_event event = {
+        .key = start->key,
+        .kind = start->kind,
+        .op = start->op,
+        .blocking = start->blocking,
+        .phase = LOCK_PHASE_DONE};
+    u64 now = bpf_ktime_get_ns();
+    event.latency = now > start->timestamp ? now - start->timestamp : 0;
+    void *fl = (void *)start->fl;
+    bpf_map_delete_elem(&lock_start, &tid);
+
+    event.status = PT_REGS_RC(regs);
+
+    // F_GETLK 返回后 fl 中为冲突的锁，没有冲突时类型为 F_UNLCK，owner 和范围仍为请求的值
+    struct lock_info info = read_file_lock(fl);
+    event.owner = info.owner;
+    event.start = info.start;
+    event.end = info.end;
+    event.type = info.type;
+    if (event.op == LOCK_OP_TEST && info.type != F_UNLCK)
+        event.conflict_pid = info.pid;
+
+    lock_fill_task(&event);
+    submit_event(regs, &lock_events, EVENT_CHAN_LOCK, &event, sizeof(event));
 
-    // 获取 rpc owner pid
-    if (bpf_probe_read_kernel(&pid, sizeof(pid), &task->tk_owner) < 0)
-    {
-        return 0;
-    }
+    return 0;
+}
 
-    inode = (struct inode *)PT_REGS_PARM3(regs);
-    hdr = (struct nfs_pgio_header *)PT_REGS_PARM2(regs);
+SEC("kprobe/nfs_lock")
+int kb_nfs_lock(struct pt_regs *regs)
+{
+    return lock_begin(regs, false);
+}
 
-    // 获取 dev 和 fileid
-    u64 dev = BPF_CORE_READ(inode, i_sb, s_dev);
-    u64 fileid = BPF_CORE_READ(inode, i_ino);
-    u64 key = (((u64)dev) << 32) | (fileid & 0xFFFFFFFF);
+SEC("kretprobe/nfs_lock")
+int kretb_nfs_lock(struct pt_regs *regs)
+{
+    return lock_end(regs);
+}
 
-    // 获取读取字节数
-    u32 res_count = BPF_CORE_READ(hdr, res.count);
+SEC("kprobe/nfs_flock")
+int kb_nfs_flock(struct pt_regs *regs)
+{
+    return lock_begin(regs, true);
+}
 
-    struct raw_metrics *metrics = bpf_map_lookup_elem(&io_metrics, &key);
-    if (!metrics)
-    {
-        struct raw_metrics new_metrics = {0};
-        bpf_map_update_elem(&io_metrics, &key, &new_metrics, BPF_ANY);
-        metrics = bpf_map_lookup_elem(&io_metrics, &key);
-        if (!metrics)
-            return 0;
-    }
+SEC("kretprobe/nfs_flock")
+int kretb_nfs_flock(struct pt_regs *regs)
+{
+    return lock_end(regs);
+}
 
-    // 使用 pid 到 pid_cgroup_map 中搜索
-    u64 pid_ptr = (u64)pid;
-    struct metadata *metadata = bpf_map_lookup_elem(&pid_cgroup_map, &pid_ptr);
-    if (metadata)
-    {
-        bpf_probe_read_kernel(&metrics->pod, sizeof(metrics->pod), metadata->pod);
-        bpf_probe_read_kernel(&metrics->container, sizeof(metrics->container), metadata->container);
-    }
+/*
+以下代码检测 NFS 服务端无响应与恢复，对应内核日志 "server X not responding" 和 "server X OK"
+*/
+
+// 与 internal/output/health.go 中 serverEventNames 保持一致
+enum server_event_type
+{
+    // 超时后重传，未达到 major timeout
+    SERVER_MINOR_TIMEOUT,
+    // 传输层第一次 major timeout
+    SERVER_NOT_RESPONDING,
+    // 无响应期间后续的 major timeout
+    SERVER_MAJOR_TIMEOUT,
+    // 无响应后第一次收到回复
+    SERVER_OK,
+};
+
+struct server_state
+{
+    // 第一次 major timeout 的时间，0 表示服务端正常
+    u64 down_since;
+    u64 last_minor_emit;
+    u64 last_major_emit;
+    // 上次输出事件后合并的 minor/major timeout 次数
+    u32 minor;
+    u32 major;
+};
+
+struct server_event
+{
+    u64 xprt;
+    // SERVER_OK 时为无响应的持续时间
+    u64 duration;
+    u32 client_id;
+    u32 task_id;
+    // 事件代表的超时次数，SERVER_OK 时为尚未输出的 major timeout 次数
+    u32 count;
+    // 发起请求的进程
+    int pid;
+    u8 type;
+    u8 pad[7];
+    char addr[48];
+    char proc[32];
+    char pod[100];
+    char container[100];
+};
+
+struct server_event *unused_server_event __attribute__((unused));
+
+// key: rpc_xprt 指针
+struct
+{
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, u64);
+    __type(value, struct server_state);
+    __uint(max_entries, 256);
+} server_state SEC(".maps");
+
+// key: {tgid, pid}, value: xprt_adjust_timeout 的 rpc_rqst 参数
+struct
+{
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, u64);
+    __type(value, u64);
+    __uint(max_entries, 1024);
+} adjust_timeout_args SEC(".maps");
+
+struct
+{
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, EVENT_RINGBUF_SIZE);
+} server_events SEC(".maps");
 
-    // 计算读操作延迟
-    u64 *start_time = bpf_map_lookup_elem(&link_end, &pid);
-    if (start_time)
+static __always_inline void submit_server_event(void *ctx, struct rpc_xprt *xprt, struct rpc_task *task,
+                                                u8 type, u32 count, u64 duration)
+{
+    struct server_event event = {
+        .xprt = (u64)xprt,
+        .duration = duration,
+        .count = count,
+        .type = type};
+
+    const char *addr = BPF_CORE_READ(xprt, address_strings[RPC_DISPLAY_ADDR]);
+    if (addr)
+        bpf_probe_read_kernel_str(&event.addr, sizeof(event.addr), addr);
+
+    if (task)
     {
-        metrics->read_lat += current_time - *start_time;
-        bpf_map_delete_elem(&link_begin, &pid);
-    }
+        event.client_id = BPF_CORE_READ(task, tk_client, cl_clid);
+        event.task_id = BPF_CORE_READ(task, tk_pid);
+        event.pid = BPF_CORE_READ(task, tk_owner);
 
-    // 更新读操作计数和字节数
-    __sync_fetch_and_add(&metrics->read_count, 1);
-    __sync_fetch_and_add(&metrics->read_size, res_count);
+        const char *proc = BPF_CORE_READ(task, tk_msg.rpc_proc, p_name);
+        if (proc)
+            bpf_probe_read_kernel_str(&event.proc, sizeof(event.proc), proc);
 
-    // 更新 io_metrics map
-    bpf_map_update_elem(&io_metrics, &key, metrics, BPF_ANY);
+        u64 pid_key = (u64)event.pid;
+        struct metadata *metadata = bpf_map_lookup_elem(&pid_cgroup_map, &pid_key);
+        if (metadata)
+        {
+            bpf_probe_read_kernel(&event.pod, sizeof(event.pod), metadata->pod);
+            bpf_probe_read_kernel(&event.container, sizeof(event.container), metadata->container);
+        }
+    }
 
     if (cfg->debug_log)
     {
-        bpf_printk("Read - dev: %llu, file: %llu\n", dev, fileid);
-        // bpf_printk("Read - dev: %llu, file: %llu, bytes: %u, count: %d, total_bytes: %d, latency: %d\n",
-        //            dev, fileid, res_count, metrics->read_count, metrics->read_size, metrics->read_lat);
+        bpf_printk("server_event: type: %d, count: %u, duration: %llu\n", type, count, duration);
     }
 
-    return 0;
+    submit_event(ctx, &server_events, EVENT_CHAN_SERVER, &event, sizeof(event));
 }
 
-SEC("kprobe/nfs_writeback_done")
-int kb_nfs_write_d(struct pt_regs *regs)
+SEC("kprobe/xprt_adjust_timeout")
+int kb_xprt_adjust_timeout(struct pt_regs *regs)
 {
-    int pid;
-    struct rpc_task *task;
-    struct inode *inode;
-    struct nfs_pgio_header *hdr;
-    u64 current_time = bpf_ktime_get_ns();
+    u64 id = bpf_get_current_pid_tgid();
+    u64 req = (u64)PT_REGS_PARM1(regs);
+    bpf_map_update_elem(&adjust_timeout_args, &id, &req, BPF_ANY);
 
-    task = (struct rpc_task *)PT_REGS_PARM1(regs);
+    return 0;
+}
 
-    // 获取 rpc owner pid
-    if (bpf_probe_read_kernel(&pid, sizeof(pid), &task->tk_owner) < 0)
-    {
+// 返回 0 为 minor timeout，加大超时后重传；返回 -ETIMEDOUT 为 major timeout，硬挂载的任务继续重试
+SEC("kretprobe/xprt_adjust_timeout")
+int kretb_xprt_adjust_timeout(struct pt_regs *regs)
+{
+    u64 id = bpf_get_current_pid_tgid();
+    u64 *req_ptr = bpf_map_lookup_elem(&adjust_timeout_args, &id);
+    if (!req_ptr)
         return 0;
-    }
 
-    inode = (struct inode *)PT_REGS_PARM3(regs);
-    hdr = (struct nfs_pgio_header *)PT_REGS_PARM2(regs);
+    struct rpc_rqst *req = (struct rpc_rqst *)*req_ptr;
+    bpf_map_delete_elem(&adjust_timeout_args, &id);
 
-    // 获取 dev 和 fileid
-    u64 dev = BPF_CORE_READ(inode, i_sb, s_dev);
-    u64 fileid = BPF_CORE_READ(inode, i_ino);
-    u64 key = (((u64)dev) << 32) | (fileid & 0xFFFFFFFF);
-    // 获取写入字节数
-    u32 res_count = BPF_CORE_READ(hdr, res.count);
+    struct rpc_xprt *xprt = BPF_CORE_READ(req, rq_xprt);
+    if (!xprt)
+        return 0;
 
-    struct raw_metrics *metrics = bpf_map_lookup_elem(&io_metrics, &key);
-    if (!metrics)
+    u64 key = (u64)xprt;
+    struct server_state *state = bpf_map_lookup_elem(&server_state, &key);
+    if (!state)
     {
-        struct raw_metrics new_metrics = {0};
-        bpf_map_update_elem(&io_metrics, &key, &new_metrics, BPF_ANY);
-        metrics = bpf_map_lookup_elem(&io_metrics, &key);
-        if (!metrics)
+        struct server_state new_state = {};
+        bpf_map_update_elem(&server_state, &key, &new_state, BPF_NOEXIST);
+        state = bpf_map_lookup_elem(&server_state, &key);
+        if (!state)
             return 0;
     }
 
-    // 使用 pid 到 pid_cgroup_map 中搜索
-    u64 pid_ptr = (u64)pid;
-    struct metadata *metadata = bpf_map_lookup_elem(&pid_cgroup_map, &pid_ptr);
-    if (metadata)
+    struct rpc_task *task = BPF_CORE_READ(req, rq_task);
+    u64 now = bpf_ktime_get_ns();
+    int ret = PT_REGS_RC(regs);
+    if (ret == 0)
     {
-        bpf_probe_read_kernel(&metrics->pod, sizeof(metrics->pod), metadata->pod);
-        bpf_probe_read_kernel(&metrics->container, sizeof(metrics->container), metadata->container);
+        __sync_fetch_and_add(&state->minor, 1);
+        if (cfg->dedup_interval && now - state->last_minor_emit < cfg->dedup_interval)
+            return 0;
+
+        u32 count = state->minor;
+        state->minor = 0;
+        state->last_minor_emit = now;
+        submit_server_event(regs, xprt, task, SERVER_MINOR_TIMEOUT, count, 0);
+        return 0;
     }
 
-    // 计算写操作延迟
-    u64 *start_time = bpf_map_lookup_elem(&link_end, &pid);
-    if (start_time)
+    // 第一次 major timeout 总是输出，之后按间隔合并
+    if (!state->down_since)
     {
-        metrics->write_lat += current_time - *start_time;
-        bpf_map_delete_elem(&link_begin, &pid);
+        state->down_since = now;
+        state->last_major_emit = now;
+        submit_server_event(regs, xprt, task, SERVER_NOT_RESPONDING, 1, 0);
+        return 0;
     }
 
-    // 更新写操作计数和字节数
-    __sync_fetch_and_add(&metrics->write_count, 1);
-    __sync_fetch_and_add(&metrics->write_size, res_count);
+    __sync_fetch_and_add(&state->major, 1);
+    if (cfg->dedup_interval && now - state->last_major_emit < cfg->dedup_interval)
+        return 0;
 
-    // 更新 io_metrics map
-    bpf_map_update_elem(&io_metrics, &key, metrics, BPF_ANY);
+    u32 count = state->major;
+    state->major = 0;
+    state->last_major_emit = now;
+    submit_server_event(regs, xprt, task, SERVER_MAJOR_TIMEOUT, count, now - state->down_since);
 
-    if (cfg->debug_log)
-    {
-        bpf_printk("Write - dev: %llu, file: %llu\n", dev, fileid);
-        // bpf_printk("Write - dev: %llu, file: %llu, bytes: %u, count: %d, total_bytes: %d, latency: %d\n",
-        //            dev, fileid, res_count, metrics->write_count, metrics->write_size, metrics->write_lat);
-    }
+    return 0;
+}
+
+// 收到回复说明服务端已恢复，无响应的传输层只在这里查找一次 map
+SEC("kprobe/xprt_complete_rqst")
+int kb_xprt_complete_rqst(struct pt_regs *regs)
+{
+    struct rpc_task *task = (struct rpc_task *)PT_REGS_PARM1(regs);
+    struct rpc_xprt *xprt = BPF_CORE_READ(task, tk_xprt);
+    if (!xprt)
+        return 0;
+
+    u64 key = (u64)xprt;
+    struct server_state *state = bpf_map_lookup_elem(&server_state, &key);
+    if (!state || !state->down_since)
+        return 0;
+
+    u64 now = bpf_ktime_get_ns();
+    u64 duration = now > state->down_since ? now - state->down_since : 0;
+    u32 count = state->major;
+    state->down_since = 0;
+    state->major = 0;
+    submit_server_event(regs, xprt, task, SERVER_OK, count, duration);
 
     return 0;
 }
@@ -825,7 +3365,7 @@ int kprobe_udp_sendmsg(struct pt_regs *ctx)
         bpf_printk("domain: %s, len: %d\n", query.domain, read_len);
     }
 
-    bpf_perf_event_output(ctx, &dns_events, BPF_F_CURRENT_CPU, &query, sizeof(query));
+    submit_event(ctx, &dns_events, EVENT_CHAN_DNS, &query, sizeof(query));
 
     return 0;
 }
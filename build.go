@@ -1,4 +1,5 @@
 //go:generate sh -c "echo Generating for $TARGET_GOARCH"
-//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -type rpc_task_fields -type raw_metrics -type path_segment -type dns_event -target $TARGET_GOARCH -go-package binary -output-dir ./internal/binary -cc clang -no-strip NFSTrace ./bpf/trace.c -- -DRPC_TASK_VAR=$FILTER_STRUCT -I./bpf/headers -Wno-address-of-packed-member
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -type file_key -type rpc_task_fields -type raw_metrics -type path_segment -type dns_event -type rpc_error_event -type xprt_stats -type xprt_event -type io_event -type access_key -type access_stats -type meta_event -type nfs4_event -type lock_event -type server_event -target $TARGET_GOARCH -go-package binary -output-dir ./internal/binary -cc clang -no-strip NFSTrace ./bpf/trace.c -- -DRPC_TASK_VAR=$FILTER_STRUCT -I./bpf/headers -Wno-address-of-packed-member
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -target $TARGET_GOARCH -go-package binary -output-dir ./internal/binary -cc clang -no-strip NFSTraceLegacy ./bpf/trace.c -- -DPATH_WALK_UNROLL -DRPC_TASK_VAR=$FILTER_STRUCT -I./bpf/headers -Wno-address-of-packed-member
 
 package main
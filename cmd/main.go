@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 
+	"github.com/cen-ngc5139/nfs-trace/internal/bpf"
 	"github.com/cen-ngc5139/nfs-trace/internal/config"
 	"github.com/cen-ngc5139/nfs-trace/internal/log"
 
@@ -26,6 +27,31 @@ func main() {
 
 	config.SetFlags(rootCmd.Flags())
 
+	var pinPath, configPath string
+	var uninstallCmd = &cobra.Command{
+		Use:   "uninstall",
+		Short: "Remove the BPF maps pinned by nfs-trace",
+		Run: func(cmd *cobra.Command, args []string) {
+			// 未显式指定 --pin-path 时使用配置文件中的 pin.path
+			if configPath != "" && !cmd.Flags().Changed("pin-path") {
+				cfg := config.Configuration{ConfigPath: configPath}
+				if err := config.LoadConfig(&cfg); err != nil {
+					log.Fatal(err)
+				}
+				if cfg.Pin.Path != "" {
+					pinPath = cfg.Pin.Path
+				}
+			}
+
+			if err := bpf.Unpin(pinPath); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	uninstallCmd.Flags().StringVar(&pinPath, "pin-path", bpf.DefaultPinPath, "bpffs directory of the pinned BPF maps")
+	uninstallCmd.Flags().StringVar(&configPath, "config-path", "", "config file to read pin.path from")
+	rootCmd.AddCommand(uninstallCmd)
+
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal(err)
 		os.Exit(1)
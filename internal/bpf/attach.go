@@ -44,3 +44,35 @@ func AttachTracepoint(coll *ebpf.Collection) (*tracing, bool, error) {
 
 	return trace, hasError, nil
 }
+
+// AttachTracepointGroup 附加 group 组中的 tracepoint，progs 为程序名到 tracepoint 的映射
+func AttachTracepointGroup(coll *ebpf.Collection, group string, progs map[string]string) *tracing {
+	tracepointProgs := map[string]*ebpf.Program{}
+	for name, tracepoint := range progs {
+		if prog, ok := coll.Programs[name]; ok {
+			tracepointProgs[tracepoint] = prog
+		}
+	}
+
+	return Tracepoint(group, tracepointProgs)
+}
+
+// AttachXprtTracepoint 附加 sunrpc 传输层相关的 tracepoint，不存在的 tracepoint 将被跳过
+func AttachXprtTracepoint(coll *ebpf.Collection) *tracing {
+	xprtTracepointProgs := map[string]*ebpf.Program{}
+	for name, prog := range coll.Programs {
+		key, ok := XprtTracepointProgs[name]
+		if !ok {
+			continue
+		}
+
+		if !IsTracepointExist("sunrpc", key) {
+			log.Warningf("警告：Tracepoint %s/%s 不存在，跳过\n", "sunrpc", key)
+			continue
+		}
+
+		xprtTracepointProgs[key] = prog
+	}
+
+	return Tracepoint("sunrpc", xprtTracepointProgs)
+}
@@ -1,11 +1,35 @@
 package bpf
 
 import (
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/cen-ngc5139/nfs-trace/internal/config"
 )
 
+const (
+	DefaultMaxPathDepth = 256
+	// 与 bpf/trace.c 中 MAX_PATH_DEPTH 保持一致
+	MaxPathDepth = 1024
+	// LegacyMaxPathDepth 不支持有界循环的内核上展开遍历的深度，与 bpf/trace.c 中 PATH_WALK_UNROLL 时的 PATH_WALK_DEPTH 保持一致
+	LegacyMaxPathDepth = 10
+	// DefaultPathCacheInterval 元数据操作父目录、加锁文件路径的默认缓存间隔
+	DefaultPathCacheInterval = 10 * time.Second
+)
+
 type FilterCfg struct {
-	EnableDebug uint8
+	EnableDebug  uint8
+	UseRingBuf   uint8
+	HasFuncIP    uint8
+	StackTrace   uint8
+	MaxPathDepth uint16
+	IOEvents     uint8
+	PageSize     uint32
+	// DedupInterval 文件访问事件的去重间隔，单位纳秒
+	DedupInterval uint64
+	// PathCacheInterval 元数据操作父目录、加锁文件路径的缓存间隔，单位纳秒
+	PathCacheInterval uint64
 }
 
 func boolToUint8(b bool) uint8 {
@@ -15,9 +39,37 @@ func boolToUint8(b bool) uint8 {
 	return 0
 }
 
-func GetConfig(flags config.Configuration) (cfg FilterCfg, err error) {
+func GetConfig(flags config.Configuration, useRingBuf, hasFuncIP bool) (cfg FilterCfg, err error) {
 	cfg = FilterCfg{
 		EnableDebug: boolToUint8(flags.Features.Debug),
+		UseRingBuf:  boolToUint8(useRingBuf),
+		HasFuncIP:   boolToUint8(hasFuncIP),
+		StackTrace:  boolToUint8(flags.Features.StackTrace),
+		IOEvents:    boolToUint8(flags.Features.IOPattern),
+		PageSize:    uint32(os.Getpagesize()),
+	}
+
+	if flags.Probing.DedupInterval < 0 {
+		return cfg, fmt.Errorf("invalid dedup interval %s", flags.Probing.DedupInterval)
+	}
+	cfg.DedupInterval = uint64(flags.Probing.DedupInterval.Nanoseconds())
+
+	switch interval := flags.Probing.PathCacheInterval; {
+	case interval < 0:
+		return cfg, fmt.Errorf("invalid path cache interval %s", interval)
+	case interval == 0:
+		cfg.PathCacheInterval = uint64(DefaultPathCacheInterval.Nanoseconds())
+	default:
+		cfg.PathCacheInterval = uint64(interval.Nanoseconds())
+	}
+
+	switch depth := flags.Probing.MaxPathDepth; {
+	case depth <= 0:
+		cfg.MaxPathDepth = DefaultMaxPathDepth
+	case depth > MaxPathDepth:
+		err = fmt.Errorf("max path depth %d exceeds %d", depth, MaxPathDepth)
+	default:
+		cfg.MaxPathDepth = uint16(depth)
 	}
 
 	return
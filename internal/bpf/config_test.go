@@ -0,0 +1,61 @@
+package bpf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+)
+
+func TestGetConfigDedupInterval(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		want     uint64
+		wantErr  bool
+	}{
+		{interval: 0, want: 0},
+		{interval: time.Second, want: uint64(time.Second)},
+		{interval: 500 * time.Millisecond, want: uint64(500 * time.Millisecond)},
+		{interval: -time.Second, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		var flags config.Configuration
+		flags.Probing.DedupInterval = tt.interval
+
+		cfg, err := GetConfig(flags, false, false)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("GetConfig(%s) error = %v, wantErr %v", tt.interval, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && cfg.DedupInterval != tt.want {
+			t.Errorf("GetConfig(%s) DedupInterval = %d, want %d", tt.interval, cfg.DedupInterval, tt.want)
+		}
+	}
+}
+
+func TestGetConfigPathCacheInterval(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		want     uint64
+		wantErr  bool
+	}{
+		{interval: 0, want: uint64(DefaultPathCacheInterval)},
+		{interval: time.Minute, want: uint64(time.Minute)},
+		{interval: -time.Second, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		var flags config.Configuration
+		flags.Probing.PathCacheInterval = tt.interval
+
+		cfg, err := GetConfig(flags, false, false)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("GetConfig(%s) error = %v, wantErr %v", tt.interval, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && cfg.PathCacheInterval != tt.want {
+			t.Errorf("GetConfig(%s) PathCacheInterval = %d, want %d", tt.interval, cfg.PathCacheInterval, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package bpf
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	pb "github.com/cheggaaa/pb/v3"
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"k8s.io/klog/v2"
+)
+
+// FexitKprobeProgs fentry 模式下替代 kprobe 的 fexit 程序
+var FexitKprobeProgs = map[string]string{
+	"fexit_nfs_read_d":  "kb_nfs_read_d",
+	"fexit_nfs_write_d": "kb_nfs_write_d",
+}
+
+// TakeTracingSpecs 从 spec 中移出 fentry/fexit 程序。tracing 程序加载时就要确定目标函数，
+// 不能随主程序集一起加载，附加时再按目标函数单独加载
+func TakeTracingSpecs(spec *ebpf.CollectionSpec) map[string]*ebpf.ProgramSpec {
+	progs := make(map[string]*ebpf.ProgramSpec)
+	for name, prog := range spec.Programs {
+		if prog.Type != ebpf.Tracing {
+			continue
+		}
+
+		progs[name] = prog
+		delete(spec.Programs, name)
+	}
+
+	return progs
+}
+
+// Tracer 按目标函数加载并附加 fentry/fexit 程序，复用主程序集中的 map
+type Tracer struct {
+	spec  *ebpf.CollectionSpec
+	progs map[string]*ebpf.ProgramSpec
+	coll  *ebpf.Collection
+	opts  ebpf.ProgramOptions
+
+	links    []link.Link
+	programs []*ebpf.Program
+	// funcLinks AttachFuncs 附加成功的函数，交给 ProbeManager 用于运行时卸载
+	funcLinks []FuncLink
+}
+
+func NewTracer(spec *ebpf.CollectionSpec, progs map[string]*ebpf.ProgramSpec, coll *ebpf.Collection, opts ebpf.ProgramOptions) *Tracer {
+	return &Tracer{
+		spec:  spec,
+		progs: progs,
+		coll:  coll,
+		opts:  opts,
+	}
+}
+
+// Attach 附加 tracing 程序，target 为空时使用 SEC 中声明的函数
+func (t *Tracer) Attach(progName, target string) error {
+	progSpec, ok := t.progs[progName]
+	if !ok {
+		return fmt.Errorf("program %s not found", progName)
+	}
+
+	progSpec = progSpec.Copy()
+	if target != "" {
+		progSpec.AttachTo = target
+	}
+
+	spec := &ebpf.CollectionSpec{
+		Maps:      t.spec.Maps,
+		Programs:  map[string]*ebpf.ProgramSpec{progName: progSpec},
+		Types:     t.spec.Types,
+		ByteOrder: t.spec.ByteOrder,
+	}
+
+	coll, err := ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{
+		Programs:        t.opts,
+		MapReplacements: t.coll.Maps,
+	})
+	if err != nil {
+		return fmt.Errorf("loading %s for %s: %w", progName, progSpec.AttachTo, err)
+	}
+	defer coll.Close()
+
+	prog := coll.DetachProgram(progName)
+	l, err := link.AttachTracing(link.TracingOptions{Program: prog})
+	if err != nil {
+		prog.Close()
+		return fmt.Errorf("attaching %s to %s: %w", progName, progSpec.AttachTo, err)
+	}
+
+	t.links = append(t.links, newOnceLink(l))
+	t.programs = append(t.programs, prog)
+
+	return nil
+}
+
+// AttachFuncs 按参数位置将 fentry_skb_X 附加到过滤出的函数，返回附加失败的函数，
+// 由调用方改用 kprobe 附加。fentry 程序与主程序集共享 map
+func (t *Tracer) AttachFuncs(ctx context.Context, funcs Funcs) Funcs {
+	log.Println("Attaching kprobes (via fentry)...")
+
+	start := time.Now()
+	failed := Funcs{}
+	bar := pb.StartNew(len(funcs))
+	for fn, pos := range funcs {
+		select {
+		case <-ctx.Done():
+			bar.Finish()
+			return nil
+		default:
+		}
+
+		bar.Increment()
+		if err := t.Attach(fmt.Sprintf("fentry_skb_%d", pos), fn); err != nil {
+			klog.V(2).Infof("Skip fentry %s: %v", fn, err)
+			failed[fn] = pos
+			continue
+		}
+		t.funcLinks = append(t.funcLinks, FuncLink{Link: t.links[len(t.links)-1], Funcs: []string{fn}})
+	}
+	bar.Finish()
+
+	log.Printf("Attached %d functions via fentry in %s (falling back to kprobe for %d)\n", len(funcs)-len(failed), time.Since(start).Round(time.Millisecond), len(failed))
+
+	return failed
+}
+
+// FuncLinks 返回 AttachFuncs 附加成功的函数对应的 link
+func (t *Tracer) FuncLinks() []FuncLink {
+	return t.funcLinks
+}
+
+// Detach 关闭所有 tracing link 和程序
+func (t *Tracer) Detach() {
+	log.Println("Detaching fentry/fexit...")
+
+	for _, l := range t.links {
+		_ = l.Close()
+	}
+
+	for _, p := range t.programs {
+		_ = p.Close()
+	}
+}
@@ -69,11 +69,7 @@ func GetFuncs(pattern, filterStruct, modelBTF string, spec *btf.Spec, kmods []st
 			}
 
 			if kprobeMulti {
-				availableFnName := fnName
-				if it.kmod != "" {
-					availableFnName = fmt.Sprintf("%s [%s]", fnName, it.kmod)
-				}
-				if _, ok := availableFuncs[availableFnName]; !ok {
+				if _, ok := availableFuncs[ksymName(fnName, it.kmod)]; !ok {
 					continue
 				}
 			}
@@ -85,8 +81,8 @@ func GetFuncs(pattern, filterStruct, modelBTF string, spec *btf.Spec, kmods []st
 					if strct, ok := ptr.Target.(*btf.Struct); ok {
 						if strct.Name == filterStruct && i <= 5 {
 							name := fnName
-							if kprobeMulti && it.kmod != "" {
-								name = fmt.Sprintf("%s [%s]", fnName, it.kmod)
+							if kprobeMulti {
+								name = ksymName(fnName, it.kmod)
 							}
 							funcs[name] = i
 							continue
@@ -0,0 +1,271 @@
+package bpf
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cilium/ebpf"
+)
+
+const (
+	FilterAllow = "allow"
+	FilterDeny  = "deny"
+
+	FilterCgroup = "cgroup"
+	FilterDev    = "dev"
+	FilterMount  = "mount"
+	FilterPid    = "pid"
+	FilterComm   = "comm"
+
+	filterCommLen = 16
+)
+
+// 与 bpf/trace.c 中 enum filter_type 保持一致
+var filterTypes = []string{FilterCgroup, FilterDev, FilterMount, FilterPid, FilterComm}
+
+var filterMapNames = map[string]string{
+	FilterCgroup: "filter_cgroup",
+	FilterDev:    "filter_dev",
+	FilterMount:  "filter_mount",
+	FilterPid:    "filter_pid",
+	FilterComm:   "filter_comm",
+}
+
+var filterActions = map[string]uint8{
+	FilterAllow: 1,
+	FilterDeny:  2,
+}
+
+type filterState struct {
+	Enabled uint32
+	Allow   [5]uint32
+}
+
+type commKey struct {
+	Prefixlen uint32
+	Comm      [filterCommLen]byte
+}
+
+// FilterRule 一条内核态事件过滤规则
+type FilterRule struct {
+	Action string `json:"action"`
+	Type   string `json:"type"`
+	Value  string `json:"value"`
+}
+
+// EventFilter 维护内核态的事件过滤 map，支持从配置加载和运行时增删
+type EventFilter struct {
+	mu    sync.Mutex
+	coll  *ebpf.Collection
+	rules map[string]map[string]string // type -> value -> action
+}
+
+// Filters 全局的事件过滤器，加载 eBPF 程序后通过 WithCollection 关联 map
+var Filters = &EventFilter{rules: make(map[string]map[string]string)}
+
+func (f *EventFilter) WithCollection(coll *ebpf.Collection) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.coll = coll
+}
+
+// Apply 将配置中的规则写入内核
+func (f *EventFilter) Apply(cfg config.EventFilterConfig) error {
+	for action, rules := range map[string]config.EventFilterRules{FilterAllow: cfg.Allow, FilterDeny: cfg.Deny} {
+		values := map[string][]string{
+			FilterCgroup: rules.Cgroups,
+			FilterDev:    rules.Devs,
+			FilterMount:  rules.Mounts,
+			FilterPid:    rules.Pids,
+			FilterComm:   rules.Comms,
+		}
+
+		for typ, vs := range values {
+			for _, v := range vs {
+				if err := f.Add(FilterRule{Action: action, Type: typ, Value: v}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Add 增加或覆盖一条规则
+func (f *EventFilter) Add(rule FilterRule) error {
+	action, ok := filterActions[rule.Action]
+	if !ok {
+		return fmt.Errorf("unknown filter action %q", rule.Action)
+	}
+
+	key, err := parseFilterKey(rule.Type, rule.Value)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m, err := f.filterMap(rule.Type)
+	if err != nil {
+		return err
+	}
+
+	if err := m.Put(key, action); err != nil {
+		return fmt.Errorf("update %s filter %s: %w", rule.Type, rule.Value, err)
+	}
+
+	if f.rules[rule.Type] == nil {
+		f.rules[rule.Type] = make(map[string]string)
+	}
+	f.rules[rule.Type][rule.Value] = rule.Action
+
+	return f.sync()
+}
+
+// Delete 删除一条规则
+func (f *EventFilter) Delete(rule FilterRule) error {
+	key, err := parseFilterKey(rule.Type, rule.Value)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.rules[rule.Type][rule.Value]; !ok {
+		return fmt.Errorf("%s filter %s not found", rule.Type, rule.Value)
+	}
+
+	m, err := f.filterMap(rule.Type)
+	if err != nil {
+		return err
+	}
+
+	if err := m.Delete(key); err != nil {
+		return fmt.Errorf("delete %s filter %s: %w", rule.Type, rule.Value, err)
+	}
+	delete(f.rules[rule.Type], rule.Value)
+
+	return f.sync()
+}
+
+// List 返回当前的规则
+func (f *EventFilter) List() []FilterRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rules := make([]FilterRule, 0)
+	for _, typ := range filterTypes {
+		for value, action := range f.rules[typ] {
+			rules = append(rules, FilterRule{Action: action, Type: typ, Value: value})
+		}
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Type != rules[j].Type {
+			return rules[i].Type < rules[j].Type
+		}
+		return rules[i].Value < rules[j].Value
+	})
+
+	return rules
+}
+
+func (f *EventFilter) filterMap(typ string) (*ebpf.Map, error) {
+	if f.coll == nil {
+		return nil, fmt.Errorf("event filter is not initialized")
+	}
+
+	m, ok := f.coll.Maps[filterMapNames[typ]]
+	if !ok {
+		return nil, fmt.Errorf("unknown filter type %q", typ)
+	}
+
+	return m, nil
+}
+
+// sync 更新 filter_state 并清空进程过滤结果缓存，调用方需持有锁
+func (f *EventFilter) sync() error {
+	var state filterState
+	for i, typ := range filterTypes {
+		for _, action := range f.rules[typ] {
+			state.Enabled = 1
+			if action == FilterAllow {
+				state.Allow[i]++
+			}
+		}
+	}
+
+	if err := f.coll.Maps["filter_state"].Put(uint32(0), state); err != nil {
+		return fmt.Errorf("update filter state: %w", err)
+	}
+
+	cache := f.coll.Maps["filter_task_cache"]
+	var pid uint32
+	var keys []uint32
+	iter := cache.Iterate()
+	var v uint8
+	for iter.Next(&pid, &v) {
+		keys = append(keys, pid)
+	}
+	for _, k := range keys {
+		_ = cache.Delete(k)
+	}
+
+	return iter.Err()
+}
+
+// parseFilterKey 将规则的值转换为对应 map 的 key
+func parseFilterKey(typ, value string) (interface{}, error) {
+	switch typ {
+	case FilterCgroup:
+		// cgroup v2 路径或者 cgroup id
+		if id, err := strconv.ParseUint(value, 10, 64); err == nil {
+			return id, nil
+		}
+
+		info, err := os.Stat(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cgroup %q: %w", value, err)
+		}
+		return info.Sys().(*syscall.Stat_t).Ino, nil
+	case FilterDev:
+		// major:minor 或者内核中的 s_dev
+		if major, minor, ok := strings.Cut(value, ":"); ok {
+			ma, err := strconv.ParseUint(major, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid dev %q: %w", value, err)
+			}
+			mi, err := strconv.ParseUint(minor, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid dev %q: %w", value, err)
+			}
+			return uint32(ma<<20 | mi), nil
+		}
+		fallthrough
+	case FilterMount, FilterPid:
+		v, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", typ, value, err)
+		}
+		return uint32(v), nil
+	case FilterComm:
+		if value == "" || len(value) >= filterCommLen {
+			return nil, fmt.Errorf("invalid comm prefix %q", value)
+		}
+
+		key := commKey{Prefixlen: uint32(len(value) * 8)}
+		copy(key.Comm[:], value)
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unknown filter type %q", typ)
+	}
+}
@@ -0,0 +1,42 @@
+package bpf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFilterKey(t *testing.T) {
+	comm := commKey{Prefixlen: 24}
+	copy(comm.Comm[:], "dd_")
+
+	tests := []struct {
+		name    string
+		typ     string
+		value   string
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "cgroup id", typ: FilterCgroup, value: "4026", want: uint64(4026)},
+		{name: "dev major:minor", typ: FilterDev, value: "0:52", want: uint32(52)},
+		{name: "dev with major", typ: FilterDev, value: "8:1", want: uint32(8<<20 | 1)},
+		{name: "dev s_dev", typ: FilterDev, value: "8388609", want: uint32(8388609)},
+		{name: "mount id", typ: FilterMount, value: "1343", want: uint32(1343)},
+		{name: "pid", typ: FilterPid, value: "42", want: uint32(42)},
+		{name: "comm prefix", typ: FilterComm, value: "dd_", want: comm},
+		{name: "comm too long", typ: FilterComm, value: "abcdefghijklmnop", wantErr: true},
+		{name: "invalid pid", typ: FilterPid, value: "abc", wantErr: true},
+		{name: "unknown type", typ: "inode", value: "1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFilterKey(tt.typ, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseFilterKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFilterKey() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -9,8 +9,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"k8s.io/klog/v2"
 
@@ -22,19 +24,45 @@ import (
 
 type kprober struct {
 	links []link.Link
+	// funcLinks 过滤出的函数对应的 link，交给 ProbeManager 用于运行时卸载
+	funcLinks []FuncLink
 
 	kprobeMulti bool
 	kprobeBatch uint
 }
 
+// FuncLink 附加到过滤出的函数的 link。kprobe 和 fentry 模式下每个函数一个，
+// kprobe-multi 模式下同一参数位置的函数共享一个，卸载部分函数时需要用 Prog 重新附加剩余的函数
+type FuncLink struct {
+	Link  link.Link
+	Prog  *ebpf.Program
+	Funcs []string
+}
+
+// onceLink 同时由附加者和 ProbeManager 持有，运行时卸载后退出时不会再次关闭
+type onceLink struct {
+	link.Link
+	once sync.Once
+	err  error
+}
+
+func newOnceLink(l link.Link) *onceLink {
+	return &onceLink{Link: l}
+}
+
+func (l *onceLink) Close() error {
+	l.once.Do(func() { l.err = l.Link.Close() })
+	return l.err
+}
+
 type Kprobe struct {
 	hookFunc  string // internal use
 	HookFuncs []string
 	Prog      *ebpf.Program
 }
 
-func attachKprobes(ctx context.Context, bar *pb.ProgressBar, kprobes []Kprobe) (links []link.Link, ignored int, err error) {
-	links = make([]link.Link, 0, len(kprobes))
+func attachKprobes(ctx context.Context, bar *pb.ProgressBar, kprobes []Kprobe) (links []FuncLink, ignored int, err error) {
+	links = make([]FuncLink, 0, len(kprobes))
 	for _, kprobe := range kprobes {
 		select {
 		case <-ctx.Done():
@@ -54,7 +82,7 @@ func attachKprobes(ctx context.Context, bar *pb.ProgressBar, kprobes []Kprobe) (
 				ignored++
 			}
 		} else {
-			links = append(links, kp)
+			links = append(links, FuncLink{Link: newOnceLink(kp), Prog: kprobe.Prog, Funcs: []string{kprobe.hookFunc}})
 		}
 
 		bar.Increment()
@@ -64,7 +92,7 @@ func attachKprobes(ctx context.Context, bar *pb.ProgressBar, kprobes []Kprobe) (
 }
 
 // AttachKprobes attaches kprobes concurrently.
-func AttachKprobes(ctx context.Context, bar *pb.ProgressBar, kps []Kprobe, batch uint) (links []link.Link, ignored int) {
+func AttachKprobes(ctx context.Context, bar *pb.ProgressBar, kps []Kprobe, batch uint) (links []FuncLink, ignored int) {
 	if batch == 0 {
 		log.Fatal("--filter-kprobe-batch must be greater than 0")
 	}
@@ -86,7 +114,7 @@ func AttachKprobes(ctx context.Context, bar *pb.ProgressBar, kps []Kprobe, batch
 	errg, ctx := errgroup.WithContext(ctx)
 
 	var mu sync.Mutex
-	links = make([]link.Link, 0, len(kprobes))
+	links = make([]FuncLink, 0, len(kprobes))
 
 	attaching := func(kprobes []Kprobe) error {
 		l, i, e := attachKprobes(ctx, bar, kprobes)
@@ -162,8 +190,8 @@ func (k *kprober) DetachKprobes() {
 }
 
 // AttachKprobeMulti attaches kprobe-multi serially.
-func AttachKprobeMulti(ctx context.Context, bar *pb.ProgressBar, kprobes []Kprobe, a2n Addr2Name) (links []link.Link, ignored int) {
-	links = make([]link.Link, 0, len(kprobes))
+func AttachKprobeMulti(ctx context.Context, bar *pb.ProgressBar, kprobes []Kprobe, a2n Addr2Name) (links []FuncLink, ignored int) {
+	links = make([]FuncLink, 0, len(kprobes))
 
 	for _, kp := range kprobes {
 		select {
@@ -173,9 +201,11 @@ func AttachKprobeMulti(ctx context.Context, bar *pb.ProgressBar, kprobes []Kprob
 		}
 
 		addrs := make([]uintptr, 0, len(kp.HookFuncs))
+		fns := make([]string, 0, len(kp.HookFuncs))
 		for _, fn := range kp.HookFuncs {
 			if addr, ok := a2n.Name2AddrMap[fn]; ok {
 				addrs = append(addrs, addr...)
+				fns = append(fns, fn)
 			} else {
 				ignored += 1
 				bar.Increment()
@@ -194,7 +224,7 @@ func AttachKprobeMulti(ctx context.Context, bar *pb.ProgressBar, kprobes []Kprob
 			log.Fatalf("Opening kprobe-multi: %s\n", err)
 		}
 
-		links = append(links, l)
+		links = append(links, FuncLink{Link: newOnceLink(l), Prog: kp.Prog, Funcs: fns})
 	}
 
 	return
@@ -207,6 +237,7 @@ func NewKprober(ctx context.Context, funcs Funcs, coll *ebpf.Collection, a2n Add
 	}
 	log.Printf("Attaching kprobes (via %s)...\n", msg)
 
+	start := time.Now()
 	ignored := 0
 	bar := pb.StartNew(len(funcs))
 
@@ -233,52 +264,155 @@ func NewKprober(ctx context.Context, funcs Funcs, coll *ebpf.Collection, a2n Add
 
 	if !useKprobeMulti {
 		l, i := AttachKprobes(ctx, bar, traceKprobes, batch)
-		k.links = l
+		k.funcLinks = l
 		ignored += i
 	} else {
 		l, i := AttachKprobeMulti(ctx, bar, traceKprobes, a2n)
-		k.links = l
+		k.funcLinks = l
 		ignored += i
 	}
+	for _, l := range k.funcLinks {
+		k.links = append(k.links, l.Link)
+	}
 	bar.Finish()
 	select {
 	case <-ctx.Done():
 		return nil
 	default:
 	}
-	log.Printf("Attached (ignored %d)\n", ignored)
+	log.Printf("Attached %d functions via %s in %s (ignored %d)\n", len(funcs)-ignored, msg, time.Since(start).Round(time.Millisecond), ignored)
 
 	return &k
 }
 
-func NewCustomFuncsKprober(manifest map[string]string, coll *ebpf.Collection) *kprober {
+// FuncLinks 返回过滤出的函数对应的 link
+func (k *kprober) FuncLinks() []FuncLink {
+	if k == nil {
+		return nil
+	}
+	return k.funcLinks
+}
+
+func NewCustomFuncsKprober(manifest map[string]string, coll *ebpf.Collection) (*kprober, error) {
 	var k kprober
 	k.kprobeBatch = uint(len(manifest))
 
 	for progName, targetName := range manifest {
 		kp, err := link.Kprobe(targetName, coll.Programs[progName], nil)
 		if err != nil {
-			log.Fatalf("Opening kprobe %s: %s\n", targetName, err)
+			// 卸载已附加的部分，由调用方决定退出还是回退
+			k.DetachKprobes()
+			return nil, fmt.Errorf("opening kprobe %s: %w", targetName, err)
 		}
 
 		k.links = append(k.links, kp)
 	}
 
-	return &k
+	return &k, nil
 }
 
-func NewCustomKretprobes(manifest map[string]string, coll *ebpf.Collection) *kprober {
+func NewCustomKretprobes(manifest map[string]string, coll *ebpf.Collection) (*kprober, error) {
 	var k kprober
 	k.kprobeBatch = uint(len(manifest))
 
 	for progName, targetName := range manifest {
 		kp, err := link.Kretprobe(targetName, coll.Programs[progName], nil)
 		if err != nil {
-			log.Fatalf("Opening kretprobe %s: %s\n", targetName, err)
+			// 卸载已附加的部分，由调用方决定退出还是回退
+			k.DetachKprobes()
+			return nil, fmt.Errorf("opening kretprobe %s: %w", targetName, err)
 		}
 
 		k.links = append(k.links, kp)
 	}
 
+	return &k, nil
+}
+
+// NewOptionalKprober 附加可选的 kprobe/kretprobe，内核中不存在的函数将被跳过
+func NewOptionalKprober(manifest map[string]string, coll *ebpf.Collection, kretprobe bool) *kprober {
+	var k kprober
+	k.kprobeBatch = uint(len(manifest))
+
+	attach := link.Kprobe
+	if kretprobe {
+		attach = link.Kretprobe
+	}
+
+	ignored := 0
+	for progName, targetName := range manifest {
+		prog, ok := coll.Programs[progName]
+		if !ok {
+			ignored++
+			continue
+		}
+
+		kp, err := attach(targetName, prog, nil)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, syscall.EADDRNOTAVAIL) {
+				log.Fatalf("Opening kprobe %s: %s\n", targetName, err)
+			}
+
+			klog.Warningf("kprobe %s not found, skipped", targetName)
+			ignored++
+			continue
+		}
+
+		k.links = append(k.links, kp)
+	}
+
+	log.Printf("Attached optional kprobes (ignored %d)\n", ignored)
+
 	return &k
 }
+
+const (
+	AttachModeAuto        = "auto"
+	AttachModeKprobe      = "kprobe"
+	AttachModeKprobeMulti = "kprobe-multi"
+	AttachModeFentry      = "fentry"
+)
+
+// ResolveAttachMode 根据配置和内核特性确定附加方式，auto 时依次尝试 kprobe-multi（5.18+）和 kprobe。
+// fentry 每个函数需要单独加载一个程序，过滤出的函数较多时启动慢、占用内存多，只在显式指定时使用
+func ResolveAttachMode(mode string) (string, error) {
+	switch mode {
+	case AttachModeKprobe:
+		return AttachModeKprobe, nil
+	case AttachModeKprobeMulti:
+		if !HaveBPFLinkKprobeMulti() {
+			return "", errors.New("kprobe-multi is not supported by the kernel")
+		}
+		if !HaveAvailableFilterFunctions() {
+			return "", errors.New("kprobe-multi requires available_filter_functions (is /sys/kernel/debug/tracing mounted?)")
+		}
+		return AttachModeKprobeMulti, nil
+	case AttachModeFentry:
+		if !HaveBPFLinkTracing() {
+			return "", errors.New("fentry is not supported by the kernel")
+		}
+		return AttachModeFentry, nil
+	case AttachModeAuto, "":
+		// kprobe-multi 依赖 available_filter_functions 过滤不可附加的函数
+		if HaveBPFLinkKprobeMulti() && HaveAvailableFilterFunctions() {
+			return AttachModeKprobeMulti, nil
+		}
+		return AttachModeKprobe, nil
+	default:
+		return "", fmt.Errorf("unknown attach mode %q", mode)
+	}
+}
+
+// SetKprobeAttachType 设置 kprobe_skb_* 的附加类型，使同一个 ELF 可以按 kprobe 或 kprobe-multi 加载
+func SetKprobeAttachType(spec *ebpf.CollectionSpec, kprobeMulti bool) {
+	attachType := ebpf.AttachNone
+	if kprobeMulti {
+		attachType = ebpf.AttachTraceKprobeMulti
+	}
+
+	for name, prog := range spec.Programs {
+		if strings.HasPrefix(name, "kprobe_skb_") {
+			prog.AttachType = attachType
+		}
+	}
+}
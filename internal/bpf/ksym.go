@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"os"
 	"fmt"
+	"io"
 	"sort"
 	"strconv"
 	"strings"
@@ -33,36 +34,64 @@ type Addr2Name struct {
 }
 
 func (a *Addr2Name) FindNearestSym(ip uint64) string {
-	total := len(a.Addr2NameSlice)
-	i, j := 0, total
-	for i < j {
-		h := int(uint(i+j) >> 1)
-		if a.Addr2NameSlice[h].addr <= ip {
-			if h+1 < total && a.Addr2NameSlice[h+1].addr > ip {
-				return strings.Replace(a.Addr2NameSlice[h].name, "\t", "", -1)
-			}
-			i = h + 1
-		} else {
-			j = h
-		}
+	sym := a.findSym(ip)
+	if sym == nil {
+		return ""
 	}
-	return strings.Replace(a.Addr2NameSlice[i-1].name, "\t", "", -1)
+	return strings.Replace(sym.name, "\t", "", -1)
 }
 
-func ParseKallsyms(funcs Funcs, all bool) (Addr2Name, BpfProgName2Addr, error) {
-	a2n := Addr2Name{
-		Addr2NameMap: make(map[uint64]*ksym),
-		Name2AddrMap: make(map[string][]uintptr),
+// Symbolize 将地址转换为 "函数名+偏移 [模块名]" 的形式
+func (a *Addr2Name) Symbolize(ip uint64) string {
+	sym := a.findSym(ip)
+	if sym == nil {
+		return fmt.Sprintf("0x%x", ip)
 	}
-	n2a := BpfProgName2Addr{}
 
+	fn, mod, ok := strings.Cut(sym.name, "\t")
+	if !ok {
+		return fmt.Sprintf("%s+0x%x", fn, ip-sym.addr)
+	}
+	return fmt.Sprintf("%s+0x%x %s", fn, ip-sym.addr, mod)
+}
+
+// findSym 查找地址不大于 ip 的最近符号
+func (a *Addr2Name) findSym(ip uint64) *ksym {
+	i := sort.Search(len(a.Addr2NameSlice), func(i int) bool {
+		return a.Addr2NameSlice[i].addr > ip
+	})
+	if i == 0 {
+		return nil
+	}
+	return a.Addr2NameSlice[i-1]
+}
+
+// ksymName 返回函数在 kallsyms 中的名称，内核模块中的函数形如 "nfs_file_read\t[nfs]"
+func ksymName(fn, kmod string) string {
+	if kmod == "" {
+		return fn
+	}
+	return fmt.Sprintf("%s\t[%s]", fn, kmod)
+}
+
+func ParseKallsyms(funcs Funcs, all bool) (Addr2Name, BpfProgName2Addr, error) {
 	file, err := os.Open(config.GetProcPath("kallsyms"))
 	if err != nil {
-		return a2n, n2a, err
+		return Addr2Name{}, BpfProgName2Addr{}, err
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	return parseKallsyms(file, funcs, all)
+}
+
+func parseKallsyms(r io.Reader, funcs Funcs, all bool) (Addr2Name, BpfProgName2Addr, error) {
+	a2n := Addr2Name{
+		Addr2NameMap: make(map[uint64]*ksym),
+		Name2AddrMap: make(map[string][]uintptr),
+	}
+	n2a := BpfProgName2Addr{}
+
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := strings.Split(scanner.Text(), " ")
 		name, isBpfProg := extractBpfProgName(line[2])
@@ -80,6 +109,10 @@ func ParseKallsyms(funcs Funcs, all bool) (Addr2Name, BpfProgName2Addr, error) {
 			}
 			a2n.Addr2NameMap[addr] = sym
 			a2n.Name2AddrMap[name] = append(a2n.Name2AddrMap[name], uintptr(addr))
+			// 内核模块中的符号见 ksymName，额外按函数名索引
+			if fn, _, ok := strings.Cut(name, "\t"); ok {
+				a2n.Name2AddrMap[fn] = append(a2n.Name2AddrMap[fn], uintptr(addr))
+			}
 			if all {
 				a2n.Addr2NameSlice = append(a2n.Addr2NameSlice, sym)
 			}
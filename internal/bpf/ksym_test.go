@@ -0,0 +1,57 @@
+package bpf
+
+import (
+	"strings"
+	"testing"
+)
+
+const availableFilterFunctions = `vfs_read
+nfs_file_read [nfs]
+nfs_file_write [nfs]
+`
+
+const kallsyms = `ffffffff81400000 T vfs_read
+ffffffffc0a01000 t nfs_file_read	[nfs]
+ffffffffc0a02000 t nfs_file_write	[nfs]
+ffffffffc0b01000 t nfs4_file_open	[nfsv4]
+`
+
+func TestKsymNameRoundTrip(t *testing.T) {
+	available, err := parseAvailableFilterFunctions(strings.NewReader(availableFilterFunctions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// GetFuncs 按 available_filter_functions 过滤后，以相同名称作为 ParseKallsyms 的输入
+	funcs := Funcs{}
+	for _, fn := range []struct{ name, kmod string }{
+		{"vfs_read", ""},
+		{"nfs_file_read", "nfs"},
+		{"nfs4_file_open", "nfsv4"},
+	} {
+		name := ksymName(fn.name, fn.kmod)
+		if _, ok := available[name]; ok {
+			funcs[name] = 1
+		}
+	}
+	if len(funcs) != 2 {
+		t.Fatalf("funcs = %v, want vfs_read and nfs_file_read", funcs)
+	}
+
+	a2n, _, err := parseKallsyms(strings.NewReader(kallsyms), funcs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name := range funcs {
+		if addrs := a2n.Name2AddrMap[name]; len(addrs) != 1 {
+			t.Errorf("Name2AddrMap[%q] = %v, want 1 address", name, addrs)
+		}
+	}
+	if _, ok := a2n.Name2AddrMap[ksymName("nfs_file_write", "nfs")]; ok {
+		t.Error("nfs_file_write should not be indexed")
+	}
+	if addrs := a2n.Name2AddrMap["nfs_file_read"]; len(addrs) != 1 || addrs[0] != 0xffffffffc0a01000 {
+		t.Errorf("Name2AddrMap[nfs_file_read] = %v, want [0xffffffffc0a01000]", addrs)
+	}
+}
@@ -0,0 +1,253 @@
+package bpf
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/cache"
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	EvictionLRU  = "lru"
+	EvictionNone = "none"
+
+	DefaultMapWarnPercent = 90
+
+	// 哈希类 map 每个元素的额外开销，只用于粗略估算内存
+	hashEntryOverhead = 64
+)
+
+// MapUsage map 当前的元素数量和容量
+type MapUsage struct {
+	Entries    uint32
+	MaxEntries uint32
+}
+
+// SetupMapSizes 按配置修改 map 的容量和淘汰策略，需要在加载前调用
+func SetupMapSizes(spec *ebpf.CollectionSpec, cfg config.MapsConfig) error {
+	for name, size := range cfg.Sizes {
+		m, err := sizableMap(spec, name)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			continue
+		}
+
+		if size == 0 {
+			return fmt.Errorf("invalid size 0 for map %s", name)
+		}
+		m.MaxEntries = size
+	}
+
+	for name, policy := range cfg.Eviction {
+		m, err := sizableMap(spec, name)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			continue
+		}
+
+		if err := setEviction(m, policy); err != nil {
+			return fmt.Errorf("map %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// sizableMap 返回可以调整容量的 map，map 因功能未启用被删除时返回 nil，名称是否存在见 CheckMapNames
+func sizableMap(spec *ebpf.CollectionSpec, name string) (*ebpf.MapSpec, error) {
+	m, ok := spec.Maps[name]
+	if !ok {
+		log.Warningf("Map %s is not loaded, ignore its size config", name)
+		return nil, nil
+	}
+
+	switch m.Type {
+	case ebpf.RingBuf, ebpf.PerfEventArray:
+		return nil, fmt.Errorf("map %s is an event channel, use transport config instead", name)
+	case ebpf.Array:
+		return nil, fmt.Errorf("map %s has a fixed size", name)
+	}
+
+	return m, nil
+}
+
+func setEviction(m *ebpf.MapSpec, policy string) error {
+	switch policy {
+	case EvictionLRU:
+		switch m.Type {
+		case ebpf.Hash:
+			m.Type = ebpf.LRUHash
+		case ebpf.PerCPUHash:
+			m.Type = ebpf.LRUCPUHash
+		case ebpf.LRUHash, ebpf.LRUCPUHash:
+			return nil
+		default:
+			return fmt.Errorf("eviction is not supported for %s", m.Type)
+		}
+		// LRU map 不支持 BPF_F_NO_PREALLOC
+		m.Flags &^= unix.BPF_F_NO_PREALLOC
+	case EvictionNone:
+		switch m.Type {
+		case ebpf.LRUHash:
+			m.Type = ebpf.Hash
+		case ebpf.LRUCPUHash:
+			m.Type = ebpf.PerCPUHash
+		}
+	default:
+		return fmt.Errorf("unknown eviction policy %q", policy)
+	}
+
+	return nil
+}
+
+// EstimateMapMemory 粗略估算 spec 中所有 map 占用的内存
+func EstimateMapMemory(spec *ebpf.CollectionSpec) uint64 {
+	var total uint64
+	for _, m := range spec.Maps {
+		entries := uint64(m.MaxEntries)
+		switch m.Type {
+		case ebpf.RingBuf:
+			total += entries
+		case ebpf.PerfEventArray:
+			// perf buffer 在用户态创建 reader 时分配
+		case ebpf.Array, ebpf.StackTrace:
+			total += entries * align8(m.ValueSize)
+		default:
+			total += entries * (align8(m.KeySize) + align8(m.ValueSize) + hashEntryOverhead)
+		}
+	}
+
+	return total
+}
+
+// MemlockLimit 返回当前的 RLIMIT_MEMLOCK
+func MemlockLimit() (uint64, error) {
+	var rlim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_MEMLOCK, &rlim); err != nil {
+		return 0, fmt.Errorf("get memlock limit: %w", err)
+	}
+
+	return rlim.Cur, nil
+}
+
+// CheckMemlock 检查 map 估算的内存是否超过 limit。只有 5.11 以前按 memlock 计费的内核需要检查，
+// limit 为移除限制前的 RLIMIT_MEMLOCK，超过时只返回错误由调用方决定如何处理
+func CheckMemlock(spec *ebpf.CollectionSpec, limit uint64) error {
+	total := EstimateMapMemory(spec)
+	log.Infof("Estimated BPF map memory: %d KiB", total>>10)
+
+	if limit == unix.RLIM_INFINITY || total <= limit {
+		return nil
+	}
+
+	return fmt.Errorf("estimated BPF map memory %d KiB exceeds memlock limit %d KiB", total>>10, limit>>10)
+}
+
+// CheckMapNames 检查 map 容量和淘汰策略配置中的名称，需要在按功能删除 map 之前调用
+func CheckMapNames(spec *ebpf.CollectionSpec, cfg config.MapsConfig) error {
+	names := make([]string, 0, len(cfg.Sizes)+len(cfg.Eviction))
+	for name := range cfg.Sizes {
+		names = append(names, name)
+	}
+	for name := range cfg.Eviction {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := spec.Maps[name]; !ok {
+			return fmt.Errorf("unknown map %s", name)
+		}
+	}
+
+	return nil
+}
+
+func align8(n uint32) uint64 {
+	return (uint64(n) + 7) &^ 7
+}
+
+// WatchMapUsage 定期统计哈希类 map 的元素数量，超过 warnPercent 时输出告警
+func WatchMapUsage(ctx context.Context, coll *ebpf.Collection, warnPercent int, interval time.Duration) {
+	if warnPercent <= 0 {
+		warnPercent = DefaultMapWarnPercent
+	}
+
+	names := make([]string, 0, len(coll.Maps))
+	for name, m := range coll.Maps {
+		if countable(m.Type()) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	warned := make(map[string]bool)
+	for {
+		for _, name := range names {
+			m := coll.Maps[name]
+			entries, err := countEntries(m)
+			if err != nil {
+				log.Errorf("Failed to count entries of map %s: %v", name, err)
+				continue
+			}
+
+			usage := MapUsage{Entries: entries, MaxEntries: m.MaxEntries()}
+			cache.BPFMapUsageMap.Store(name, usage)
+
+			full := uint64(usage.Entries)*100 >= uint64(usage.MaxEntries)*uint64(warnPercent)
+			if full && !warned[name] {
+				log.Warningf("Map %s is near capacity (%d/%d), consider increasing maps.sizes.%s", name, usage.Entries, usage.MaxEntries, name)
+			}
+			warned[name] = full
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func countable(typ ebpf.MapType) bool {
+	switch typ {
+	case ebpf.Hash, ebpf.LRUHash, ebpf.PerCPUHash, ebpf.LRUCPUHash, ebpf.LPMTrie:
+		return true
+	default:
+		return false
+	}
+}
+
+// countEntries 遍历 map 的 key 统计元素数量，LRU map 遍历时可能有元素被淘汰，最多遍历两倍容量
+func countEntries(m *ebpf.Map) (uint32, error) {
+	var count uint32
+	var key []byte
+	for count < m.MaxEntries()*2 {
+		next, err := m.NextKeyBytes(key)
+		if err != nil {
+			return count, err
+		}
+		if next == nil {
+			break
+		}
+
+		count++
+		key = next
+	}
+
+	if count > m.MaxEntries() {
+		count = m.MaxEntries()
+	}
+
+	return count, nil
+}
@@ -0,0 +1,75 @@
+package bpf
+
+import (
+	"testing"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cilium/ebpf"
+)
+
+func TestSetupMapSizes(t *testing.T) {
+	newSpec := func() *ebpf.CollectionSpec {
+		return &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{
+			"io_metrics":     {Type: ebpf.LRUHash, KeySize: 16, ValueSize: 8, MaxEntries: 4096},
+			"pid_cgroup_map": {Type: ebpf.Hash, KeySize: 8, ValueSize: 8, MaxEntries: 2048},
+			"filter_state":   {Type: ebpf.Array, KeySize: 4, ValueSize: 24, MaxEntries: 1},
+			"nfs_trace_map":  {Type: ebpf.RingBuf, MaxEntries: 1 << 18},
+		}}
+	}
+
+	tests := []struct {
+		name    string
+		cfg     config.MapsConfig
+		wantErr bool
+		check   func(*ebpf.CollectionSpec) bool
+	}{
+		{
+			name: "resize and switch to lru",
+			cfg: config.MapsConfig{
+				Sizes:    map[string]uint32{"io_metrics": 16384, "pid_cgroup_map": 8192},
+				Eviction: map[string]string{"pid_cgroup_map": EvictionLRU, "io_metrics": EvictionNone},
+			},
+			check: func(s *ebpf.CollectionSpec) bool {
+				return s.Maps["io_metrics"].MaxEntries == 16384 && s.Maps["io_metrics"].Type == ebpf.Hash &&
+					s.Maps["pid_cgroup_map"].MaxEntries == 8192 && s.Maps["pid_cgroup_map"].Type == ebpf.LRUHash
+			},
+		},
+		{
+			name:  "disabled map is ignored",
+			cfg:   config.MapsConfig{Sizes: map[string]uint32{"dns_cache": 10}},
+			check: func(s *ebpf.CollectionSpec) bool { return len(s.Maps) == 4 },
+		},
+		{name: "zero size", cfg: config.MapsConfig{Sizes: map[string]uint32{"io_metrics": 0}}, wantErr: true},
+		{name: "event channel", cfg: config.MapsConfig{Sizes: map[string]uint32{"nfs_trace_map": 1024}}, wantErr: true},
+		{name: "fixed size array", cfg: config.MapsConfig{Sizes: map[string]uint32{"filter_state": 2}}, wantErr: true},
+		{name: "unknown eviction", cfg: config.MapsConfig{Eviction: map[string]string{"io_metrics": "fifo"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := newSpec()
+			err := SetupMapSizes(spec, tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetupMapSizes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.check != nil && !tt.check(spec) {
+				t.Errorf("SetupMapSizes() got unexpected spec")
+			}
+		})
+	}
+}
+
+func TestCheckMapNames(t *testing.T) {
+	spec := &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{
+		"io_metrics": {Type: ebpf.LRUHash, KeySize: 16, ValueSize: 8, MaxEntries: 4096},
+	}}
+
+	if err := CheckMapNames(spec, config.MapsConfig{Sizes: map[string]uint32{"io_metrics": 8192}}); err != nil {
+		t.Errorf("CheckMapNames() error = %v", err)
+	}
+	if err := CheckMapNames(spec, config.MapsConfig{Sizes: map[string]uint32{"io_metric": 8192}}); err == nil {
+		t.Error("CheckMapNames() should reject unknown size config")
+	}
+	if err := CheckMapNames(spec, config.MapsConfig{Eviction: map[string]string{"pid_map": EvictionLRU}}); err == nil {
+		t.Error("CheckMapNames() should reject unknown eviction config")
+	}
+}
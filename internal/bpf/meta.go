@@ -0,0 +1,130 @@
+package bpf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+)
+
+// MetaFunc 元数据操作追踪的函数，Arg 为需要读取的参数类型：dentry、path 或 file
+type MetaFunc struct {
+	Name string
+	Arg  string
+}
+
+// MetaFuncs 顺序与 bpf/trace.c 中 enum meta_func 保持一致
+var MetaFuncs = [...]MetaFunc{
+	{Name: "nfs_lookup", Arg: "dentry"},
+	{Name: "nfs_atomic_open", Arg: "dentry"},
+	{Name: "nfs_open", Arg: "file"},
+	{Name: "nfs4_file_open", Arg: "file"},
+	{Name: "nfs_getattr", Arg: "path"},
+	{Name: "nfs_setattr", Arg: "dentry"},
+	{Name: "nfs_create", Arg: "dentry"},
+	{Name: "nfs_unlink", Arg: "dentry"},
+	{Name: "nfs_rename", Arg: "dentry"},
+}
+
+// metaModules 元数据操作函数所在的内核模块，内置到内核时在 vmlinux 中
+var metaModules = []string{"nfs", "nfsv4"}
+
+// MetaPrograms 返回函数对应的 kprobe 和 kretprobe 程序名
+func MetaPrograms(fn string) (kprobe, kretprobe string) {
+	return "kb_meta_" + fn, "kretb_meta_" + fn
+}
+
+// SetupMetaProbes 根据内核 BTF 确定各函数中 dentry/path/file 参数的位置并写入 META_ARG_POS，
+// 找不到的函数删除对应的程序。返回需要附加的 kprobe 和 kretprobe
+func SetupMetaProbes(spec *ebpf.CollectionSpec, kernel *btf.Spec, modelDir string) (kprobes, kretprobes map[string]string, err error) {
+	specs := []*btf.Spec{kernel}
+	for _, mod := range metaModules {
+		f, err := os.Open(filepath.Join(modelDir, mod))
+		if err != nil {
+			continue
+		}
+
+		modSpec, err := btf.LoadSplitSpecFromReader(f, kernel)
+		f.Close()
+		if err != nil {
+			log.Warningf("加载 %s BTF 失败: %v", mod, err)
+			continue
+		}
+		specs = append(specs, modSpec)
+	}
+
+	kprobes = make(map[string]string)
+	kretprobes = make(map[string]string)
+	var positions [len(MetaFuncs)]uint8
+	for i, fn := range MetaFuncs {
+		kprobe, kretprobe := MetaPrograms(fn.Name)
+
+		pos, err := findParamPos(specs, fn)
+		if err != nil {
+			log.Warningf("跳过元数据操作函数 %s: %v", fn.Name, err)
+			delete(spec.Programs, kprobe)
+			delete(spec.Programs, kretprobe)
+			continue
+		}
+
+		positions[i] = pos
+		kprobes[kprobe] = fn.Name
+		kretprobes[kretprobe] = fn.Name
+	}
+
+	if len(kprobes) == 0 {
+		return nil, nil, errors.New("no nfs metadata function found in BTF")
+	}
+
+	return kprobes, kretprobes, spec.RewriteConstants(map[string]interface{}{
+		"META_ARG_POS": positions,
+	})
+}
+
+func findParamPos(specs []*btf.Spec, fn MetaFunc) (uint8, error) {
+	for _, spec := range specs {
+		types, err := spec.AnyTypesByName(fn.Name)
+		if err != nil {
+			continue
+		}
+
+		for _, typ := range types {
+			if f, ok := typ.(*btf.Func); ok {
+				return paramPos(f, fn.Arg)
+			}
+		}
+	}
+
+	return 0, errors.New("function not found in BTF")
+}
+
+// paramPos 返回第一个指向 arg 结构体的参数位置，kprobe 最多读取 5 个参数
+func paramPos(f *btf.Func, arg string) (uint8, error) {
+	proto, ok := f.Type.(*btf.FuncProto)
+	if !ok {
+		return 0, fmt.Errorf("%s has no prototype", f.Name)
+	}
+
+	for i, p := range proto.Params {
+		ptr, ok := p.Type.(*btf.Pointer)
+		if !ok {
+			continue
+		}
+
+		strct, ok := btf.UnderlyingType(ptr.Target).(*btf.Struct)
+		if !ok || strct.Name != arg {
+			continue
+		}
+
+		if i >= 5 {
+			return 0, fmt.Errorf("struct %s is parameter %d of %s, only 5 are supported", arg, i+1, f.Name)
+		}
+		return uint8(i + 1), nil
+	}
+
+	return 0, fmt.Errorf("%s has no struct %s parameter", f.Name, arg)
+}
@@ -0,0 +1,74 @@
+package bpf
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+func ptrTo(name string) btf.Type {
+	return &btf.Pointer{Target: &btf.Struct{Name: name}}
+}
+
+func TestParamPos(t *testing.T) {
+	integer := &btf.Int{Name: "unsigned int", Size: 4}
+
+	tests := []struct {
+		name    string
+		params  []btf.FuncParam
+		arg     string
+		want    uint8
+		wantErr bool
+	}{
+		{
+			name:   "nfs_create before 5.12",
+			params: []btf.FuncParam{{Type: ptrTo("inode")}, {Type: ptrTo("dentry")}, {Type: integer}},
+			arg:    "dentry",
+			want:   2,
+		},
+		{
+			name:   "nfs_create with mnt_idmap",
+			params: []btf.FuncParam{{Type: ptrTo("mnt_idmap")}, {Type: ptrTo("inode")}, {Type: ptrTo("dentry")}},
+			arg:    "dentry",
+			want:   3,
+		},
+		{
+			name:   "nfs_getattr with const path",
+			params: []btf.FuncParam{{Type: ptrTo("user_namespace")}, {Type: &btf.Pointer{Target: &btf.Const{Type: &btf.Struct{Name: "path"}}}}},
+			arg:    "path",
+			want:   2,
+		},
+		{
+			name:   "nfs_rename uses the first dentry",
+			params: []btf.FuncParam{{Type: ptrTo("inode")}, {Type: ptrTo("dentry")}, {Type: ptrTo("inode")}, {Type: ptrTo("dentry")}},
+			arg:    "dentry",
+			want:   2,
+		},
+		{
+			name:    "missing",
+			params:  []btf.FuncParam{{Type: ptrTo("inode")}, {Type: integer}},
+			arg:     "file",
+			wantErr: true,
+		},
+		{
+			name: "beyond the fifth parameter",
+			params: []btf.FuncParam{{Type: integer}, {Type: integer}, {Type: integer}, {Type: integer},
+				{Type: integer}, {Type: ptrTo("dentry")}},
+			arg:     "dentry",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &btf.Func{Name: "fn", Type: &btf.FuncProto{Params: tt.params}}
+			got, err := paramPos(f, tt.arg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("paramPos() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("paramPos() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
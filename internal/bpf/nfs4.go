@@ -0,0 +1,124 @@
+package bpf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cilium/ebpf"
+)
+
+// NFS4Layout nfs4 tracepoint 的字段偏移，与 bpf/trace.c 中 struct nfs4_layout 保持一致，0 表示不存在
+type NFS4Layout struct {
+	Error       uint16
+	Dev         uint16
+	FileID      uint16
+	Server      uint16
+	Slot        uint16
+	StatusFlags uint16
+	Session     uint16
+	Queue       uint16
+}
+
+// NFS4Tracepoint NFSv4 状态事件使用的 tracepoint
+type NFS4Tracepoint struct {
+	Prog  string
+	Group string
+	Name  string
+}
+
+// NFS4Tracepoints 顺序与 bpf/trace.c 中 enum nfs4_tp 保持一致
+var NFS4Tracepoints = [...]NFS4Tracepoint{
+	{Prog: "tp_nfs4_set_deleg", Group: "nfs4", Name: "nfs4_set_delegation"},
+	{Prog: "tp_nfs4_delegreturn", Group: "nfs4", Name: "nfs4_delegreturn"},
+	{Prog: "tp_nfs4_cb_recall", Group: "nfs4", Name: "nfs4_cb_recall"},
+	{Prog: "tp_nfs4_open_reclaim", Group: "nfs4", Name: "nfs4_open_reclaim"},
+	{Prog: "tp_nfs4_open_expired", Group: "nfs4", Name: "nfs4_open_expired"},
+	{Prog: "tp_nfs4_state_mgr", Group: "nfs4", Name: "nfs4_state_mgr"},
+	{Prog: "tp_nfs4_state_mgr_failed", Group: "nfs4", Name: "nfs4_state_mgr_failed"},
+	{Prog: "tp_nfs4_renew", Group: "nfs4", Name: "nfs4_renew"},
+	{Prog: "tp_nfs4_renew_async", Group: "nfs4", Name: "nfs4_renew_async"},
+	{Prog: "tp_nfs4_sequence_done", Group: "nfs4", Name: "nfs4_sequence_done"},
+	// 等待 NFSv4.1 会话 slot 的任务在 "ForeChannel Slot table" 队列上睡眠
+	{Prog: "tp_nfs4_slot_wait", Group: "sunrpc", Name: "rpc_task_sleep"},
+}
+
+// SetupNFS4Tracepoints 解析各 tracepoint 的格式并写入 NFS4_LAYOUTS，不存在的 tracepoint 删除对应的程序。
+// 返回按组划分的程序名到 tracepoint 的映射
+func SetupNFS4Tracepoints(spec *ebpf.CollectionSpec) (map[string]map[string]string, error) {
+	var layouts [len(NFS4Tracepoints)]NFS4Layout
+	attach := make(map[string]map[string]string)
+	for i, tp := range NFS4Tracepoints {
+		layout, err := detectNFS4Layout(tp.Group, tp.Name)
+		if err != nil {
+			log.Warningf("跳过 NFSv4 状态事件 %s/%s: %v", tp.Group, tp.Name, err)
+			delete(spec.Programs, tp.Prog)
+			continue
+		}
+
+		layouts[i] = layout
+		if attach[tp.Group] == nil {
+			attach[tp.Group] = make(map[string]string)
+		}
+		attach[tp.Group][tp.Prog] = tp.Name
+	}
+
+	if len(attach["nfs4"]) == 0 {
+		return nil, fmt.Errorf("no nfs4 tracepoint found, is the nfsv4 module loaded?")
+	}
+
+	return attach, spec.RewriteConstants(map[string]interface{}{
+		"NFS4_LAYOUTS": layouts,
+	})
+}
+
+func detectNFS4Layout(group, name string) (NFS4Layout, error) {
+	f, err := os.Open(filepath.Join(tracingEventsDir, group, name, "format"))
+	if err != nil {
+		return NFS4Layout{}, err
+	}
+	defer f.Close()
+
+	fields, err := parseTracepointFormat(f)
+	if err != nil {
+		return NFS4Layout{}, fmt.Errorf("parse %s/%s format: %w", group, name, err)
+	}
+
+	layout := matchNFS4Layout(fields)
+	if name == "rpc_task_sleep" && layout.Queue == 0 {
+		return NFS4Layout{}, fmt.Errorf("missing field q_name")
+	}
+	return layout, nil
+}
+
+// matchNFS4Layout 查找 nfs4 tracepoint 中关心的字段，不存在或大小不符的字段为 0
+func matchNFS4Layout(fields map[string]tracepointField) NFS4Layout {
+	lookup := func(size int, names ...string) uint16 {
+		for _, name := range names {
+			if field, ok := fields[name]; ok && field.size == size {
+				return uint16(field.offset)
+			}
+		}
+		return 0
+	}
+
+	// 新内核中 error、status_flags 为 unsigned long，按小端读取低 32 位
+	lookupInt := func(name string) uint16 {
+		if offset := lookup(4, name); offset != 0 {
+			return offset
+		}
+		return lookup(8, name)
+	}
+
+	return NFS4Layout{
+		Error:       lookupInt("error"),
+		Dev:         lookup(4, "dev"),
+		FileID:      lookup(8, "fileid"),
+		Server:      lookup(4, "dstaddr", "hostname"),
+		Slot:        lookup(4, "slot_nr"),
+		StatusFlags: lookupInt("status_flags"),
+		Session:     lookup(4, "session", "client_id"),
+		Queue:       lookup(4, "q_name"),
+	}
+}
@@ -0,0 +1,85 @@
+package bpf
+
+import (
+	"strings"
+	"testing"
+)
+
+const sequenceDoneFormat = `name: nfs4_sequence_done
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned int session;	offset:8;	size:4;	signed:0;
+	field:unsigned int slot_nr;	offset:12;	size:4;	signed:0;
+	field:unsigned int seq_nr;	offset:16;	size:4;	signed:0;
+	field:unsigned int highest_slotid;	offset:20;	size:4;	signed:0;
+	field:unsigned int target_highest_slotid;	offset:24;	size:4;	signed:0;
+	field:unsigned long status_flags;	offset:32;	size:8;	signed:0;
+	field:unsigned long error;	offset:40;	size:8;	signed:0;
+`
+
+const cbRecallFormat = `name: nfs4_cb_recall
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:int error;	offset:8;	size:4;	signed:1;
+	field:dev_t dev;	offset:12;	size:4;	signed:0;
+	field:u32 fhandle;	offset:16;	size:4;	signed:0;
+	field:u64 fileid;	offset:24;	size:8;	signed:0;
+	field:__data_loc char[] dstaddr;	offset:32;	size:4;	signed:1;
+	field:int stateid_seq;	offset:36;	size:4;	signed:1;
+	field:u32 stateid_hash;	offset:40;	size:4;	signed:0;
+`
+
+const rpcTaskSleepFormat = `name: rpc_task_sleep
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned int task_id;	offset:8;	size:4;	signed:0;
+	field:unsigned int client_id;	offset:12;	size:4;	signed:0;
+	field:unsigned long timeout;	offset:16;	size:8;	signed:0;
+	field:unsigned long runstate;	offset:24;	size:8;	signed:0;
+	field:int status;	offset:32;	size:4;	signed:1;
+	field:unsigned short flags;	offset:36;	size:2;	signed:0;
+	field:__data_loc char[] q_name;	offset:40;	size:4;	signed:1;
+`
+
+func TestMatchNFS4Layout(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		want   NFS4Layout
+	}{
+		{
+			name:   "sequence done",
+			format: sequenceDoneFormat,
+			want:   NFS4Layout{Error: 40, Slot: 12, StatusFlags: 32, Session: 8},
+		},
+		{
+			name:   "callback recall",
+			format: cbRecallFormat,
+			want:   NFS4Layout{Error: 8, Dev: 12, FileID: 24, Server: 32},
+		},
+		{
+			name:   "rpc task sleep",
+			format: rpcTaskSleepFormat,
+			want:   NFS4Layout{Session: 12, Queue: 40},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := parseTracepointFormat(strings.NewReader(tt.format))
+			if err != nil {
+				t.Fatalf("parseTracepointFormat() error = %v", err)
+			}
+
+			if got := matchNFS4Layout(fields); got != tt.want {
+				t.Errorf("matchNFS4Layout() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package bpf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cilium/ebpf"
+)
+
+const (
+	CompletionProbeAuto       = "auto"
+	CompletionProbeTracepoint = "tracepoint"
+	CompletionProbeKprobe     = "kprobe"
+)
+
+const tracingEventsDir = "/sys/kernel/debug/tracing/events"
+
+// NFSDoneLayout 读写完成 tracepoint 的字段偏移，与 bpf/trace.c 中 struct nfs_done_layout 保持一致
+type NFSDoneLayout struct {
+	Dev             uint16
+	FileID          uint16
+	Count           uint16
+	Status          uint16
+	Offset          uint16
+	ArgCount        uint16
+	Stable          uint16
+	CountFromStatus uint8
+}
+
+// nfsDoneFormat 读写完成 tracepoint 的一种已知格式
+type nfsDoneFormat struct {
+	kernels string
+	// count 为空时读写字节数取自 status
+	count  string
+	status string
+}
+
+// nfsDoneFormats nfs_readpage_done/nfs_writeback_done 的格式兼容表，按顺序匹配
+var nfsDoneFormats = []nfsDoneFormat{
+	// 参数为 rpc_task 和 nfs_pgio_header，status 改名为 error
+	{kernels: "5.15+", count: "res_count", status: "error"},
+	// 参数改为 rpc_task 和 nfs_pgio_header，新增 arg_count/res_count
+	{kernels: "5.7 - 5.14", count: "res_count", status: "status"},
+	// 参数为 inode，status 非负时为读写字节数
+	{kernels: "4.x - 5.6", status: "status"},
+}
+
+// nfsDonePrograms 读写完成 tracepoint 程序及其替代的 kprobe/fexit 程序
+var nfsDonePrograms = map[string][]string{
+	"tp_nfs_read_d":  {"kb_nfs_read_d", "fexit_nfs_read_d"},
+	"tp_nfs_write_d": {"kb_nfs_write_d", "fexit_nfs_write_d"},
+}
+
+type tracepointField struct {
+	offset int
+	size   int
+}
+
+// SetupNFSDoneTracepoints 按 mode 选择读写完成的统计方式。使用 tracepoint 时写入字段偏移并删除
+// kprobe/fexit 程序，否则删除 tracepoint 程序。返回是否使用 tracepoint
+func SetupNFSDoneTracepoints(spec *ebpf.CollectionSpec, mode string) (bool, error) {
+	use, err := useNFSDoneTracepoints(spec, mode)
+	if err != nil {
+		return false, err
+	}
+
+	for tp, probes := range nfsDonePrograms {
+		if !use {
+			delete(spec.Programs, tp)
+			continue
+		}
+		for _, name := range probes {
+			delete(spec.Programs, name)
+		}
+	}
+
+	return use, nil
+}
+
+func useNFSDoneTracepoints(spec *ebpf.CollectionSpec, mode string) (bool, error) {
+	switch mode {
+	case CompletionProbeKprobe:
+		return false, nil
+	case CompletionProbeTracepoint, CompletionProbeAuto, "":
+	default:
+		return false, fmt.Errorf("unknown completion probe %q", mode)
+	}
+
+	read, kernels, err := detectNFSDoneLayout("nfs_readpage_done")
+	var write NFSDoneLayout
+	if err == nil {
+		write, _, err = detectNFSDoneLayout("nfs_writeback_done")
+	}
+	if err != nil {
+		if mode == CompletionProbeTracepoint {
+			return false, err
+		}
+		log.Warningf("读写完成 tracepoint 不可用，回退到 kprobe: %v", err)
+		return false, nil
+	}
+
+	log.Infof("使用 tracepoint 统计 NFS 读写完成，格式：%s", kernels)
+	return true, spec.RewriteConstants(map[string]interface{}{
+		"READ_DONE_LAYOUT":  read,
+		"WRITE_DONE_LAYOUT": write,
+	})
+}
+
+func detectNFSDoneLayout(name string) (NFSDoneLayout, string, error) {
+	f, err := os.Open(filepath.Join(tracingEventsDir, "nfs", name, "format"))
+	if err != nil {
+		return NFSDoneLayout{}, "", err
+	}
+	defer f.Close()
+
+	fields, err := parseTracepointFormat(f)
+	if err != nil {
+		return NFSDoneLayout{}, "", fmt.Errorf("parse nfs/%s format: %w", name, err)
+	}
+
+	layout, kernels, err := matchNFSDoneLayout(fields)
+	if err != nil {
+		return NFSDoneLayout{}, "", fmt.Errorf("nfs/%s: %w", name, err)
+	}
+	return layout, kernels, nil
+}
+
+// matchNFSDoneLayout 在兼容表中查找与 tracepoint 字段匹配的格式
+func matchNFSDoneLayout(fields map[string]tracepointField) (NFSDoneLayout, string, error) {
+	lookup := func(name string, size int) (uint16, bool) {
+		field, ok := fields[name]
+		if !ok || field.size != size {
+			return 0, false
+		}
+		return uint16(field.offset), true
+	}
+
+	dev, ok := lookup("dev", 4)
+	if !ok {
+		return NFSDoneLayout{}, "", fmt.Errorf("missing field dev")
+	}
+	fileID, ok := lookup("fileid", 8)
+	if !ok {
+		return NFSDoneLayout{}, "", fmt.Errorf("missing field fileid")
+	}
+
+	for _, format := range nfsDoneFormats {
+		layout := NFSDoneLayout{Dev: dev, FileID: fileID}
+		if layout.Status, ok = lookup(format.status, 4); !ok {
+			continue
+		}
+
+		if format.count == "" {
+			layout.CountFromStatus = 1
+		} else if layout.Count, ok = lookup(format.count, 4); !ok {
+			continue
+		}
+
+		// 请求的位置、大小和写入的同步级别用于分析访问模式，不存在时为 0
+		layout.Offset, _ = lookup("offset", 8)
+		layout.ArgCount, _ = lookup("arg_count", 4)
+		layout.Stable, _ = lookup("stable", 4)
+
+		return layout, format.kernels, nil
+	}
+
+	return NFSDoneLayout{}, "", fmt.Errorf("unknown tracepoint format")
+}
+
+// parseTracepointFormat 解析 tracefs 中 format 文件的字段，如
+// "field:u64 fileid;	offset:16;	size:8;	signed:0;"
+func parseTracepointFormat(r io.Reader) (map[string]tracepointField, error) {
+	fields := make(map[string]tracepointField)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "field:") {
+			continue
+		}
+
+		var (
+			name  string
+			field tracepointField
+			err   error
+		)
+		for _, part := range strings.Split(line, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(part), ":")
+			if !ok {
+				continue
+			}
+
+			switch key {
+			case "field":
+				decl := strings.Fields(value)
+				if len(decl) == 0 {
+					return nil, fmt.Errorf("invalid field %q", line)
+				}
+				name, _, _ = strings.Cut(decl[len(decl)-1], "[")
+			case "offset":
+				field.offset, err = strconv.Atoi(value)
+			case "size":
+				field.size, err = strconv.Atoi(value)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid field %q: %w", line, err)
+			}
+		}
+
+		fields[name] = field
+	}
+
+	return fields, scanner.Err()
+}
@@ -0,0 +1,83 @@
+package bpf
+
+import (
+	"strings"
+	"testing"
+)
+
+const readDoneFormat = `name: nfs_readpage_done
+ID: 1532
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:dev_t dev;	offset:8;	size:4;	signed:0;
+	field:u32 fhandle;	offset:12;	size:4;	signed:0;
+	field:u64 fileid;	offset:16;	size:8;	signed:0;
+	field:loff_t offset;	offset:24;	size:8;	signed:1;
+	field:u32 arg_count;	offset:32;	size:4;	signed:0;
+	field:u32 res_count;	offset:36;	size:4;	signed:0;
+	field:bool eof;	offset:40;	size:1;	signed:0;
+	field:int error;	offset:44;	size:4;	signed:1;
+
+print fmt: "error=%d fileid=%02x:%02x:%llu", REC->error, MAJOR(REC->dev), MINOR(REC->dev), REC->fileid
+`
+
+const legacyWriteDoneFormat = `name: nfs_writeback_done
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:dev_t dev;	offset:8;	size:4;	signed:0;
+	field:u32 fhandle;	offset:12;	size:4;	signed:0;
+	field:u64 fileid;	offset:16;	size:8;	signed:0;
+	field:loff_t offset;	offset:24;	size:8;	signed:1;
+	field:int status;	offset:32;	size:4;	signed:1;
+	field:enum nfs3_stable_how stable;	offset:36;	size:4;	signed:0;
+	field:char verifier[8];	offset:40;	size:8;	signed:1;
+`
+
+func TestMatchNFSDoneLayout(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		want    NFSDoneLayout
+		kernels string
+		wantErr bool
+	}{
+		{
+			name:    "res_count and error",
+			format:  readDoneFormat,
+			want:    NFSDoneLayout{Dev: 8, FileID: 16, Count: 36, Status: 44, Offset: 24, ArgCount: 32},
+			kernels: "5.15+",
+		},
+		{
+			name:    "count from status",
+			format:  legacyWriteDoneFormat,
+			want:    NFSDoneLayout{Dev: 8, FileID: 16, Status: 32, Offset: 24, Stable: 36, CountFromStatus: 1},
+			kernels: "4.x - 5.6",
+		},
+		{
+			name:    "unknown",
+			format:  "format:\n\tfield:dev_t dev;\toffset:8;\tsize:4;\tsigned:0;\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := parseTracepointFormat(strings.NewReader(tt.format))
+			if err != nil {
+				t.Fatalf("parseTracepointFormat() error = %v", err)
+			}
+
+			got, kernels, err := matchNFSDoneLayout(fields)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("matchNFSDoneLayout() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want || kernels != tt.kernels {
+				t.Errorf("matchNFSDoneLayout() = %+v, %q, want %+v, %q", got, kernels, tt.want, tt.kernels)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package bpf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	DefaultPinPath = "/sys/fs/bpf/nfs-trace"
+
+	// PinSchemaVersion 固定 map 的结构版本，PinMaps 中 map 的 key/value 布局变化时需要递增
+	PinSchemaVersion uint32 = 1
+
+	pinSchemaMap = "schema_version"
+)
+
+// PinMaps 需要跨重启保留的 map，其余 map 每次启动重新创建
+var PinMaps = []string{"io_metrics", "pid_cgroup_map", "xprt_metrics", "clnt_xprt", "dev_xprt"}
+
+// SetupPinning 将 PinMaps 设置为按名称固定到 path，加载时复用已固定的 map。
+// 结构版本不一致或与 spec 不兼容的 map 会被删除后重新创建
+func SetupPinning(spec *ebpf.CollectionSpec, path string) error {
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return fmt.Errorf("create pin path %s: %w", path, err)
+	}
+
+	if err := checkBPFFS(path); err != nil {
+		return err
+	}
+
+	if err := checkPinSchema(path); err != nil {
+		return err
+	}
+
+	for _, name := range PinMaps {
+		m, ok := spec.Maps[name]
+		if !ok {
+			continue
+		}
+		m.Pinning = ebpf.PinByName
+
+		if err := removeIncompatiblePin(m, filepath.Join(path, m.Name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkPinSchema 检查固定 map 的结构版本，版本变化时删除旧的 map
+func checkPinSchema(path string) error {
+	schema, err := ebpf.NewMapWithOptions(&ebpf.MapSpec{
+		Name:       pinSchemaMap,
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+		Pinning:    ebpf.PinByName,
+	}, ebpf.MapOptions{PinPath: path})
+	if err != nil {
+		return fmt.Errorf("load pinned schema version: %w", err)
+	}
+	defer schema.Close()
+
+	var version uint32
+	if err := schema.Lookup(uint32(0), &version); err != nil {
+		return fmt.Errorf("lookup pinned schema version: %w", err)
+	}
+
+	if version == PinSchemaVersion {
+		return nil
+	}
+
+	if version != 0 {
+		log.Warningf("Pinned maps schema version %d is not %d, removing stale maps", version, PinSchemaVersion)
+		for _, name := range PinMaps {
+			if err := os.Remove(filepath.Join(path, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("remove stale pinned map %s: %w", name, err)
+			}
+		}
+	}
+
+	return schema.Put(uint32(0), PinSchemaVersion)
+}
+
+// removeIncompatiblePin 删除与 spec 不兼容的固定 map，例如修改了 map 容量
+func removeIncompatiblePin(spec *ebpf.MapSpec, pinned string) error {
+	m, err := ebpf.LoadPinnedMap(pinned, nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("load pinned map %s: %w", pinned, err)
+	}
+	defer m.Close()
+
+	if err := spec.Compatible(m); err != nil {
+		log.Warningf("Pinned map %s is incompatible (%v), recreating it", spec.Name, err)
+		return m.Unpin()
+	}
+
+	log.Infof("Reusing pinned map %s", pinned)
+
+	return nil
+}
+
+// Unpin 删除 path 下 nfs-trace 固定的 map，只删除已知的名称，目录为空时一并删除
+func Unpin(path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		log.Infof("Pin path %s does not exist", path)
+		return nil
+	}
+
+	if err := checkBPFFS(path); err != nil {
+		return err
+	}
+
+	for _, name := range append([]string{pinSchemaMap}, PinMaps...) {
+		if err := os.Remove(filepath.Join(path, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove pinned map %s: %w", name, err)
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		log.Warningf("Keep pin path %s: %v", path, err)
+	}
+
+	log.Infof("Removed pinned maps under %s", path)
+
+	return nil
+}
+
+func checkBPFFS(path string) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	if uint32(st.Type) != unix.BPF_FS_MAGIC {
+		return fmt.Errorf("%s is not a bpffs mount", path)
+	}
+
+	return nil
+}
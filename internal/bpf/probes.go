@@ -0,0 +1,402 @@
+package bpf
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/link"
+)
+
+const (
+	ProbeSourceStartup = "startup"
+	ProbeSourceRuntime = "runtime"
+
+	// maxRuntimeProbes 单次请求最多附加的函数数量，避免正则误匹配大量函数
+	maxRuntimeProbes = 512
+)
+
+// ProbeRequest 运行时附加或卸载函数的请求
+type ProbeRequest struct {
+	// Func 函数名或 RE2 正则，需完整匹配
+	Func string `json:"func"`
+	// Struct 参数中的结构体，为空时使用 --filter-struct
+	Struct string `json:"struct,omitempty"`
+	// Pos 结构体参数的位置（1-5），指定时 Func 为函数名，不再查找 BTF
+	Pos int `json:"pos,omitempty"`
+}
+
+// ProbeResult 附加或卸载的结果，Skipped 为未处理的函数及原因
+type ProbeResult struct {
+	Attached []string          `json:"attached,omitempty"`
+	Detached []string          `json:"detached,omitempty"`
+	Skipped  map[string]string `json:"skipped,omitempty"`
+}
+
+// ProbeInfo 当前附加的函数及命中次数，无法统计时 Hits 为空
+type ProbeInfo struct {
+	Func   string  `json:"func"`
+	Pos    int     `json:"pos"`
+	Source string  `json:"source"`
+	Hits   *uint64 `json:"hits"`
+}
+
+// ProbeEnv 解析和附加函数所需的环境，与启动时保持一致
+type ProbeEnv struct {
+	BTF         *btf.Spec
+	ModelDir    string
+	KMods       []string
+	Struct      string
+	KprobeMulti bool
+	// NoFuncBTF 内置的精简 BTF 不包含函数原型，只能通过 Pos 指定参数位置
+	NoFuncBTF bool
+	Addr2Name Addr2Name
+	// Funcs 启动时附加的函数
+	Funcs Funcs
+	// Uncounted 无法统计命中次数的函数，即内核不支持 bpf_get_func_ip 时通过 fentry 附加的函数
+	Uncounted Funcs
+	// Links 启动时附加的函数对应的 link，用于卸载启动时的函数
+	Links []FuncLink
+}
+
+type runtimeProbe struct {
+	pos  int
+	link link.Link
+}
+
+// startupLink 启动时附加的 link，kprobe-multi 模式下同一参数位置的函数共享
+type startupLink struct {
+	link link.Link
+	prog *ebpf.Program
+	// funcs 仍附加的函数，与 Name2AddrMap 中的名称一致
+	funcs map[string]struct{}
+	// owned 卸载部分函数后重新附加的 link，由 ProbeManager 关闭
+	owned bool
+}
+
+// ProbeManager 管理运行时附加的 kprobe，以及卸载启动时附加的函数
+type ProbeManager struct {
+	mu      sync.Mutex
+	coll    *ebpf.Collection
+	env     ProbeEnv
+	startup map[string]int
+	// startupLinks 启动时附加的函数对应的 link，按 probeFuncName 索引
+	startupLinks map[string]*startupLink
+	runtime      map[string]*runtimeProbe
+	// uncounted 无法统计命中次数的启动时函数
+	uncounted map[string]struct{}
+}
+
+// Probes 全局的函数探测管理器，附加启动时的函数后通过 WithCollection 初始化
+var Probes = &ProbeManager{runtime: make(map[string]*runtimeProbe)}
+
+func (m *ProbeManager) WithCollection(coll *ebpf.Collection, env ProbeEnv) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.coll = coll
+	m.env = env
+	m.startup = make(map[string]int, len(env.Funcs))
+	for fn, pos := range env.Funcs {
+		m.startup[probeFuncName(fn)] = pos
+	}
+	m.uncounted = make(map[string]struct{}, len(env.Uncounted))
+	for fn := range env.Uncounted {
+		m.uncounted[probeFuncName(fn)] = struct{}{}
+	}
+	m.startupLinks = make(map[string]*startupLink, len(env.Funcs))
+	for _, l := range env.Links {
+		sl := &startupLink{link: l.Link, prog: l.Prog, funcs: make(map[string]struct{}, len(l.Funcs))}
+		for _, fn := range l.Funcs {
+			sl.funcs[fn] = struct{}{}
+			m.startupLinks[probeFuncName(fn)] = sl
+		}
+	}
+}
+
+// Attach 将 kprobe_skb_X 附加到匹配的函数，已附加的函数会被跳过
+func (m *ProbeManager) Attach(req ProbeRequest) (ProbeResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.coll == nil {
+		return ProbeResult{}, errors.New("probes are not loaded")
+	}
+
+	funcs, err := m.resolve(req)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	if len(funcs) == 0 {
+		return ProbeResult{}, fmt.Errorf("no function matches %q", req.Func)
+	}
+	if len(funcs) > maxRuntimeProbes {
+		return ProbeResult{}, fmt.Errorf("%d functions match %q, more than %d", len(funcs), req.Func, maxRuntimeProbes)
+	}
+
+	result := ProbeResult{Skipped: make(map[string]string)}
+	for _, fn := range sortedFuncs(funcs) {
+		pos := funcs[fn]
+		if _, ok := m.startup[fn]; ok {
+			result.Skipped[fn] = "already attached at startup"
+			continue
+		}
+		if _, ok := m.runtime[fn]; ok {
+			result.Skipped[fn] = "already attached"
+			continue
+		}
+
+		l, err := m.attach(fn, pos)
+		if err != nil {
+			result.Skipped[fn] = err.Error()
+			continue
+		}
+
+		m.runtime[fn] = &runtimeProbe{pos: pos, link: l}
+		result.Attached = append(result.Attached, fn)
+	}
+
+	log.Infof("运行时附加函数 %q: 成功 %d 个，跳过 %d 个", req.Func, len(result.Attached), len(result.Skipped))
+	return result, nil
+}
+
+// Detach 卸载匹配的函数，包括启动时附加的函数
+func (m *ProbeManager) Detach(req ProbeRequest) (ProbeResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.runtime)+len(m.startup))
+	for fn := range m.runtime {
+		names = append(names, fn)
+	}
+	for fn := range m.startup {
+		names = append(names, fn)
+	}
+
+	matched, err := matchFuncs(req.Func, names)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	if len(matched) == 0 {
+		return ProbeResult{}, fmt.Errorf("no attached function matches %q", req.Func)
+	}
+
+	result := ProbeResult{Skipped: make(map[string]string)}
+	for _, fn := range matched {
+		probe, ok := m.runtime[fn]
+		if !ok {
+			if err := m.detachStartup(fn); err != nil {
+				result.Skipped[fn] = err.Error()
+				continue
+			}
+			result.Detached = append(result.Detached, fn)
+			continue
+		}
+
+		if err := probe.link.Close(); err != nil {
+			result.Skipped[fn] = err.Error()
+			continue
+		}
+		delete(m.runtime, fn)
+		result.Detached = append(result.Detached, fn)
+	}
+
+	log.Infof("运行时卸载函数 %q: 成功 %d 个，跳过 %d 个", req.Func, len(result.Detached), len(result.Skipped))
+	return result, nil
+}
+
+// List 返回当前附加的函数及命中次数
+func (m *ProbeManager) List() []ProbeInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hits := m.readHits()
+	probes := make([]ProbeInfo, 0, len(m.startup)+len(m.runtime))
+	for fn, pos := range m.startup {
+		info := ProbeInfo{Func: fn, Pos: pos, Source: ProbeSourceStartup}
+		if _, ok := m.uncounted[fn]; !ok {
+			count := hits[fn]
+			info.Hits = &count
+		}
+		probes = append(probes, info)
+	}
+	for fn, probe := range m.runtime {
+		count := hits[fn]
+		probes = append(probes, ProbeInfo{Func: fn, Pos: probe.pos, Source: ProbeSourceRuntime, Hits: &count})
+	}
+
+	sort.Slice(probes, func(i, j int) bool { return probes[i].Func < probes[j].Func })
+	return probes
+}
+
+// Close 卸载所有运行时附加的函数和重新附加的 kprobe-multi，其余启动时的 link 由附加者关闭
+func (m *ProbeManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for fn, probe := range m.runtime {
+		_ = probe.link.Close()
+		delete(m.runtime, fn)
+	}
+
+	closed := make(map[*startupLink]struct{})
+	for fn, sl := range m.startupLinks {
+		if _, ok := closed[sl]; sl.owned && !ok {
+			_ = sl.link.Close()
+			closed[sl] = struct{}{}
+		}
+		delete(m.startupLinks, fn)
+	}
+}
+
+// detachStartup 卸载启动时附加的函数。kprobe-multi 不能移除单个地址，先以剩余的函数重新附加再关闭原来的 link
+func (m *ProbeManager) detachStartup(fn string) error {
+	sl, ok := m.startupLinks[fn]
+	if !ok {
+		return errors.New("attached at startup without a link, restart the agent to detach")
+	}
+
+	var remaining []uintptr
+	for name := range sl.funcs {
+		if probeFuncName(name) == fn {
+			continue
+		}
+		remaining = append(remaining, m.env.Addr2Name.Name2AddrMap[name]...)
+	}
+
+	if len(remaining) == 0 {
+		if err := sl.link.Close(); err != nil {
+			return err
+		}
+	} else {
+		l, err := link.KprobeMulti(sl.prog, link.KprobeMultiOptions{Addresses: remaining})
+		if err != nil {
+			return fmt.Errorf("re-attaching kprobe-multi: %w", err)
+		}
+		_ = sl.link.Close()
+		sl.link = l
+		sl.owned = true
+	}
+
+	for name := range sl.funcs {
+		if probeFuncName(name) == fn {
+			delete(sl.funcs, name)
+		}
+	}
+	delete(m.startupLinks, fn)
+	delete(m.startup, fn)
+	delete(m.uncounted, fn)
+	return nil
+}
+
+func (m *ProbeManager) resolve(req ProbeRequest) (Funcs, error) {
+	if req.Func == "" {
+		return nil, errors.New("func is required")
+	}
+
+	if req.Pos != 0 {
+		if req.Pos < 1 || req.Pos > 5 {
+			return nil, fmt.Errorf("invalid pos %d, must be 1-5", req.Pos)
+		}
+		return Funcs{req.Func: req.Pos}, nil
+	}
+	if m.env.NoFuncBTF {
+		return nil, errors.New("embedded BTF has no function prototypes, pos is required")
+	}
+
+	filterStruct := req.Struct
+	if filterStruct == "" {
+		filterStruct = m.env.Struct
+	}
+
+	return GetFuncs(req.Func, filterStruct, m.env.ModelDir, m.env.BTF, m.env.KMods, false)
+}
+
+func (m *ProbeManager) attach(fn string, pos int) (link.Link, error) {
+	prog, ok := m.coll.Programs[fmt.Sprintf("kprobe_skb_%d", pos)]
+	if !ok {
+		return nil, fmt.Errorf("program kprobe_skb_%d not found", pos)
+	}
+
+	if !m.env.KprobeMulti {
+		return link.Kprobe(fn, prog, nil)
+	}
+
+	addrs, ok := m.env.Addr2Name.Name2AddrMap[fn]
+	if !ok {
+		return nil, fmt.Errorf("symbol %s not found in kallsyms", fn)
+	}
+	return link.KprobeMulti(prog, link.KprobeMultiOptions{Addresses: addrs})
+}
+
+// readHits 汇总 probe_hits 中各 CPU 的命中次数，按函数名聚合
+func (m *ProbeManager) readHits() map[string]uint64 {
+	hits := make(map[string]uint64)
+
+	probeHits, ok := m.coll.Maps["probe_hits"]
+	if !ok {
+		return hits
+	}
+
+	var (
+		ip     uint64
+		counts []uint64
+	)
+	iter := probeHits.Iterate()
+	for iter.Next(&ip, &counts) {
+		fn := probeFuncName(m.env.Addr2Name.FindNearestSym(ip))
+		for _, count := range counts {
+			hits[fn] += count
+		}
+	}
+	if err := iter.Err(); err != nil {
+		log.Errorf("遍历 probe_hits 失败: %v", err)
+	}
+
+	return hits
+}
+
+// probeFuncName 去掉内核模块函数名中的模块名，如 "nfs_file_read [nfs]"
+func probeFuncName(name string) string {
+	fn, _, _ := strings.Cut(name, " ")
+	fn, _, _ = strings.Cut(fn, "[")
+	return fn
+}
+
+// matchFuncs 返回完整匹配 pattern 的函数名，按名称排序
+func matchFuncs(pattern string, names []string) ([]string, error) {
+	if pattern == "" {
+		return nil, errors.New("func is required")
+	}
+
+	reg, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile regular expression %v", err)
+	}
+
+	var matched []string
+	for _, name := range names {
+		if reg.MatchString(name) {
+			matched = append(matched, name)
+		}
+	}
+
+	sort.Strings(matched)
+	return matched, nil
+}
+
+func sortedFuncs(funcs Funcs) []string {
+	names := make([]string, 0, len(funcs))
+	for fn := range funcs {
+		names = append(names, fn)
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,94 @@
+package bpf
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cilium/ebpf/link"
+)
+
+func TestProbeFuncName(t *testing.T) {
+	tests := map[string]string{
+		"nfs_file_read":       "nfs_file_read",
+		"nfs_file_read [nfs]": "nfs_file_read",
+		"nfs_file_read[nfs]":  "nfs_file_read",
+		"vfs_iocb_iter_read":  "vfs_iocb_iter_read",
+		"":                    "",
+	}
+
+	for name, want := range tests {
+		if got := probeFuncName(name); got != want {
+			t.Errorf("probeFuncName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestMatchFuncs(t *testing.T) {
+	names := []string{"nfs_file_write", "nfs_file_read", "vfs_iocb_iter_read", "nfs_file_direct_read"}
+
+	tests := []struct {
+		pattern string
+		want    []string
+		wantErr bool
+	}{
+		{pattern: "nfs_file_read", want: []string{"nfs_file_read"}},
+		{pattern: "nfs_file_.*", want: []string{"nfs_file_direct_read", "nfs_file_read", "nfs_file_write"}},
+		{pattern: "read", want: nil},
+		{pattern: ".*_read|vfs_.*", want: []string{"nfs_file_direct_read", "nfs_file_read", "vfs_iocb_iter_read"}},
+		{pattern: "", wantErr: true},
+		{pattern: "(", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := matchFuncs(tt.pattern, names)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("matchFuncs(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("matchFuncs(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+type fakeLink struct {
+	link.Link
+	closed int
+}
+
+func (l *fakeLink) Close() error {
+	l.closed++
+	return nil
+}
+
+func TestDetachStartup(t *testing.T) {
+	read, write := &fakeLink{}, &fakeLink{}
+	m := &ProbeManager{runtime: make(map[string]*runtimeProbe)}
+	m.WithCollection(nil, ProbeEnv{
+		Funcs: Funcs{"nfs_file_read [nfs]": 1, "nfs_file_write [nfs]": 1},
+		Links: []FuncLink{
+			{Link: newOnceLink(read), Funcs: []string{"nfs_file_read [nfs]"}},
+			{Link: newOnceLink(write), Funcs: []string{"nfs_file_write [nfs]"}},
+		},
+	})
+
+	result, err := m.Detach(ProbeRequest{Func: "nfs_file_read"})
+	if err != nil {
+		t.Fatalf("Detach() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Detached, []string{"nfs_file_read"}) {
+		t.Errorf("Detached = %v, want [nfs_file_read]", result.Detached)
+	}
+	if read.closed != 1 || write.closed != 0 {
+		t.Errorf("closed read %d write %d, want 1 and 0", read.closed, write.closed)
+	}
+	if _, ok := m.startup["nfs_file_read"]; ok {
+		t.Error("nfs_file_read is still listed as a startup probe")
+	}
+
+	// 附加者退出时再次关闭，onceLink 不会重复关闭
+	_ = m.env.Links[0].Link.Close()
+	if read.closed != 1 {
+		t.Errorf("read closed %d times, want 1", read.closed)
+	}
+}
@@ -0,0 +1,94 @@
+package bpf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cilium/ebpf"
+)
+
+// RPCTaskLayout rpc_task_begin/rpc_task_end tracepoint 的字段偏移，与 bpf/trace.c 中 struct rpc_task_layout 保持一致
+type RPCTaskLayout struct {
+	TaskID   uint16
+	ClientID uint16
+	Status   uint16
+	Flags    uint16
+}
+
+// rpcTaskTracepoints 请求开始和结束的 tracepoint，属于同一个事件类，格式必须相同，
+// 否则两端计算出的请求 key 不一致
+var rpcTaskTracepoints = []string{"rpc_task_begin", "rpc_task_end"}
+
+// SetupRPCTaskTracepoints 解析 rpc_task_begin/rpc_task_end 的格式并写入 RPC_TASK_LAYOUT，
+// tracefs 不可用时保留 bpf/trace.c 中的默认偏移
+func SetupRPCTaskTracepoints(spec *ebpf.CollectionSpec) error {
+	var layouts []RPCTaskLayout
+	for _, name := range rpcTaskTracepoints {
+		layout, err := detectRPCTaskLayout(name)
+		if err != nil {
+			log.Warningf("解析 sunrpc/%s 格式失败，使用默认偏移: %v", name, err)
+			return nil
+		}
+		layouts = append(layouts, layout)
+	}
+
+	if err := checkRPCTaskLayouts(layouts[0], layouts[1]); err != nil {
+		return err
+	}
+
+	return spec.RewriteConstants(map[string]interface{}{
+		"RPC_TASK_LAYOUT": layouts[0],
+	})
+}
+
+// checkRPCTaskLayouts rpc_task_begin 与 rpc_task_end 共用 RPC_TASK_LAYOUT，格式必须一致
+func checkRPCTaskLayouts(begin, end RPCTaskLayout) error {
+	if begin != end {
+		return fmt.Errorf("rpc_task_begin layout %+v differs from rpc_task_end %+v", begin, end)
+	}
+	return nil
+}
+
+func detectRPCTaskLayout(name string) (RPCTaskLayout, error) {
+	f, err := os.Open(filepath.Join(tracingEventsDir, "sunrpc", name, "format"))
+	if err != nil {
+		return RPCTaskLayout{}, err
+	}
+	defer f.Close()
+
+	fields, err := parseTracepointFormat(f)
+	if err != nil {
+		return RPCTaskLayout{}, fmt.Errorf("parse sunrpc/%s format: %w", name, err)
+	}
+
+	return matchRPCTaskLayout(fields)
+}
+
+// matchRPCTaskLayout 查找 task_id、client_id、status 和 flags 的偏移，task_id 与 client_id 必须为 4 字节，
+// 与 rpc_task 中的 tk_pid 和 rpc_clnt 中的 cl_clid 对应
+func matchRPCTaskLayout(fields map[string]tracepointField) (RPCTaskLayout, error) {
+	var layout RPCTaskLayout
+	for _, f := range []struct {
+		name string
+		size int
+		dst  *uint16
+	}{
+		{"task_id", 4, &layout.TaskID},
+		{"client_id", 4, &layout.ClientID},
+		{"status", 4, &layout.Status},
+		{"flags", 2, &layout.Flags},
+	} {
+		field, ok := fields[f.name]
+		if !ok {
+			return RPCTaskLayout{}, fmt.Errorf("missing field %s", f.name)
+		}
+		if field.size != f.size {
+			return RPCTaskLayout{}, fmt.Errorf("field %s has size %d, want %d", f.name, field.size, f.size)
+		}
+		*f.dst = uint16(field.offset)
+	}
+
+	return layout, nil
+}
@@ -0,0 +1,67 @@
+package bpf
+
+import (
+	"strings"
+	"testing"
+)
+
+const rpcTaskBeginFormat = `name: rpc_task_begin
+ID: 1021
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned int task_id;	offset:8;	size:4;	signed:0;
+	field:unsigned int client_id;	offset:12;	size:4;	signed:0;
+	field:const void * action;	offset:16;	size:8;	signed:0;
+	field:unsigned long runstate;	offset:24;	size:8;	signed:0;
+	field:int status;	offset:32;	size:4;	signed:1;
+	field:unsigned short flags;	offset:36;	size:2;	signed:0;
+`
+
+func TestRPCTaskLayout(t *testing.T) {
+	for _, name := range rpcTaskTracepoints {
+		format := strings.Replace(rpcTaskBeginFormat, "rpc_task_begin", name, 1)
+		fields, err := parseTracepointFormat(strings.NewReader(format))
+		if err != nil {
+			t.Fatalf("%s: parseTracepointFormat() error = %v", name, err)
+		}
+
+		// action 指针位于 client_id 之后，不能被当作 client_id
+		layout, err := matchRPCTaskLayout(fields)
+		if err != nil {
+			t.Fatalf("%s: matchRPCTaskLayout() error = %v", name, err)
+		}
+		want := RPCTaskLayout{TaskID: 8, ClientID: 12, Status: 32, Flags: 36}
+		if layout != want {
+			t.Fatalf("%s: layout = %+v, want %+v", name, layout, want)
+		}
+	}
+}
+
+func TestCheckRPCTaskLayouts(t *testing.T) {
+	begin := RPCTaskLayout{TaskID: 8, ClientID: 12, Status: 32, Flags: 36}
+	if err := checkRPCTaskLayouts(begin, begin); err != nil {
+		t.Errorf("checkRPCTaskLayouts() error = %v for identical layouts", err)
+	}
+
+	end := begin
+	end.Status = 24
+	if err := checkRPCTaskLayouts(begin, end); err == nil {
+		t.Error("checkRPCTaskLayouts() should reject differing layouts")
+	}
+}
+
+func TestMatchRPCTaskLayoutSize(t *testing.T) {
+	format := strings.Replace(rpcTaskBeginFormat, "field:unsigned int client_id;	offset:12;	size:4;",
+		"field:u64 client_id;	offset:12;	size:8;", 1)
+	fields, err := parseTracepointFormat(strings.NewReader(format))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := matchRPCTaskLayout(fields); err == nil {
+		t.Error("matchRPCTaskLayout() should reject 8-byte client_id")
+	}
+}
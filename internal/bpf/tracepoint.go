@@ -19,12 +19,18 @@ var (
 	NFSTracepointProgs = map[string]string{
 		"nfs_init_read":  "nfs_initiate_read",
 		"nfs_init_write": "nfs_initiate_write",
+		"tp_nfs_read_d":  "nfs_readpage_done",
+		"tp_nfs_write_d": "nfs_writeback_done",
 	}
 
 	RPCTracepointProgs = map[string]string{
 		"rpc_task_begin": "rpc_task_begin",
 		"rpc_task_done":  "rpc_task_end",
 	}
+
+	XprtTracepointProgs = map[string]string{
+		"rpc_connect_status": "rpc_connect_status",
+	}
 )
 
 type tracing struct {
@@ -108,7 +114,7 @@ func Tracepoint(group string, progs map[string]*ebpf.Program) *tracing {
 }
 
 func IsTracepointExist(group, tracepointName string) bool {
-	tracepointPath := filepath.Join("/sys/kernel/debug/tracing/events", group, tracepointName, "enable")
+	tracepointPath := filepath.Join(tracingEventsDir, group, tracepointName, "enable")
 	_, err := os.Stat(tracepointPath)
 	return err == nil
 }
@@ -0,0 +1,89 @@
+package bpf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
+)
+
+const (
+	TransportAuto    = "auto"
+	TransportRingBuf = "ringbuf"
+	TransportPerf    = "perf"
+)
+
+// EventMaps 内核向用户态输出事件的通道，在 bpf/trace.c 中声明为 ringbuf
+var EventMaps = []string{"nfs_trace_map", "path_ringbuf", "dns_events", "rpc_error_events", "xprt_events", "io_events", "meta_events", "nfs4_events", "lock_events", "server_events"}
+
+// UseRingBuf 根据配置和内核特性判断是否使用 ringbuf，ringbuf 需要 5.8 及以上内核
+func UseRingBuf(transport string) (bool, error) {
+	switch transport {
+	case TransportPerf:
+		return false, nil
+	case TransportRingBuf, TransportAuto, "":
+	default:
+		return false, fmt.Errorf("unknown event transport %q", transport)
+	}
+
+	err := features.HaveMapType(ebpf.RingBuf)
+	if err == nil {
+		return true, nil
+	}
+
+	if transport == TransportRingBuf {
+		return false, fmt.Errorf("ring buffer is not supported: %w", err)
+	}
+
+	if !errors.Is(err, ebpf.ErrNotSupported) {
+		log.Warningf("Failed to probe ring buffer support: %v, falling back to perf event array", err)
+	} else {
+		log.Info("Ring buffer is not supported, falling back to perf event array")
+	}
+
+	return false, nil
+}
+
+// SetupEventMaps 设置事件通道的 map 类型和大小，不支持 ringbuf 时改写为 perf event array
+func SetupEventMaps(spec *ebpf.CollectionSpec, cfg config.TransportConfig) (bool, error) {
+	useRingBuf, err := UseRingBuf(cfg.Type)
+	if err != nil {
+		return false, err
+	}
+
+	for _, name := range EventMaps {
+		m, ok := spec.Maps[name]
+		if !ok {
+			continue
+		}
+
+		if useRingBuf {
+			m.Type = ebpf.RingBuf
+			if cfg.RingBufSize > 0 {
+				m.MaxEntries = ringBufSize(cfg.RingBufSize)
+			}
+			continue
+		}
+
+		// MaxEntries 为 0 时 cilium/ebpf 会按 CPU 数量创建
+		m.Type = ebpf.PerfEventArray
+		m.KeySize = 4
+		m.ValueSize = 4
+		m.MaxEntries = 0
+	}
+
+	return useRingBuf, nil
+}
+
+// ringBufSize 将大小向上取整为 2 的幂，且不小于一个页
+func ringBufSize(size int) uint32 {
+	n := uint32(os.Getpagesize())
+	for n < uint32(size) {
+		n <<= 1
+	}
+	return n
+}
@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/features"
 	"github.com/cilium/ebpf/link"
+	"io"
 	"log"
 	"os"
 	"strconv"
@@ -19,17 +21,23 @@ import (
 // getAvailableFilterFunctions return list of functions to which it is possible
 // to attach kprobes.
 func getAvailableFilterFunctions() (map[string]struct{}, error) {
-	availableFuncs := make(map[string]struct{})
 	f, err := os.Open("/sys/kernel/debug/tracing/available_filter_functions")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open: %v", err)
 	}
 	defer f.Close()
 
-	scanner := bufio.NewScanner(f)
+	return parseAvailableFilterFunctions(f)
+}
+
+// parseAvailableFilterFunctions 模块函数在文件中形如 "nfs_file_read [nfs]"，转换为与 kallsyms 相同的 ksymName 格式
+func parseAvailableFilterFunctions(r io.Reader) (map[string]struct{}, error) {
+	availableFuncs := make(map[string]struct{})
+	scanner := bufio.NewScanner(r)
 	scanner.Split(bufio.ScanLines)
 	for scanner.Scan() {
-		availableFuncs[scanner.Text()] = struct{}{}
+		fn, kmod, _ := strings.Cut(scanner.Text(), " ")
+		availableFuncs[ksymName(fn, strings.Trim(kmod, "[]"))] = struct{}{}
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, err
@@ -37,6 +45,7 @@ func getAvailableFilterFunctions() (map[string]struct{}, error) {
 
 	return availableFuncs, nil
 }
+
 func GetFuncsByPos(funcs Funcs) map[int][]string {
 	ret := make(map[int][]string, len(funcs))
 	for fn, pos := range funcs {
@@ -101,6 +110,16 @@ func HaveBPFLinkTracing() bool {
 	return true
 }
 
+// HaveFuncIP 检查 bpf_get_func_ip 是否可用（5.15+），tracing 程序无法直接探测，使用 kprobe 代替
+func HaveFuncIP() bool {
+	return features.HaveProgramHelper(ebpf.Kprobe, asm.FnGetFuncIp) == nil
+}
+
+// HaveBoundedLoops 检查 verifier 是否支持有界循环（5.3+）
+func HaveBoundedLoops() bool {
+	return features.HaveBoundedLoops() == nil
+}
+
 func HaveAvailableFilterFunctions() bool {
 	_, err := getAvailableFilterFunctions()
 	return err == nil
@@ -13,18 +13,18 @@ var PodContainerPIDMap *sync.Map
 var MountInfoMap *sync.Map
 
 // NFSPerformanceMap 保存nfs地址和性能信息的映射关系
-// key: devID+fileID
+// key: binary.NFSTraceFileKey
 // value: metadata.NFSTraceInfo
 var NFSPerformanceMap *sync.Map
 
 // NFSDevIDFileIDFileInfoMap 保存devID+fileID和文件信息的映射关系
-// key: devID+fileID
+// key: binary.NFSTraceFileKey
 // value: metadata.NFSFile
 var NFSDevIDFileIDFileInfoMap *sync.Map
 
 // NFSFileDetailMap 保存文件的详细信息
-// key: devID+fileID
-// value: string
+// key: binary.NFSTraceFileKey
+// value: metadata.FilePath
 var NFSFileDetailMap *sync.Map
 
 // PidInfoMap 保存pid和pod、container的映射关系
@@ -32,6 +32,31 @@ var NFSFileDetailMap *sync.Map
 // value: metadata.PidInfo
 var PidInfoMap *sync.Map
 
+// XprtStatsMap 保存 sunrpc 传输层的统计信息
+// key: rpc_xprt 指针
+// value: binary.NFSTraceXprtStats
+var XprtStatsMap *sync.Map
+
+// DevXprtMap 保存挂载设备号和 sunrpc 传输层的映射关系
+// key: devID
+// value: rpc_xprt 指针
+var DevXprtMap *sync.Map
+
+// MountStatsMap 保存 mountstats 中 NFS 挂载的统计信息
+// key: mount point
+// value: metadata.MountStats
+var MountStatsMap *sync.Map
+
+// BPFMapUsageMap 保存 eBPF map 的使用情况
+// key: map 名称
+// value: bpf.MapUsage
+var BPFMapUsageMap *sync.Map
+
+// NFSAccessMetricsMap 保存按访问方式统计的文件读写
+// key: binary.NFSTraceAccessKey
+// value: binary.NFSTraceAccessStats
+var NFSAccessMetricsMap *sync.Map
+
 func init() {
 	PodContainerPIDMap = new(sync.Map)
 	MountInfoMap = new(sync.Map)
@@ -39,4 +64,9 @@ func init() {
 	NFSDevIDFileIDFileInfoMap = new(sync.Map)
 	NFSFileDetailMap = new(sync.Map)
 	PidInfoMap = new(sync.Map)
+	XprtStatsMap = new(sync.Map)
+	DevXprtMap = new(sync.Map)
+	MountStatsMap = new(sync.Map)
+	BPFMapUsageMap = new(sync.Map)
+	NFSAccessMetricsMap = new(sync.Map)
 }
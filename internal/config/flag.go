@@ -2,6 +2,7 @@ package config
 
 import (
 	"flag"
+	"time"
 
 	"github.com/spf13/pflag"
 )
@@ -16,12 +17,37 @@ func SetFlags(pflag *pflag.FlagSet) {
 	pflag.BoolVar(&Config.Probing.AllKMods, "all-kmods", false, "attach to all available kernel modules")
 	pflag.BoolVar(&Config.Probing.SkipAttach, "skip-attach", false, "skip attaching kprobes")
 	pflag.StringVar(&Config.Probing.AddFuncs, "add-funcs", "", "add functions to be probed by name (ex. rpc_task:1,sk_buff:2)")
+	pflag.IntVar(&Config.Probing.MaxPathDepth, "max-path-depth", 256, "maximum directory depth when resolving file paths in kernel, up to 1024")
+	pflag.StringVar(&Config.Probing.CompletionProbe, "completion-probe", "auto", "how to count nfs read/write completions (ex. auto, tracepoint, kprobe), auto uses the nfs tracepoints when available")
+	pflag.DurationVar(&Config.Probing.DedupInterval, "dedup-interval", 0, "emit at most one file access event per process and file in each interval, 0 disables deduplication")
+	pflag.DurationVar(&Config.Probing.PathCacheInterval, "path-cache-interval", 10*time.Second, "resolve the parent directory of metadata operations and locked files at most once per interval")
+	pflag.BoolVar(&Config.EventFilter.Runtime, "enable-runtime-filters", false, "allow changing event filter rules at runtime via POST/DELETE /filters, also starts the http server")
+	pflag.BoolVar(&Config.Probing.RuntimeProbes, "enable-runtime-probes", false, "allow attaching and detaching functions at runtime via POST/DELETE /probes, also starts the http server")
+	pflag.StringVar(&Config.Probing.AttachMode, "attach-mode", "auto", "how to attach the filtered functions (ex. auto, fentry, kprobe-multi, kprobe), auto picks kprobe-multi when supported, otherwise kprobe")
 
 	pflag.StringVar(&Config.Output.Type, "output-type", "file", "output type(ex. file, stdout, kafka, es, logstash, redis)")
 	pflag.BoolVar(&Config.Features.Debug, "enable-debug", false, "enable debug mode")
 
 	pflag.BoolVar(&Config.Features.DNS, "enable-dns", false, "enable dns mode")
 	pflag.BoolVar(&Config.Features.NFSMetrics, "enable-nfs-metrics", false, "enable nfs metrics mode")
+	pflag.BoolVar(&Config.Features.TopK, "enable-topk", false, "only export metrics of the top-k hottest files (requires --enable-nfs-metrics)")
+	pflag.IntVar(&Config.TopK.Size, "topk-size", 10, "number of hottest files exported per window and order")
+	pflag.BoolVar(&Config.Features.MountStats, "enable-mountstats", false, "enable /proc/1/mountstats collector for the host mount namespace, also used as fallback when eBPF is unavailable")
+	pflag.BoolVar(&Config.Features.Xprt, "enable-xprt", false, "enable sunrpc transport (retransmit/reconnect) metrics")
+	pflag.BoolVar(&Config.Features.IOPattern, "enable-io-pattern", false, "export per-request offset/size and classify file access patterns (requires --enable-nfs-metrics)")
+	pflag.BoolVar(&Config.Features.AccessMethod, "enable-access-method", false, "count file reads/writes by access method (buffered, direct, mmap, splice)")
+	pflag.BoolVar(&Config.Features.MetaOps, "enable-meta-ops", false, "trace nfs metadata operations (lookup, open, getattr, setattr, create, unlink, rename)")
+	pflag.DurationVar(&Config.Meta.SlowThreshold, "meta-slow-threshold", 10*time.Millisecond, "emit metadata operation events that fail or take at least this long, 0 emits all")
+	pflag.BoolVar(&Config.Features.NFS4State, "enable-nfs4-state", false, "trace nfsv4 state management events (delegations, state recovery, lease renewal, sequence errors, slot table exhaustion)")
+	pflag.BoolVar(&Config.Features.Locks, "enable-locks", false, "trace nfs file locks (fcntl and flock): requests, waits, denials and current holders")
+	pflag.DurationVar(&Config.Lock.WaitThreshold, "lock-wait-threshold", 10*time.Millisecond, "emit lock events that fail or wait at least this long, 0 emits all")
+	pflag.BoolVar(&Config.Features.ServerHealth, "enable-server-health", false, "detect nfs servers not responding (major rpc timeouts) and their recovery, export nfs_server_up")
+	pflag.BoolVar(&Config.Features.StackTrace, "enable-stack-trace", false, "capture and symbolize kernel call chains in file access events")
+	pflag.StringVar(&Config.Transport.Type, "event-transport", "auto", "event transport between kernel and user space (ex. auto, ringbuf, perf)")
+	pflag.IntVar(&Config.Transport.RingBufSize, "ringbuf-size", 1<<20, "size in bytes of each ring buffer, rounded up to a power of two")
+	pflag.IntVar(&Config.Transport.PerfBufferSize, "perf-buffer-size", 64<<10, "size in bytes of the per-CPU perf buffer, used when ring buffer is unavailable")
+	pflag.BoolVar(&Config.Pin.Enabled, "pin-maps", false, "pin stateful BPF maps to bpffs so that counters survive restarts")
+	pflag.StringVar(&Config.Pin.Path, "pin-path", "/sys/fs/bpf/nfs-trace", "bpffs directory of the pinned BPF maps")
 	pflag.StringVar(&Config.ConfigPath, "config-path", "", "specify config file path")
 
 	pflag.Set("logtostderr", "false")
@@ -1,13 +1,22 @@
 package config
 
+import "time"
+
 type Configuration struct {
-	Filter     FilterConfig   `yaml:"filter"`
-	BTF        BTFConfig      `yaml:"btf"`
-	Probing    ProbingConfig  `yaml:"probing"`
-	Features   FeaturesConfig `yaml:"features"`
-	Output     OutputConfig   `yaml:"output"`
-	Logging    LoggingConfig  `yaml:"logging"`
-	ConfigPath string         `yaml:"-"`
+	Filter      FilterConfig      `yaml:"filter"`
+	EventFilter EventFilterConfig `yaml:"event_filter"`
+	BTF         BTFConfig         `yaml:"btf"`
+	Probing     ProbingConfig     `yaml:"probing"`
+	Features    FeaturesConfig    `yaml:"features"`
+	TopK        TopKConfig        `yaml:"topk"`
+	Meta        MetaConfig        `yaml:"meta"`
+	Lock        LockConfig        `yaml:"lock"`
+	Transport   TransportConfig   `yaml:"transport"`
+	Maps        MapsConfig        `yaml:"maps"`
+	Pin         PinConfig         `yaml:"pin"`
+	Output      OutputConfig      `yaml:"output"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	ConfigPath  string            `yaml:"-"`
 }
 
 type FilterConfig struct {
@@ -15,6 +24,22 @@ type FilterConfig struct {
 	Struct string `yaml:"struct"`
 }
 
+// EventFilterConfig 内核态事件过滤，某个维度存在 allow 规则时只保留命中的事件，deny 优先
+type EventFilterConfig struct {
+	Allow EventFilterRules `yaml:"allow"`
+	Deny  EventFilterRules `yaml:"deny"`
+	// Runtime 允许通过 /filters 接口在运行时修改规则，HTTP 服务没有鉴权，默认关闭
+	Runtime bool `yaml:"runtime"`
+}
+
+type EventFilterRules struct {
+	Cgroups []string `yaml:"cgroups"` // cgroup v2 路径或 cgroup id
+	Devs    []string `yaml:"devs"`    // major:minor 或 s_dev
+	Mounts  []string `yaml:"mounts"`  // mount id
+	Pids    []string `yaml:"pids"`
+	Comms   []string `yaml:"comms"` // 进程名前缀
+}
+
 type BTFConfig struct {
 	Kernel   string `yaml:"kernel"`
 	ModelDir string `yaml:"model_dir"`
@@ -24,12 +49,72 @@ type ProbingConfig struct {
 	AllKMods   bool   `yaml:"all_kmods"`
 	SkipAttach bool   `yaml:"skip_attach"`
 	AddFuncs   string `yaml:"add_funcs"`
+	AttachMode string `yaml:"attach_mode"` // enum: auto, fentry, kprobe-multi, kprobe
+	// MaxPathDepth 内核中重建文件路径的最大深度，超过时路径被标记为截断
+	MaxPathDepth int `yaml:"max_path_depth"`
+	// CompletionProbe 统计读写完成的方式，tracepoint 不依赖函数参数顺序，但无法拆分排队时间和 RTT
+	CompletionProbe string `yaml:"completion_probe"` // enum: auto, tracepoint, kprobe
+	// DedupInterval 同一进程访问同一文件时每个间隔只输出一次事件，其余访问只计数，0 表示不去重
+	DedupInterval time.Duration `yaml:"dedup_interval"`
+	// PathCacheInterval 元数据操作父目录、加锁文件的路径在间隔内只解析一次，0 表示使用默认值
+	PathCacheInterval time.Duration `yaml:"path_cache_interval"`
+	// RuntimeProbes 允许通过 /probes 接口在运行时附加、卸载函数，启用时即使未开启指标也会启动 HTTP 服务
+	RuntimeProbes bool `yaml:"runtime_probes"`
 }
 
 type FeaturesConfig struct {
 	Debug      bool `yaml:"debug"`
 	DNS        bool `yaml:"dns"`
 	NFSMetrics bool `yaml:"nfs_metrics"`
+	Xprt       bool `yaml:"xprt"`
+	MountStats bool `yaml:"mountstats"`
+	TopK       bool `yaml:"topk"`
+	StackTrace bool `yaml:"stack_trace"`
+	IOPattern  bool `yaml:"io_pattern"`
+	// AccessMethod 按 buffered、direct、mmap、splice 统计文件读写
+	AccessMethod bool `yaml:"access_method"`
+	// MetaOps 追踪 lookup、open、getattr 等元数据操作
+	MetaOps bool `yaml:"meta_ops"`
+	// NFS4State 追踪 NFSv4 委托、状态恢复、租约续期和会话 slot 等状态管理事件
+	NFS4State bool `yaml:"nfs4_state"`
+	// Locks 追踪 fcntl 和 flock 文件锁的请求、等待和持有者
+	Locks bool `yaml:"locks"`
+	// ServerHealth 检测服务端无响应（major timeout）与恢复
+	ServerHealth bool `yaml:"server_health"`
+}
+
+type TopKConfig struct {
+	Size int `yaml:"size"`
+}
+
+type MetaConfig struct {
+	// SlowThreshold 延迟不低于该值或失败的元数据操作输出事件，0 表示全部输出
+	SlowThreshold time.Duration `yaml:"slow_threshold"`
+}
+
+type LockConfig struct {
+	// WaitThreshold 等待不低于该值的加锁请求输出事件，0 表示全部输出，失败的请求总是输出
+	WaitThreshold time.Duration `yaml:"wait_threshold"`
+}
+
+// MapsConfig eBPF map 容量配置，未配置的 map 使用 bpf/trace.c 中的默认值
+type MapsConfig struct {
+	Sizes       map[string]uint32 `yaml:"sizes"`
+	Eviction    map[string]string `yaml:"eviction"` // lru 或 none，仅对哈希类 map 生效
+	WarnPercent int               `yaml:"warn_percent"`
+}
+
+// PinConfig 将 map 固定到 bpffs，重启后复用其中的计数和 pod 信息
+type PinConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+}
+
+// TransportConfig 事件通道配置，Type 为 auto/ringbuf/perf，auto 时内核支持 ringbuf 则优先使用
+type TransportConfig struct {
+	Type           string `yaml:"type"`
+	RingBufSize    int    `yaml:"ringbuf_size"`
+	PerfBufferSize int    `yaml:"perf_buffer_size"`
 }
 
 type OutputConfig struct {
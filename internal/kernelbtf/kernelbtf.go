@@ -0,0 +1,180 @@
+// Package kernelbtf 内置精简后的内核 BTF，用于没有 /sys/kernel/btf/vmlinux 的内核
+package kernelbtf
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cilium/ebpf/btf"
+	"golang.org/x/sys/unix"
+)
+
+// ArchiveName 内置 BTF 归档的文件名，由 tools/btfgen 生成。
+// 归档中的文件路径为 <ID>/<VERSION_ID>/<arch>/<uname -r>.btf，ID 和 VERSION_ID 来自 os-release
+const ArchiveName = "btfs.tar.gz"
+
+//go:embed archive/*
+var archive embed.FS
+
+// ErrNotFound 内置归档中没有当前内核的 BTF
+var ErrNotFound = errors.New("no embedded BTF for the running kernel")
+
+// Kernel 用于选择 BTF 的内核信息
+type Kernel struct {
+	ID        string
+	VersionID string
+	Arch      string
+	Release   string
+}
+
+// Load 按当前内核从内置归档中加载 BTF，返回选中的文件名
+func Load() (*btf.Spec, string, error) {
+	kernel, err := CurrentKernel()
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := archive.ReadFile(path.Join("archive", ArchiveName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	files, err := readArchive(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("read embedded BTF archive: %w", err)
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+
+	name := Select(names, kernel)
+	if name == "" {
+		return nil, "", fmt.Errorf("%w: %s %s %s %s", ErrNotFound, kernel.ID, kernel.VersionID, kernel.Arch, kernel.Release)
+	}
+
+	spec, err := btf.LoadSpecFromReader(bytes.NewReader(files[name]))
+	if err != nil {
+		return nil, "", fmt.Errorf("load embedded BTF %s: %w", name, err)
+	}
+
+	return spec, name, nil
+}
+
+// Select 选择与内核匹配的 BTF，优先匹配发行版和版本，其次只匹配内核版本和架构
+func Select(names []string, kernel Kernel) string {
+	exact := path.Join(kernel.ID, kernel.VersionID, kernel.Arch, kernel.Release+".btf")
+
+	var fallback string
+	for _, name := range names {
+		if name == exact {
+			return name
+		}
+
+		if path.Base(name) == kernel.Release+".btf" && path.Base(path.Dir(name)) == kernel.Arch && fallback == "" {
+			fallback = name
+		}
+	}
+
+	return fallback
+}
+
+// CurrentKernel 读取内核版本、架构以及宿主机的 os-release
+func CurrentKernel() (Kernel, error) {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return Kernel{}, fmt.Errorf("uname: %w", err)
+	}
+
+	kernel := Kernel{
+		Arch:    normalizeArch(unix.ByteSliceToString(uts.Machine[:])),
+		Release: unix.ByteSliceToString(uts.Release[:]),
+	}
+
+	// 容器中通过宿主机 1 号进程的根目录读取 os-release
+	for _, p := range []string{config.GetProcPath("1/root/etc/os-release"), "/etc/os-release"} {
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+
+		kernel.ID, kernel.VersionID = parseOSRelease(f)
+		f.Close()
+		break
+	}
+
+	return kernel, nil
+}
+
+func parseOSRelease(r io.Reader) (id, versionID string) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			id = value
+		case "VERSION_ID":
+			versionID = value
+		}
+	}
+
+	return
+}
+
+func normalizeArch(machine string) string {
+	if machine == "aarch64" {
+		return "arm64"
+	}
+	return machine
+}
+
+func readArchive(data []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[path.Clean(hdr.Name)] = content
+	}
+
+	return files, nil
+}
@@ -0,0 +1,51 @@
+package kernelbtf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	names := []string{
+		"kylin/V10/x86_64/4.19.90-24.4.v2101.ky10.x86_64.btf",
+		"kylin/V10/arm64/4.19.90-24.4.v2101.ky10.aarch64.btf",
+		"alinux/3/x86_64/5.10.134-16.3.al8.x86_64.btf",
+		"anolis/8.8/x86_64/5.10.134-16.3.al8.x86_64.btf",
+	}
+
+	tests := []struct {
+		name   string
+		kernel Kernel
+		want   string
+	}{
+		{
+			name:   "exact",
+			kernel: Kernel{ID: "anolis", VersionID: "8.8", Arch: "x86_64", Release: "5.10.134-16.3.al8.x86_64"},
+			want:   "anolis/8.8/x86_64/5.10.134-16.3.al8.x86_64.btf",
+		},
+		{
+			name:   "release only",
+			kernel: Kernel{ID: "unknown", Arch: "arm64", Release: "4.19.90-24.4.v2101.ky10.aarch64"},
+			want:   "kylin/V10/arm64/4.19.90-24.4.v2101.ky10.aarch64.btf",
+		},
+		{
+			name:   "arch mismatch",
+			kernel: Kernel{ID: "kylin", VersionID: "V10", Arch: "arm64", Release: "4.19.90-24.4.v2101.ky10.x86_64"},
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Select(names, tt.kernel); got != tt.want {
+				t.Errorf("Select() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOSRelease(t *testing.T) {
+	id, version := parseOSRelease(strings.NewReader("NAME=\"Kylin Linux Advanced Server\"\nVERSION_ID=\"V10\"\nID=\"kylin\"\n"))
+	if id != "kylin" || version != "V10" {
+		t.Errorf("parseOSRelease() = %q, %q", id, version)
+	}
+}
@@ -5,12 +5,21 @@ import "github.com/cen-ngc5139/nfs-trace/internal/binary"
 type NFSFile struct {
 	MountPath     string `json:"mount_path"`
 	RemoteNFSAddr string `json:"remote_nfs_addr"`
+	ServerAddr    string `json:"server_addr,omitempty"`
+	NFSVersion    string `json:"nfs_version,omitempty"`
 	LocalMountDir string `json:"local_mount_dir"`
 	FilePath      string `json:"file_path"`
+	PathTruncated bool   `json:"path_truncated,omitempty"`
 	Pod           string `json:"pod"`
 	Container     string `json:"container"`
 }
 
+// FilePath 内核中重建的文件路径，Truncated 表示超过深度限制或文件名过长
+type FilePath struct {
+	Path      string
+	Truncated bool
+}
+
 type NFSTraceInfo struct {
 	Traffic binary.NFSTraceRawMetrics `json:"traffic"`
 	File    NFSFile                   `json:"file"`
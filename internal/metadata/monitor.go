@@ -14,6 +14,7 @@ import (
 
 type MountInfo struct {
 	MountID       string
+	Dev           string
 	LocalMountDir string
 	RemoteNFSAddr string
 }
@@ -110,6 +111,7 @@ func ParseMountInfo(filePath string) ([]MountInfo, error) {
 
 		mountInfo := MountInfo{
 			MountID:       fields[0],
+			Dev:           fields[2],
 			LocalMountDir: fields[4],
 		}
 
@@ -38,6 +38,25 @@ func GetMountInfoFromCache(id string) (MountInfo, error) {
 	return MountInfo{}, fmt.Errorf("mount info not found for id %s", id)
 }
 
+// GetMountInfoByDev 根据内核 s_dev 查找挂载信息
+func GetMountInfoByDev(dev uint32) (MountInfo, bool) {
+	// 内核 dev_t 编码: major 占高 12 位, minor 占低 20 位
+	devStr := fmt.Sprintf("%d:%d", dev>>20, dev&0xFFFFF)
+
+	var found MountInfo
+	var ok bool
+	cache.MountInfoMap.Range(func(key, value interface{}) bool {
+		mount := value.(MountInfo)
+		if mount.Dev == devStr && mount.RemoteNFSAddr != "" {
+			found, ok = mount, true
+			return false
+		}
+		return true
+	})
+
+	return found, ok
+}
+
 func GetMountInfoFormObj(id string, mounts []MountInfo) (MountInfo, error) {
 	for _, mount := range mounts {
 		if mount.MountID == id {
@@ -0,0 +1,269 @@
+package metadata
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+)
+
+// MountStats /proc/<pid>/mountstats 中单个 NFS 挂载的统计信息
+type MountStats struct {
+	Device     string
+	MountPoint string
+	FSType     string
+	NFSVersion string
+	Age        uint64
+	Bytes      NFSBytesStats
+	Xprts      []NFSXprtStats
+	Ops        []NFSOpStats
+}
+
+// NFSBytesStats 对应 mountstats 的 bytes: 行
+type NFSBytesStats struct {
+	NormalRead  uint64
+	NormalWrite uint64
+	DirectRead  uint64
+	DirectWrite uint64
+	ServerRead  uint64
+	ServerWrite uint64
+	ReadPages   uint64
+	WritePages  uint64
+}
+
+// NFSXprtStats 对应 mountstats 的 xprt: 行，udp 没有连接相关字段
+type NFSXprtStats struct {
+	Protocol     string
+	Port         uint64
+	BindCount    uint64
+	ConnectCount uint64
+	ConnectTime  uint64
+	IdleTime     uint64
+	Sends        uint64
+	Recvs        uint64
+	BadXIDs      uint64
+	ReqU         uint64
+	BklogU       uint64
+	MaxSlots     uint64
+	SendingU     uint64
+	PendingU     uint64
+}
+
+// NFSOpStats 对应 per-op statistics 中的一行，时间单位为毫秒
+type NFSOpStats struct {
+	Op        string
+	Ops       uint64
+	Trans     uint64
+	Timeouts  uint64
+	BytesSent uint64
+	BytesRecv uint64
+	QueueMs   uint64
+	RTTMs     uint64
+	ExecuteMs uint64
+	Errors    uint64
+}
+
+func ParseMountStats(filePath string) ([]MountStats, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseMountStats(file)
+}
+
+// parseMountStats 解析 mountstats 内容，无法解析的行会被跳过，不影响其他挂载点
+func parseMountStats(r io.Reader) ([]MountStats, error) {
+	var stats []MountStats
+	var current *MountStats
+	var inOps bool
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		// device 192.168.1.1:/export mounted on /mnt with fstype nfs4 statvers=1.1
+		if fields[0] == "device" {
+			current = nil
+			inOps = false
+			if len(fields) < 8 || !strings.HasPrefix(fields[7], "nfs") {
+				continue
+			}
+
+			stats = append(stats, MountStats{
+				Device:     fields[1],
+				MountPoint: fields[4],
+				FSType:     fields[7],
+			})
+			current = &stats[len(stats)-1]
+			continue
+		}
+
+		if current == nil {
+			continue
+		}
+
+		switch fields[0] {
+		case "opts:":
+			if len(fields) > 1 {
+				current.NFSVersion = parseNFSVersion(fields[1])
+			}
+		case "age:":
+			if len(fields) > 1 {
+				current.Age, _ = strconv.ParseUint(fields[1], 10, 64)
+			}
+		case "bytes:":
+			values, err := parseUints(fields[1:])
+			if err != nil {
+				log.Warningf("跳过 %s 无效的 bytes 行: %v", current.MountPoint, err)
+				continue
+			}
+			current.Bytes = newNFSBytesStats(values)
+		case "xprt:":
+			xprt, err := parseXprtStats(fields[1:])
+			if err != nil {
+				log.Warningf("跳过 %s 无效的 xprt 行: %v", current.MountPoint, err)
+				continue
+			}
+			current.Xprts = append(current.Xprts, xprt)
+		case "per-op":
+			inOps = true
+		default:
+			if !inOps || !strings.HasSuffix(fields[0], ":") {
+				continue
+			}
+
+			op, err := parseOpStats(fields)
+			if err != nil {
+				log.Warningf("跳过 %s 无效的 per-op 行: %v", current.MountPoint, err)
+				continue
+			}
+			current.Ops = append(current.Ops, op)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func parseNFSVersion(opts string) string {
+	for _, opt := range strings.Split(opts, ",") {
+		if strings.HasPrefix(opt, "vers=") {
+			return strings.TrimPrefix(opt, "vers=")
+		}
+	}
+	return ""
+}
+
+func parseUints(fields []string) ([]uint64, error) {
+	values := make([]uint64, 0, len(fields))
+	for _, f := range fields {
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// field 返回 values 中第 i 个值，缺失时返回 0，用于兼容不同 statvers 的字段数
+func field(values []uint64, i int) uint64 {
+	if i < len(values) {
+		return values[i]
+	}
+	return 0
+}
+
+func newNFSBytesStats(v []uint64) NFSBytesStats {
+	return NFSBytesStats{
+		NormalRead:  field(v, 0),
+		NormalWrite: field(v, 1),
+		DirectRead:  field(v, 2),
+		DirectWrite: field(v, 3),
+		ServerRead:  field(v, 4),
+		ServerWrite: field(v, 5),
+		ReadPages:   field(v, 6),
+		WritePages:  field(v, 7),
+	}
+}
+
+func parseXprtStats(fields []string) (NFSXprtStats, error) {
+	if len(fields) < 2 {
+		return NFSXprtStats{}, fmt.Errorf("too few fields: %d", len(fields))
+	}
+
+	xprt := NFSXprtStats{Protocol: fields[0]}
+	v, err := parseUints(fields[1:])
+	if err != nil {
+		return xprt, err
+	}
+
+	switch xprt.Protocol {
+	case "udp":
+		// srcport bind_count sends recvs bad_xids req_u bklog_u [max_slots sending_u pending_u]
+		xprt.Port = field(v, 0)
+		xprt.BindCount = field(v, 1)
+		xprt.Sends = field(v, 2)
+		xprt.Recvs = field(v, 3)
+		xprt.BadXIDs = field(v, 4)
+		xprt.ReqU = field(v, 5)
+		xprt.BklogU = field(v, 6)
+		xprt.MaxSlots = field(v, 7)
+		xprt.SendingU = field(v, 8)
+		xprt.PendingU = field(v, 9)
+	default:
+		// tcp/rdma: srcport bind_count connect_count connect_time idle_time sends recvs bad_xids
+		// req_u bklog_u [max_slots sending_u pending_u]
+		xprt.Port = field(v, 0)
+		xprt.BindCount = field(v, 1)
+		xprt.ConnectCount = field(v, 2)
+		xprt.ConnectTime = field(v, 3)
+		xprt.IdleTime = field(v, 4)
+		xprt.Sends = field(v, 5)
+		xprt.Recvs = field(v, 6)
+		xprt.BadXIDs = field(v, 7)
+		xprt.ReqU = field(v, 8)
+		xprt.BklogU = field(v, 9)
+		xprt.MaxSlots = field(v, 10)
+		xprt.SendingU = field(v, 11)
+		xprt.PendingU = field(v, 12)
+	}
+
+	return xprt, nil
+}
+
+func parseOpStats(fields []string) (NFSOpStats, error) {
+	op := NFSOpStats{Op: strings.TrimSuffix(fields[0], ":")}
+	v, err := parseUints(fields[1:])
+	if err != nil {
+		return op, err
+	}
+
+	if len(v) < 8 {
+		return op, fmt.Errorf("too few fields for %s: %d", op.Op, len(v))
+	}
+
+	op.Ops = v[0]
+	op.Trans = v[1]
+	op.Timeouts = v[2]
+	op.BytesSent = v[3]
+	op.BytesRecv = v[4]
+	op.QueueMs = v[5]
+	op.RTTMs = v[6]
+	op.ExecuteMs = v[7]
+	op.Errors = field(v, 8)
+
+	return op, nil
+}
@@ -0,0 +1,113 @@
+package metadata
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMountStats(t *testing.T) {
+	type args struct {
+		filePath string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []MountStats
+		wantErr bool
+	}{
+		{
+			name: "Parse mount stats",
+			args: args{
+				filePath: "../../testdata/mountstats",
+			},
+			want: []MountStats{
+				{
+					Device:     "192.168.100.204:/data/nfs",
+					MountPoint: "/data/mount",
+					FSType:     "nfs4",
+					NFSVersion: "4.1",
+					Age:        86400,
+					Bytes: NFSBytesStats{
+						NormalRead: 4096, NormalWrite: 8192, ServerRead: 4096, ServerWrite: 8192,
+						ReadPages: 1, WritePages: 2,
+					},
+					Xprts: []NFSXprtStats{
+						{
+							Protocol: "tcp", Port: 875, ConnectCount: 2, IdleTime: 12, Sends: 150, Recvs: 149,
+							ReqU: 300, BklogU: 4, MaxSlots: 65536, SendingU: 10, PendingU: 20,
+						},
+					},
+					Ops: []NFSOpStats{
+						{Op: "NULL", Ops: 1, Trans: 1, BytesSent: 44, BytesRecv: 24},
+						{Op: "READ", Ops: 10, Trans: 10, BytesSent: 1760, BytesRecv: 42000, QueueMs: 3, RTTMs: 25, ExecuteMs: 30},
+						{Op: "WRITE", Ops: 20, Trans: 21, Timeouts: 1, BytesSent: 90000, BytesRecv: 3200, QueueMs: 8, RTTMs: 120, ExecuteMs: 130, Errors: 2},
+					},
+				},
+				{
+					Device:     "192.168.100.205:/export",
+					MountPoint: "/mnt/v3",
+					FSType:     "nfs",
+					NFSVersion: "3",
+					Age:        60,
+					Bytes:      NFSBytesStats{NormalRead: 100, ServerRead: 100, ReadPages: 1},
+					Xprts: []NFSXprtStats{
+						{Protocol: "udp", Port: 700, Sends: 5, Recvs: 5, ReqU: 5},
+					},
+					Ops: []NFSOpStats{
+						{Op: "GETATTR", Ops: 5, Trans: 5, BytesSent: 560, BytesRecv: 560, RTTMs: 3, ExecuteMs: 4},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Parse mount stats without nfs mounts",
+			args: args{
+				filePath: "../../testdata/host-mount-info",
+			},
+			want:    nil,
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMountStats(tt.args.filePath)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseMountStats() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMountStats() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMountStatsMalformed(t *testing.T) {
+	content := `device 10.0.0.1:/a mounted on /mnt/a with fstype nfs4 statvers=1.1
+	bytes:	1 2 x 4 5 6 7 8
+	xprt:	tcp
+	per-op statistics
+	        READ: 10 10 0 100 200 1 2 3 0
+	       WRITE: 1 2 bad
+device 10.0.0.2:/b mounted on /mnt/b with fstype nfs statvers=1.1
+	age:	60
+`
+	got, err := parseMountStats(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("parseMountStats() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("parseMountStats() got %d mounts, want 2", len(got))
+	}
+	if got[0].Bytes != (NFSBytesStats{}) || len(got[0].Xprts) != 0 {
+		t.Errorf("malformed bytes/xprt lines not skipped: %+v", got[0])
+	}
+	if len(got[0].Ops) != 1 || got[0].Ops[0].Op != "READ" {
+		t.Errorf("ops = %+v, want READ only", got[0].Ops)
+	}
+	if got[1].MountPoint != "/mnt/b" || got[1].Age != 60 {
+		t.Errorf("second mount = %+v", got[1])
+	}
+}
@@ -0,0 +1,193 @@
+package output
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/binary"
+	"github.com/cen-ngc5139/nfs-trace/internal/cache"
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
+	"github.com/cilium/ebpf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	NFSAccessCount     = "nfs_access_count"
+	NFSAccessSize      = "nfs_access_size"
+	NFSAccessLatencies = "nfs_access_latencies"
+	NFSAccessErrors    = "nfs_access_errors"
+)
+
+// 与 bpf/trace.c 中 enum access_method 保持一致
+const (
+	AccessMethodBuffered uint8 = iota
+	AccessMethodDirect
+	AccessMethodMmap
+	AccessMethodSplice
+)
+
+// accessMethodName 与调用栈判断的访问路径（accessPath）使用相同的名称
+func accessMethodName(method uint8) string {
+	switch method {
+	case AccessMethodDirect:
+		return AccessDirect
+	case AccessMethodMmap:
+		return AccessMmap
+	case AccessMethodSplice:
+		return AccessSplice
+	}
+	return "buffered"
+}
+
+// ProcessAccessMetrics 定期将 access_metrics 同步到 cache.NFSAccessMetricsMap
+func ProcessAccessMetrics(coll *ebpf.Collection, ctx context.Context) {
+	accessMetrics := coll.Maps["access_metrics"]
+
+	for {
+		var (
+			key   binary.NFSTraceAccessKey
+			stats binary.NFSTraceAccessStats
+		)
+		seen := make(map[binary.NFSTraceAccessKey]struct{})
+		iter := accessMetrics.Iterate()
+		for iter.Next(&key, &stats) {
+			cache.NFSAccessMetricsMap.Store(key, stats)
+			seen[key] = struct{}{}
+		}
+		if err := iter.Err(); err != nil {
+			log.Errorf("遍历 access_metrics 失败: %v", err)
+		} else {
+			// 删除内核中已被 LRU 淘汰的文件
+			cache.NFSAccessMetricsMap.Range(func(k, v interface{}) bool {
+				if _, ok := seen[k.(binary.NFSTraceAccessKey)]; !ok {
+					cache.NFSAccessMetricsMap.Delete(k)
+				}
+				return true
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Infof("退出访问方式统计")
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// AccessMetrics 按访问方式导出文件读写的次数、字节数、累计延迟和错误次数。
+// 内核按文件统计，导出时按挂载点和 Pod 汇总，不区分文件
+type AccessMetrics struct {
+	Count     *prometheus.GaugeVec
+	Size      *prometheus.GaugeVec
+	Latencies *prometheus.GaugeVec
+	Errors    *prometheus.GaugeVec
+	accessMap *sync.Map
+
+	mu sync.Mutex
+	// last 各文件上次导出时的统计，retired 已淘汰文件的累计值，保证汇总后的值不会减少
+	last    map[binary.NFSTraceAccessKey]accessSample
+	retired map[accessSeries]binary.NFSTraceAccessStats
+}
+
+type accessSeries struct {
+	method    string
+	op        string
+	server    string
+	mountPath string
+	pod       string
+	container string
+}
+
+type accessSample struct {
+	series accessSeries
+	stats  binary.NFSTraceAccessStats
+}
+
+func createAccessGaugeVec(name, help string) *prometheus.GaugeVec {
+	return promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: name,
+			Help: help,
+		},
+		[]string{"method", "op", "node_name", "nfs_server", "mount_path", "nfs_pod", "nfs_container"},
+	)
+}
+
+// NewAccessMetrics 创建并注册访问方式指标
+func NewAccessMetrics(accessMap *sync.Map) *AccessMetrics {
+	return &AccessMetrics{
+		Count:     createAccessGaugeVec(NFSAccessCount, "NFS file access count by access method"),
+		Size:      createAccessGaugeVec(NFSAccessSize, "NFS file access bytes by access method"),
+		Latencies: createAccessGaugeVec(NFSAccessLatencies, "NFS file access latencies by access method"),
+		Errors:    createAccessGaugeVec(NFSAccessErrors, "NFS file access errors by access method"),
+		accessMap: accessMap,
+		last:      make(map[binary.NFSTraceAccessKey]accessSample),
+		retired:   make(map[accessSeries]binary.NFSTraceAccessStats),
+	}
+}
+
+func addAccessStats(a, b binary.NFSTraceAccessStats) binary.NFSTraceAccessStats {
+	a.Count += b.Count
+	a.Bytes += b.Bytes
+	a.Lat += b.Lat
+	a.Errors += b.Errors
+	return a
+}
+
+// UpdateMetricsFromCache updates the Prometheus metrics from the NFSAccessMetricsMap
+func (m *AccessMetrics) UpdateMetricsFromCache(nodeName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := make(map[binary.NFSTraceAccessKey]accessSample)
+	m.accessMap.Range(func(k, v interface{}) bool {
+		key := k.(binary.NFSTraceAccessKey)
+
+		var file metadata.NFSFile
+		if fileInfo, ok := cache.NFSDevIDFileIDFileInfoMap.Load(key.Key); ok {
+			file = fileInfo.(metadata.NFSFile)
+		}
+
+		current[key] = accessSample{
+			series: accessSeries{
+				method:    accessMethodName(key.Method),
+				op:        rpcErrorOpName(key.Op, ""),
+				server:    file.RemoteNFSAddr,
+				mountPath: file.MountPath,
+				pod:       file.Pod,
+				container: file.Container,
+			},
+			stats: v.(binary.NFSTraceAccessStats),
+		}
+		return true
+	})
+
+	for key, sample := range m.last {
+		if _, ok := current[key]; !ok {
+			m.retired[sample.series] = addAccessStats(m.retired[sample.series], sample.stats)
+		}
+	}
+	m.last = current
+
+	series := make(map[accessSeries]binary.NFSTraceAccessStats, len(m.retired))
+	for s, stats := range m.retired {
+		series[s] = stats
+	}
+	for _, sample := range current {
+		series[sample.series] = addAccessStats(series[sample.series], sample.stats)
+	}
+
+	for s, stats := range series {
+		labels := []string{s.method, s.op, nodeName, s.server, s.mountPath, s.pod, s.container}
+		m.Count.WithLabelValues(labels...).Set(float64(stats.Count))
+		m.Size.WithLabelValues(labels...).Set(float64(stats.Bytes))
+		m.Latencies.WithLabelValues(labels...).Set(float64(stats.Lat))
+		if stats.Errors > 0 {
+			m.Errors.WithLabelValues(labels...).Set(float64(stats.Errors))
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package output
+
+import (
+	"sync"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/bpf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	NFSTraceBPFMapEntries    = "nfs_trace_bpf_map_entries"
+	NFSTraceBPFMapMaxEntries = "nfs_trace_bpf_map_max_entries"
+)
+
+// MapUsageMetrics eBPF map 的使用情况，用于发现容量不足导致的数据丢失
+type MapUsageMetrics struct {
+	Entries    *prometheus.GaugeVec
+	MaxEntries *prometheus.GaugeVec
+	usageMap   *sync.Map
+}
+
+func createMapUsageGaugeVec(name, help string) *prometheus.GaugeVec {
+	return promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: name,
+			Help: help,
+		},
+		[]string{"map", "node_name"},
+	)
+}
+
+// NewMapUsageMetrics 创建并注册 map 使用情况指标
+func NewMapUsageMetrics(usageMap *sync.Map) *MapUsageMetrics {
+	return &MapUsageMetrics{
+		Entries:    createMapUsageGaugeVec(NFSTraceBPFMapEntries, "Number of entries in the BPF map"),
+		MaxEntries: createMapUsageGaugeVec(NFSTraceBPFMapMaxEntries, "Capacity of the BPF map"),
+		usageMap:   usageMap,
+	}
+}
+
+// UpdateMetricsFromCache updates the Prometheus metrics from the BPFMapUsageMap
+func (m *MapUsageMetrics) UpdateMetricsFromCache(nodeName string) {
+	m.usageMap.Range(func(key, value interface{}) bool {
+		name := key.(string)
+		usage := value.(bpf.MapUsage)
+
+		m.Entries.WithLabelValues(name, nodeName).Set(float64(usage.Entries))
+		m.MaxEntries.WithLabelValues(name, nodeName).Set(float64(usage.MaxEntries))
+
+		return true
+	})
+}
@@ -14,19 +14,67 @@ import (
 	"github.com/cen-ngc5139/nfs-trace/internal/log"
 	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
 	"github.com/cilium/ebpf"
-	"github.com/cilium/ebpf/perf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"k8s.io/klog/v2"
 )
 
+const NFSTraceSuppressedEvents = "nfs_trace_suppressed_events_total"
+
+// suppressedEvents 内核中按 --dedup-interval 去重、未输出事件的文件访问次数，来自 suppressed_events，
+// 不依赖事件中的 suppressed 字段，进程退出或去重记录被淘汰时不会丢失
+var suppressedEvents = promauto.NewCounter(prometheus.CounterOpts{
+	Name: NFSTraceSuppressedEvents,
+	Help: "Number of file access events suppressed by in-kernel deduplication",
+})
+
+// addSuppressed 存在去重时写入事件的 suppressed 字段
+func addSuppressed(fields map[string]interface{}, suppressed uint64) {
+	if suppressed == 0 {
+		return
+	}
+
+	fields["suppressed"] = suppressed
+}
+
+// ProcessSuppressedEvents 定期读取 suppressed_events，累加到 nfs_trace_suppressed_events_total
+func ProcessSuppressedEvents(coll *ebpf.Collection, ctx context.Context) {
+	counter := coll.Maps["suppressed_events"]
+
+	var last uint64
+	for {
+		total, err := sumPerCPU(counter, 0)
+		if err != nil {
+			log.Errorf("读取 suppressed_events 失败: %v", err)
+		} else if total > last {
+			suppressedEvents.Add(float64(total - last))
+			last = total
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Infof("退出去重次数统计")
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
 func ProcessEvents(coll *ebpf.Collection, ctx context.Context, addr2name bpf.Addr2Name, cfg config.Configuration) {
 	events := coll.Maps["nfs_trace_map"]
-	// Set up a perf reader to read events from the eBPF program
-	rd, err := perf.NewReader(events, os.Getpagesize())
+	// Set up a ringbuf or perf reader to read events from the eBPF program
+	rd, err := newEventReader(events, cfg)
 	if err != nil {
-		log.Fatalf("Creating perf reader failed: %v\n", err)
+		log.Fatalf("Creating event reader failed: %v\n", err)
 	}
 	defer rd.Close()
 
+	nodeName, err := os.Hostname()
+	if err != nil {
+		nodeName = "default_node"
+	}
+
+	stacks := coll.Maps["stack_traces"]
 	var event ebpfbinary.NFSTraceRpcTaskFields
 	for {
 		for {
@@ -43,20 +91,32 @@ func ProcessEvents(coll *ebpf.Collection, ctx context.Context, addr2name bpf.Add
 			}
 		}
 
-		mountPath := config.GetProcPath(fmt.Sprintf("%d/mountinfo", event.Pid))
-		mountList, err := metadata.ParseMountInfo(mountPath)
-		if err != nil {
-			klog.Errorf("Failed to get mount info: %v", err)
-			continue
+		fields := make(map[string]interface{})
+		addSuppressed(fields, event.Suppressed)
+
+		// 调用栈可能被新的调用栈覆盖，先于其他处理读取
+		var frames []string
+		if cfg.Features.StackTrace {
+			frames = readStack(stacks, event.StackId, addr2name)
 		}
 
-		mountInfo, err := metadata.GetMountInfoFormObj(fmt.Sprintf("%d", event.MountId), mountList)
-		if err != nil {
+		// 服务端地址和版本来自内核，mountinfo 只用于补充挂载路径和导出目录
+		serverAddr := formatSockaddr(event.ServerAddr)
+		mountInfo, err := lookupMountInfo(event.Pid, event.MountId)
+		if err != nil && serverAddr == "" {
 			klog.Errorf("Failed to get mount info: %v", err)
 			continue
 		}
 
-		funcName := addr2name.FindNearestSym(event.CallerAddr)
+		// funcName 为调用方，fentry 模式下没有返回地址；probeFunc 为被追踪的函数，
+		// fentry 模式下内核不支持 bpf_get_func_ip 时没有函数地址
+		var funcName, probeFunc string
+		if event.CallerAddr != 0 {
+			funcName = addr2name.FindNearestSym(event.CallerAddr)
+		}
+		if event.FuncIp != 0 {
+			probeFunc = addr2name.FindNearestSym(event.FuncIp)
+		}
 		podName := sanitizeString(convertInt8ToString(event.Pod[:]))
 		containerName := sanitizeString(convertInt8ToString(event.Container[:]))
 
@@ -64,16 +124,32 @@ func ProcessEvents(coll *ebpf.Collection, ctx context.Context, addr2name bpf.Add
 			MountPath:     mountInfo.LocalMountDir,
 			RemoteNFSAddr: mountInfo.RemoteNFSAddr,
 			LocalMountDir: mountInfo.LocalMountDir,
+			ServerAddr:    serverAddr,
+			NFSVersion:    nfsVersion(event.NfsVersion, event.NfsMinor),
 			Pod:           podName,
 			Container:     containerName,
 		}
+		if mount.RemoteNFSAddr == "" {
+			mount.RemoteNFSAddr = serverHost(serverAddr)
+		}
+		if serverAddr != "" {
+			serverInfo.WithLabelValues(nodeName, mount.RemoteNFSAddr, serverAddr, mount.NFSVersion, mount.MountPath).Set(1)
+		}
 
 		filePath, ok := cache.NFSFileDetailMap.Load(event.Key)
 		if ok {
-			mount.FilePath = filePath.(string)
+			mount.FilePath = filePath.(metadata.FilePath).Path
+			mount.PathTruncated = filePath.(metadata.FilePath).Truncated
 		}
 
-		log.StdoutOrFile(cfg.Output.Type, mount, map[string]interface{}{"funcName": funcName})
+		fields["funcName"] = funcName
+		fields["probeFunc"] = probeFunc
+		if frames != nil {
+			fields["stack"] = frames
+			fields["access_path"] = accessPath(frames)
+		}
+
+		log.StdoutOrFile(cfg.Output.Type, mount, fields)
 
 		// 保存devID+fileID和文件信息的映射关系, 如果已经存在，则覆盖
 		cache.NFSDevIDFileIDFileInfoMap.Store(event.Key, mount)
@@ -87,6 +163,19 @@ func ProcessEvents(coll *ebpf.Collection, ctx context.Context, addr2name bpf.Add
 	}
 }
 
+// lookupMountInfo 优先从进程的 mountinfo 查找挂载信息，进程已退出时使用全局的挂载信息缓存
+func lookupMountInfo(pid, mountID int32) (metadata.MountInfo, error) {
+	id := fmt.Sprintf("%d", mountID)
+	mountList, err := metadata.ParseMountInfo(config.GetProcPath(fmt.Sprintf("%d/mountinfo", pid)))
+	if err == nil {
+		if mountInfo, err := metadata.GetMountInfoFormObj(id, mountList); err == nil {
+			return mountInfo, nil
+		}
+	}
+
+	return metadata.GetMountInfoFromCache(id)
+}
+
 func sanitizeString(s string) string {
 	return strings.TrimSpace(s)
 }
@@ -0,0 +1,19 @@
+package output
+
+import (
+	"testing"
+)
+
+func TestAddSuppressed(t *testing.T) {
+	fields := make(map[string]interface{})
+	addSuppressed(fields, 0)
+	if _, ok := fields["suppressed"]; ok {
+		t.Errorf("fields = %v, want no suppressed field", fields)
+	}
+
+	// 超过 u32 的计数不会被截断
+	addSuppressed(fields, 1<<32+2)
+	if fields["suppressed"] != uint64(1<<32+2) {
+		t.Errorf("suppressed = %v, want %d", fields["suppressed"], uint64(1<<32+2))
+	}
+}
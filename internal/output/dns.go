@@ -2,7 +2,6 @@ package output
 
 import (
 	"context"
-	"os"
 	"strings"
 	"time"
 
@@ -12,15 +11,14 @@ import (
 	"github.com/cen-ngc5139/nfs-trace/internal/log"
 	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
 	"github.com/cilium/ebpf"
-	"github.com/cilium/ebpf/perf"
 )
 
 func ProcessDNS(coll *ebpf.Collection, ctx context.Context, cfg config.Configuration) {
 	events := coll.Maps["dns_events"]
-	// Set up a perf reader to read events from the eBPF program
-	rd, err := perf.NewReader(events, os.Getpagesize())
+	// Set up a ringbuf or perf reader to read events from the eBPF program
+	rd, err := newEventReader(events, cfg)
 	if err != nil {
-		log.Fatalf("Creating perf reader failed: %v\n", err)
+		log.Fatalf("Creating event reader failed: %v\n", err)
 	}
 	defer rd.Close()
 
@@ -0,0 +1,249 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/binary"
+	"github.com/cen-ngc5139/nfs-trace/internal/cache"
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
+	"github.com/cilium/ebpf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/sys/unix"
+)
+
+const NFSRPCErrorsTotal = "nfs_rpc_errors_total"
+
+// 与 bpf/trace.c 中 enum rpc_error_op / rpc_error_layer 保持一致
+const (
+	RPCErrorOpUnknown uint8 = iota
+	RPCErrorOpRead
+	RPCErrorOpWrite
+)
+
+const (
+	RPCErrorLayerRPC uint8 = iota
+	RPCErrorLayerNFS
+)
+
+// nfs4ErrorNames NFSv4 协议错误码，参考 include/linux/nfs4.h
+var nfs4ErrorNames = map[int32]string{
+	10001: "NFS4ERR_BADHANDLE",
+	10003: "NFS4ERR_BAD_COOKIE",
+	10004: "NFS4ERR_NOTSUPP",
+	10005: "NFS4ERR_TOOSMALL",
+	10006: "NFS4ERR_SERVERFAULT",
+	10007: "NFS4ERR_BADTYPE",
+	10008: "NFS4ERR_DELAY",
+	10009: "NFS4ERR_SAME",
+	10010: "NFS4ERR_DENIED",
+	10011: "NFS4ERR_EXPIRED",
+	10012: "NFS4ERR_LOCKED",
+	10013: "NFS4ERR_GRACE",
+	10014: "NFS4ERR_FHEXPIRED",
+	10015: "NFS4ERR_SHARE_DENIED",
+	10016: "NFS4ERR_WRONGSEC",
+	10017: "NFS4ERR_CLID_INUSE",
+	10018: "NFS4ERR_RESOURCE",
+	10019: "NFS4ERR_MOVED",
+	10020: "NFS4ERR_NOFILEHANDLE",
+	10021: "NFS4ERR_MINOR_VERS_MISMATCH",
+	10022: "NFS4ERR_STALE_CLIENTID",
+	10023: "NFS4ERR_STALE_STATEID",
+	10024: "NFS4ERR_OLD_STATEID",
+	10025: "NFS4ERR_BAD_STATEID",
+	10026: "NFS4ERR_BAD_SEQID",
+	10027: "NFS4ERR_NOT_SAME",
+	10028: "NFS4ERR_LOCK_RANGE",
+	10029: "NFS4ERR_SYMLINK",
+	10030: "NFS4ERR_RESTOREFH",
+	10031: "NFS4ERR_LEASE_MOVED",
+	10032: "NFS4ERR_ATTRNOTSUPP",
+	10033: "NFS4ERR_NO_GRACE",
+	10034: "NFS4ERR_RECLAIM_BAD",
+	10035: "NFS4ERR_RECLAIM_CONFLICT",
+	10036: "NFS4ERR_BADXDR",
+	10037: "NFS4ERR_LOCKS_HELD",
+	10038: "NFS4ERR_OPENMODE",
+	10039: "NFS4ERR_BADOWNER",
+	10040: "NFS4ERR_BADCHAR",
+	10041: "NFS4ERR_BADNAME",
+	10042: "NFS4ERR_BAD_RANGE",
+	10043: "NFS4ERR_LOCK_NOTSUPP",
+	10044: "NFS4ERR_OP_ILLEGAL",
+	10045: "NFS4ERR_DEADLOCK",
+	10046: "NFS4ERR_FILE_OPEN",
+	10047: "NFS4ERR_ADMIN_REVOKED",
+	10048: "NFS4ERR_CB_PATH_DOWN",
+	10049: "NFS4ERR_BADIOMODE",
+	10050: "NFS4ERR_BADLAYOUT",
+	10051: "NFS4ERR_BAD_SESSION_DIGEST",
+	10052: "NFS4ERR_BADSESSION",
+	10053: "NFS4ERR_BADSLOT",
+	10054: "NFS4ERR_COMPLETE_ALREADY",
+	10055: "NFS4ERR_CONN_NOT_BOUND_TO_SESSION",
+	10056: "NFS4ERR_DELEG_ALREADY_WANTED",
+	10057: "NFS4ERR_BACK_CHAN_BUSY",
+	10058: "NFS4ERR_LAYOUTTRYLATER",
+	10059: "NFS4ERR_LAYOUTUNAVAILABLE",
+	10060: "NFS4ERR_NOMATCHING_LAYOUT",
+	10061: "NFS4ERR_RECALLCONFLICT",
+	10062: "NFS4ERR_UNKNOWN_LAYOUTTYPE",
+	10063: "NFS4ERR_SEQ_MISORDERED",
+	10064: "NFS4ERR_SEQUENCE_POS",
+	10065: "NFS4ERR_REQ_TOO_BIG",
+	10066: "NFS4ERR_REP_TOO_BIG",
+	10067: "NFS4ERR_REP_TOO_BIG_TO_CACHE",
+	10068: "NFS4ERR_RETRY_UNCACHED_REP",
+	10069: "NFS4ERR_UNSAFE_COMPOUND",
+	10070: "NFS4ERR_TOO_MANY_OPS",
+	10071: "NFS4ERR_OP_NOT_IN_SESSION",
+	10072: "NFS4ERR_HASH_ALG_UNSUPP",
+	10074: "NFS4ERR_CLIENTID_BUSY",
+	10075: "NFS4ERR_PNFS_IO_HOLE",
+	10076: "NFS4ERR_SEQ_FALSE_RETRY",
+	10077: "NFS4ERR_BAD_HIGH_SLOT",
+	10078: "NFS4ERR_DEADSESSION",
+	10079: "NFS4ERR_ENCR_ALG_UNSUPP",
+	10080: "NFS4ERR_PNFS_NO_LAYOUT",
+	10081: "NFS4ERR_NOT_ONLY_OP",
+	10082: "NFS4ERR_WRONG_CRED",
+	10083: "NFS4ERR_WRONG_TYPE",
+	10084: "NFS4ERR_DIRDELEG_UNAVAIL",
+	10085: "NFS4ERR_REJECT_DELEG",
+	10086: "NFS4ERR_RETURNCONFLICT",
+	10087: "NFS4ERR_DELEG_REVOKED",
+}
+
+var rpcErrorsTotal = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: NFSRPCErrorsTotal,
+		Help: "NFS RPC errors by status",
+	},
+	[]string{"status", "op", "layer", "node_name", "nfs_server", "mount_path", "nfs_pod", "nfs_container"},
+)
+
+// StatusName 将内核返回的 RPC/NFS 状态码转换为符号名称
+// 内核内部使用负 errno（如 -ESTALE），NFSv4 协议错误以 -NFS4ERR_* 形式透传
+func StatusName(status int32) string {
+	if status == 0 {
+		return "OK"
+	}
+
+	code := status
+	if code < 0 {
+		code = -code
+	}
+
+	if name, ok := nfs4ErrorNames[code]; ok {
+		return name
+	}
+
+	if name := unix.ErrnoName(syscall.Errno(code)); name != "" {
+		return "-" + name
+	}
+
+	return fmt.Sprintf("%d", status)
+}
+
+func rpcErrorOpName(op uint8, proc string) string {
+	switch op {
+	case RPCErrorOpRead:
+		return "read"
+	case RPCErrorOpWrite:
+		return "write"
+	}
+
+	if proc != "" {
+		return proc
+	}
+	return "unknown"
+}
+
+func rpcErrorLayerName(layer uint8) string {
+	if layer == RPCErrorLayerNFS {
+		return "nfs"
+	}
+	return "rpc"
+}
+
+func ProcessRPCErrors(coll *ebpf.Collection, ctx context.Context, cfg config.Configuration) {
+	events := coll.Maps["rpc_error_events"]
+	// Set up a ringbuf or perf reader to read events from the eBPF program
+	rd, err := newEventReader(events, cfg)
+	if err != nil {
+		log.Fatalf("Creating event reader failed: %v\n", err)
+	}
+	defer rd.Close()
+
+	nodeName, err := os.Hostname()
+	if err != nil {
+		nodeName = "default_node"
+	}
+
+	var event binary.NFSTraceRpcErrorEvent
+	for {
+		for {
+			if err := parseEvent(rd, &event); err == nil {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				log.Infof("退出 RPC 错误处理")
+				return
+			case <-time.After(time.Microsecond):
+				continue
+			}
+		}
+
+		// 从 metadata 中获取文件信息
+		var file metadata.NFSFile
+		if event.Key != (binary.NFSTraceFileKey{}) {
+			if fileInfo, ok := cache.NFSDevIDFileIDFileInfoMap.Load(event.Key); ok {
+				file = fileInfo.(metadata.NFSFile)
+			}
+			if filePath, ok := cache.NFSFileDetailMap.Load(event.Key); ok {
+				file.FilePath = filePath.(metadata.FilePath).Path
+				file.PathTruncated = filePath.(metadata.FilePath).Truncated
+			}
+		}
+
+		if pod := sanitizeString(convertInt8ToString(event.Pod[:])); pod != "" {
+			file.Pod = pod
+			file.Container = sanitizeString(convertInt8ToString(event.Container[:]))
+		}
+
+		status := StatusName(event.Status)
+		op := rpcErrorOpName(event.Op, convertInt8ToString(event.Proc[:]))
+		layer := rpcErrorLayerName(event.Layer)
+
+		log.StdoutOrFile(cfg.Output.Type, file, map[string]interface{}{
+			"event":     "rpc_error",
+			"task_id":   event.TaskId,
+			"client_id": event.ClientId,
+			"pid":       event.Pid,
+			"status":    status,
+			"errno":     event.Status,
+			"op":        op,
+			"layer":     layer,
+		})
+
+		// 文件路径只写入事件，指标按挂载点聚合，避免标签基数随文件数增长
+		rpcErrorsTotal.WithLabelValues(status, op, layer, nodeName, file.RemoteNFSAddr, file.MountPath,
+			file.Pod, file.Container).Inc()
+
+		select {
+		case <-ctx.Done():
+			log.Infof("退出 RPC 错误处理")
+			return
+		default:
+		}
+	}
+}
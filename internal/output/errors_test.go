@@ -0,0 +1,27 @@
+package output
+
+import "testing"
+
+func TestStatusName(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int32
+		want   string
+	}{
+		{name: "ok", status: 0, want: "OK"},
+		{name: "stale", status: -116, want: "-ESTALE"},
+		{name: "timeout", status: -110, want: "-ETIMEDOUT"},
+		{name: "access", status: -13, want: "-EACCES"},
+		{name: "io", status: -5, want: "-EIO"},
+		{name: "nfs4 delay", status: -10008, want: "NFS4ERR_DELAY"},
+		{name: "nfs4 bad stateid", status: -10025, want: "NFS4ERR_BAD_STATEID"},
+		{name: "unknown", status: -9999, want: "-9999"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StatusName(tt.status); got != tt.want {
+				t.Errorf("StatusName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
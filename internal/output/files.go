@@ -2,7 +2,6 @@ package output
 
 import (
 	"context"
-	"os"
 	"sort"
 	"strings"
 	"sync"
@@ -10,60 +9,74 @@ import (
 
 	"github.com/cen-ngc5139/nfs-trace/internal/binary"
 	"github.com/cen-ngc5139/nfs-trace/internal/cache"
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
 	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
 	"github.com/cilium/ebpf"
-	"github.com/cilium/ebpf/perf"
 	"golang.org/x/sys/unix"
 )
 
 type PathCache struct {
 	paths         *sync.Map
-	partialBuffer map[uint64][]binary.NFSTracePathSegment
+	partialBuffer map[binary.NFSTraceFileKey][]binary.NFSTracePathSegment
 }
 
 func NewPathCache() *PathCache {
 	return &PathCache{
 		paths:         cache.NFSFileDetailMap,
-		partialBuffer: make(map[uint64][]binary.NFSTracePathSegment),
+		partialBuffer: make(map[binary.NFSTraceFileKey][]binary.NFSTracePathSegment),
 	}
 }
 
-func (pc *PathCache) Get(devID, fileID uint64) (string, bool) {
-	key := (devID << 32) | fileID
+func (pc *PathCache) Get(key binary.NFSTraceFileKey) (metadata.FilePath, bool) {
 	if value, ok := pc.paths.Load(key); ok {
-		return value.(string), true
+		return value.(metadata.FilePath), true
 	}
-	return "", false
+	return metadata.FilePath{}, false
 }
 
-func (pc *PathCache) Set(devID, fileID uint64, path string) {
-	key := (devID << 32) | fileID
+func (pc *PathCache) Set(key binary.NFSTraceFileKey, path metadata.FilePath) {
 	pc.paths.Store(key, path)
 }
 
-// rebuildPath 重建路径
-func rebuildPath(segments []binary.NFSTracePathSegment) string {
+// 与 bpf/trace.c 中 enum path_segment_flag 保持一致
+const (
+	pathComplete  uint8 = 1
+	pathTruncated uint8 = 2
+)
+
+// rebuildPath 按深度从根目录开始重建路径，同一文件名的多个分片按顺序拼接
+func rebuildPath(segments []binary.NFSTracePathSegment) metadata.FilePath {
 	sort.Slice(segments, func(i, j int) bool {
-		return segments[i].Depth > segments[j].Depth
+		if segments[i].Depth != segments[j].Depth {
+			return segments[i].Depth > segments[j].Depth
+		}
+		return segments[i].Chunk < segments[j].Chunk
 	})
 
 	var path strings.Builder
+	var truncated bool
 	for _, seg := range segments {
 		fileName := unix.ByteSliceToString(seg.Name[:])
-		if fileName != "/" {
+		if seg.Chunk == 0 && fileName != "/" {
 			path.WriteByte('/')
 		}
 		path.Write([]byte(fileName))
+		truncated = truncated || seg.Flags&pathTruncated != 0
+	}
+
+	return metadata.FilePath{
+		Path:      strings.ReplaceAll(path.String(), "//", "/"),
+		Truncated: truncated,
 	}
-	return strings.ReplaceAll(path.String(), "//", "/")
 }
 
-func ProcessFiles(coll *ebpf.Collection, ctx context.Context) {
+func ProcessFiles(coll *ebpf.Collection, ctx context.Context, cfg config.Configuration) {
 	events := coll.Maps["path_ringbuf"]
-	// Set up a perf reader to read events from the eBPF program
-	rd, err := perf.NewReader(events, os.Getpagesize())
+	// Set up a ringbuf or perf reader to read events from the eBPF program
+	rd, err := newEventReader(events, cfg)
 	if err != nil {
-		log.Fatalf("Creating perf reader failed: %v\n", err)
+		log.Fatalf("Creating event reader failed: %v\n", err)
 	}
 	defer rd.Close()
 
@@ -84,13 +97,13 @@ func ProcessFiles(coll *ebpf.Collection, ctx context.Context) {
 			}
 		}
 
-		key := (event.DevId << 32) | event.FileId
-		if event.IsComplete != 0 {
+		key := binary.NFSTraceFileKey{DevId: event.DevId, FileId: event.FileId}
+		if event.Flags&pathComplete != 0 {
 			partial := pc.partialBuffer[key]
 			partial = append(partial, event)
 
 			path := rebuildPath(partial)
-			pc.Set(event.DevId, event.FileId, path)
+			pc.Set(key, path)
 			delete(pc.partialBuffer, key)
 		} else {
 			// 如果是部分路径段，放入 partialBuffer
@@ -0,0 +1,57 @@
+package output
+
+import (
+	"testing"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/binary"
+	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
+)
+
+func segment(depth uint16, chunk uint8, flags uint8, name string) binary.NFSTracePathSegment {
+	seg := binary.NFSTracePathSegment{Depth: depth, Chunk: chunk, Flags: flags}
+	copy(seg.Name[:], name)
+	return seg
+}
+
+func TestRebuildPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		segments []binary.NFSTracePathSegment
+		want     metadata.FilePath
+	}{
+		{
+			name: "complete",
+			segments: []binary.NFSTracePathSegment{
+				segment(0, 0, 0, "file"),
+				segment(1, 0, 0, "data"),
+				segment(2, 0, pathComplete, "/"),
+			},
+			want: metadata.FilePath{Path: "/data/file"},
+		},
+		{
+			name: "long name in chunks",
+			segments: []binary.NFSTracePathSegment{
+				segment(0, 1, 0, "def"),
+				segment(0, 0, 0, "abc"),
+				segment(1, 0, pathComplete, "/"),
+			},
+			want: metadata.FilePath{Path: "/abcdef"},
+		},
+		{
+			name: "truncated",
+			segments: []binary.NFSTracePathSegment{
+				segment(0, 0, 0, "file"),
+				segment(1, 0, pathComplete|pathTruncated, "deep"),
+			},
+			want: metadata.FilePath{Path: "/deep/file", Truncated: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rebuildPath(tt.segments); got != tt.want {
+				t.Errorf("rebuildPath() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
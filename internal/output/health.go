@@ -0,0 +1,432 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/binary"
+	"github.com/cen-ngc5139/nfs-trace/internal/cache"
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
+	"github.com/cilium/ebpf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	NFSServerUp            = "nfs_server_up"
+	NFSServerTimeoutsTotal = "nfs_server_timeouts_total"
+)
+
+// 与 bpf/trace.c 中 enum server_event_type 保持一致
+const (
+	serverEventMinorTimeout uint8 = iota
+	serverEventNotResponding
+	serverEventMajorTimeout
+	serverEventOK
+)
+
+var serverEventNames = []string{"minor_timeout", "not_responding", "major_timeout", "server_ok"}
+
+var (
+	serverUp = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: NFSServerUp,
+			Help: "Whether the NFS server responds to RPC requests, 0 after a major timeout until the next reply",
+		},
+		[]string{"node_name", "nfs_server"},
+	)
+
+	serverTimeoutsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: NFSServerTimeoutsTotal,
+			Help: "NFS RPC timeouts per server, minor timeouts are retransmitted, major timeouts mean the server is not responding",
+		},
+		[]string{"type", "node_name", "nfs_server"},
+	)
+)
+
+func serverEventName(typ uint8) string {
+	if int(typ) < len(serverEventNames) {
+		return serverEventNames[typ]
+	}
+	return "unknown"
+}
+
+// remoteHost 返回 mountinfo 中 host:/export 的 host，IPv6 地址去掉方括号
+func remoteHost(remote string) string {
+	if strings.HasPrefix(remote, "[") {
+		if end := strings.Index(remote, "]"); end > 0 {
+			return remote[1:end]
+		}
+	}
+	host, _, _ := strings.Cut(remote, ":")
+	return host
+}
+
+type serverOutage struct {
+	server string
+	// hosts nfs_server_up 中的 nfs_server 标签，与定期导出的挂载点服务端一致
+	hosts []string
+	since time.Time
+	pods  map[string]struct{}
+}
+
+// ServerHealthTracker 按传输层记录无响应的服务端，同一服务端的任一传输层无响应时视为不可用
+type ServerHealthTracker struct {
+	mu      sync.Mutex
+	outages map[uint64]*serverOutage
+	// exported 已导出的 nfs_server_up 序列，服务端不再挂载且已恢复时删除
+	exported map[string]struct{}
+}
+
+// ServerHealth 全局的服务端可用性
+var ServerHealth = NewServerHealthTracker()
+
+func NewServerHealthTracker() *ServerHealthTracker {
+	return &ServerHealthTracker{
+		outages:  make(map[uint64]*serverOutage),
+		exported: make(map[string]struct{}),
+	}
+}
+
+// Observe 根据事件更新服务端状态，hosts 为传输层对应的挂载点服务端，返回无响应期间受影响的 Pod 和开始时间
+func (t *ServerHealthTracker) Observe(event binary.NFSTraceServerEvent, hosts []string, pod string, now time.Time) ([]string, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	outage, ok := t.outages[event.Xprt]
+	switch {
+	case event.Type == serverEventNotResponding || (event.Type == serverEventMajorTimeout && !ok):
+		if !ok {
+			server := convertInt8ToString(event.Addr[:])
+			if len(hosts) == 0 {
+				hosts = []string{server}
+			}
+			outage = &serverOutage{
+				server: server,
+				hosts:  hosts,
+				since:  now.Add(-time.Duration(event.Duration)),
+				pods:   make(map[string]struct{}),
+			}
+			t.outages[event.Xprt] = outage
+		}
+	case event.Type == serverEventOK:
+		delete(t.outages, event.Xprt)
+	}
+
+	if outage == nil {
+		return nil, time.Time{}
+	}
+
+	if pod != "" {
+		outage.pods[pod] = struct{}{}
+	}
+
+	pods := make([]string, 0, len(outage.pods))
+	for p := range outage.pods {
+		pods = append(pods, p)
+	}
+	sort.Strings(pods)
+
+	return pods, outage.since
+}
+
+// Down 返回服务端是否有传输层无响应
+func (t *ServerHealthTracker) Down(host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, outage := range t.outages {
+		for _, h := range outage.hosts {
+			if h == host {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expire 删除传输层已不在 dev_xprt 中、且服务端已不再挂载的无响应记录。
+// 传输层释放或挂载点卸载后不会再收到 server_ok，不删除时服务端会一直被视为不可用
+func (t *ServerHealthTracker) expire(live map[uint64]struct{}, mounted []string) {
+	hosts := make(map[string]struct{}, len(mounted))
+	for _, host := range mounted {
+		hosts[host] = struct{}{}
+	}
+
+	for xprt, outage := range t.outages {
+		if _, ok := live[xprt]; ok {
+			continue
+		}
+
+		stale := true
+		for _, host := range outage.hosts {
+			if _, ok := hosts[host]; ok {
+				stale = false
+				break
+			}
+		}
+		if stale {
+			delete(t.outages, xprt)
+		}
+	}
+}
+
+// states 返回各服务端的可用性，挂载的服务端为可用，无响应的服务端为不可用
+func (t *ServerHealthTracker) states(mounted []string) map[string]bool {
+	servers := make(map[string]bool, len(mounted))
+	for _, host := range mounted {
+		servers[host] = true
+	}
+
+	for _, outage := range t.outages {
+		for _, host := range outage.hosts {
+			servers[host] = false
+		}
+	}
+
+	return servers
+}
+
+// UpdateMetricsFromCache 导出挂载的服务端以及无响应的服务端的可用性，删除已不存在的序列
+func (t *ServerHealthTracker) UpdateMetricsFromCache(nodeName string) {
+	var mounted []string
+	cache.MountInfoMap.Range(func(key, value interface{}) bool {
+		mount := value.(metadata.MountInfo)
+		if mount.RemoteNFSAddr != "" {
+			mounted = append(mounted, remoteHost(mount.RemoteNFSAddr))
+		}
+		return true
+	})
+
+	live := make(map[uint64]struct{})
+	cache.DevXprtMap.Range(func(key, value interface{}) bool {
+		live[value.(uint64)] = struct{}{}
+		return true
+	})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expire(live, mounted)
+	servers := t.states(mounted)
+	for server, up := range servers {
+		value := 0.0
+		if up {
+			value = 1
+		}
+		serverUp.WithLabelValues(nodeName, server).Set(value)
+	}
+
+	for server := range t.exported {
+		if _, ok := servers[server]; !ok {
+			serverUp.DeleteLabelValues(nodeName, server)
+			delete(t.exported, server)
+		}
+	}
+	for server := range servers {
+		t.exported[server] = struct{}{}
+	}
+}
+
+// affectedMounts 返回使用该传输层或服务端的挂载点，按挂载路径排序。
+// 传输层与挂载点的对应关系来自 dev_xprt，文件访问时由内核更新
+func affectedMounts(xprt uint64, server string) []metadata.MountInfo {
+	seen := make(map[string]metadata.MountInfo)
+	cache.DevXprtMap.Range(func(key, value interface{}) bool {
+		if value.(uint64) != xprt {
+			return true
+		}
+		if mount, ok := metadata.GetMountInfoByDev(key.(uint32)); ok {
+			seen[mount.LocalMountDir] = mount
+		}
+		return true
+	})
+
+	// 按 IP 挂载时可以直接匹配 mountinfo 中的服务端
+	cache.MountInfoMap.Range(func(key, value interface{}) bool {
+		mount := value.(metadata.MountInfo)
+		if mount.RemoteNFSAddr != "" && remoteHost(mount.RemoteNFSAddr) == server {
+			seen[mount.LocalMountDir] = mount
+		}
+		return true
+	})
+
+	mounts := make([]metadata.MountInfo, 0, len(seen))
+	for _, m := range seen {
+		mounts = append(mounts, m)
+	}
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].LocalMountDir < mounts[j].LocalMountDir })
+	return mounts
+}
+
+// mountDirs 返回挂载路径
+func mountDirs(mounts []metadata.MountInfo) []string {
+	dirs := make([]string, 0, len(mounts))
+	for _, m := range mounts {
+		dirs = append(dirs, m.LocalMountDir)
+	}
+	return dirs
+}
+
+// mountHosts 返回挂载点 mountinfo 中的服务端，去重后排序
+func mountHosts(mounts []metadata.MountInfo) []string {
+	seen := make(map[string]struct{}, len(mounts))
+	hosts := make([]string, 0, len(mounts))
+	for _, m := range mounts {
+		host := remoteHost(m.RemoteNFSAddr)
+		if _, ok := seen[host]; ok {
+			continue
+		}
+		seen[host] = struct{}{}
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// mountPods 返回访问过这些挂载点上文件的 Pod
+func mountPods(mounts []string) []string {
+	if len(mounts) == 0 {
+		return nil
+	}
+
+	mountSet := make(map[string]struct{}, len(mounts))
+	for _, m := range mounts {
+		mountSet[m] = struct{}{}
+	}
+
+	seen := make(map[string]struct{})
+	cache.NFSDevIDFileIDFileInfoMap.Range(func(key, value interface{}) bool {
+		file := value.(metadata.NFSFile)
+		if _, ok := mountSet[file.MountPath]; ok && file.Pod != "" {
+			seen[file.Pod] = struct{}{}
+		}
+		return true
+	})
+
+	pods := make([]string, 0, len(seen))
+	for p := range seen {
+		pods = append(pods, p)
+	}
+	sort.Strings(pods)
+	return pods
+}
+
+func ProcessServerEvents(coll *ebpf.Collection, ctx context.Context, cfg config.Configuration) {
+	events := coll.Maps["server_events"]
+	devMap := coll.Maps["dev_xprt"]
+	// Set up a ringbuf or perf reader to read events from the eBPF program
+	rd, err := newEventReader(events, cfg)
+	if err != nil {
+		log.Fatalf("Creating event reader failed: %v\n", err)
+	}
+	defer rd.Close()
+
+	nodeName, err := os.Hostname()
+	if err != nil {
+		nodeName = "default_node"
+	}
+
+	var event binary.NFSTraceServerEvent
+	for {
+		for {
+			if err := parseEvent(rd, &event); err == nil {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				log.Infof("退出服务端可用性事件处理")
+				return
+			case <-time.After(time.Microsecond):
+				continue
+			}
+		}
+
+		server := convertInt8ToString(event.Addr[:])
+		typ := serverEventName(event.Type)
+		pod := sanitizeString(convertInt8ToString(event.Pod[:]))
+
+		// 关闭传输层统计时 dev_xprt 不会被定期同步
+		if event.Type != serverEventMinorTimeout {
+			syncDevXprt(devMap)
+		}
+		mounts := affectedMounts(event.Xprt, server)
+		hosts := mountHosts(mounts)
+		if len(hosts) == 0 {
+			hosts = []string{server}
+		}
+		outagePods, since := ServerHealth.Observe(event, hosts, pod, time.Now())
+
+		for _, host := range hosts {
+			switch event.Type {
+			case serverEventMinorTimeout:
+				serverTimeoutsTotal.WithLabelValues("minor", nodeName, host).Add(float64(event.Count))
+			case serverEventNotResponding, serverEventMajorTimeout, serverEventOK:
+				// 恢复事件中为恢复前尚未输出的 major timeout 次数
+				serverTimeoutsTotal.WithLabelValues("major", nodeName, host).Add(float64(event.Count))
+			}
+		}
+		if event.Type != serverEventMinorTimeout {
+			ServerHealth.UpdateMetricsFromCache(nodeName)
+		}
+
+		dirs := mountDirs(mounts)
+		file := metadata.NFSFile{RemoteNFSAddr: hosts[0], ServerAddr: server, Pod: pod,
+			Container: sanitizeString(convertInt8ToString(event.Container[:]))}
+		if len(dirs) != 0 {
+			file.MountPath = dirs[0]
+			file.LocalMountDir = dirs[0]
+		}
+
+		fields := map[string]interface{}{
+			"event":     "nfs_server",
+			"type":      typ,
+			"server":    server,
+			"xprt":      fmt.Sprintf("%#x", event.Xprt),
+			"client_id": event.ClientId,
+			"task_id":   event.TaskId,
+			"pid":       event.Pid,
+			"proc":      convertInt8ToString(event.Proc[:]),
+			"count":     event.Count,
+			"mounts":    dirs,
+		}
+		if event.Type != serverEventMinorTimeout {
+			fields["affected_pods"] = mergePods(outagePods, mountPods(dirs))
+			fields["outage_ms"] = time.Duration(event.Duration).Milliseconds()
+			if !since.IsZero() {
+				fields["down_since"] = since.Format(time.RFC3339)
+			}
+		}
+		log.StdoutOrFile(cfg.Output.Type, file, fields)
+
+		select {
+		case <-ctx.Done():
+			log.Infof("退出服务端可用性事件处理")
+			return
+		default:
+		}
+	}
+}
+
+func mergePods(a, b []string) []string {
+	seen := make(map[string]struct{}, len(a)+len(b))
+	pods := make([]string, 0, len(a)+len(b))
+	for _, p := range append(a, b...) {
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		pods = append(pods, p)
+	}
+	sort.Strings(pods)
+	return pods
+}
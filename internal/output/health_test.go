@@ -0,0 +1,106 @@
+package output
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/binary"
+)
+
+func TestRemoteHost(t *testing.T) {
+	tests := map[string]string{
+		"192.168.100.204:/data/nfs": "192.168.100.204",
+		"[fd00::1]:/export":         "fd00::1",
+		"nfs.example.com:/":         "nfs.example.com",
+	}
+
+	for remote, want := range tests {
+		if got := remoteHost(remote); got != want {
+			t.Errorf("remoteHost(%q) = %q, want %q", remote, got, want)
+		}
+	}
+}
+
+func TestServerHealthObserve(t *testing.T) {
+	tracker := NewServerHealthTracker()
+	now := time.Unix(1000, 0)
+
+	event := binary.NFSTraceServerEvent{Xprt: 1, Type: serverEventNotResponding}
+	copy(event.Addr[:], []int8{'1', '0', '.', '0', '.', '0', '.', '1'})
+
+	tracker.Observe(event, []string{"nfs.example.com"}, "pod-a", now)
+	if !tracker.Down("nfs.example.com") {
+		t.Fatal("server should be down after not_responding")
+	}
+
+	event.Type = serverEventMajorTimeout
+	tracker.Observe(event, nil, "pod-b", now.Add(time.Minute))
+
+	event.Type = serverEventOK
+	pods, since := tracker.Observe(event, nil, "", now.Add(2*time.Minute))
+	if tracker.Down("nfs.example.com") {
+		t.Error("server should be up after server_ok")
+	}
+	if len(pods) != 2 || pods[0] != "pod-a" || pods[1] != "pod-b" {
+		t.Errorf("pods = %v, want [pod-a pod-b]", pods)
+	}
+	if !since.Equal(now) {
+		t.Errorf("since = %v, want %v", since, now)
+	}
+}
+
+func TestServerHealthStates(t *testing.T) {
+	tracker := NewServerHealthTracker()
+
+	// 按主机名挂载时，无响应的服务端使用 mountinfo 中的主机名，不会产生以 IP 为标签的序列
+	event := binary.NFSTraceServerEvent{Xprt: 1, Type: serverEventNotResponding}
+	copy(event.Addr[:], []int8{'1', '0', '.', '0', '.', '0', '.', '1'})
+	tracker.Observe(event, []string{"nfs.example.com"}, "", time.Unix(1000, 0))
+
+	states := tracker.states([]string{"nfs.example.com", "10.0.0.2"})
+	if len(states) != 2 || states["nfs.example.com"] || !states["10.0.0.2"] {
+		t.Fatalf("states = %v, want nfs.example.com down and 10.0.0.2 up", states)
+	}
+
+	// 没有对应挂载点时使用传输层地址
+	event.Xprt = 2
+	copy(event.Addr[:], []int8{'1', '0', '.', '0', '.', '0', '.', '3'})
+	tracker.Observe(event, nil, "", time.Unix(1000, 0))
+	if states := tracker.states(nil); states["10.0.0.3"] {
+		t.Errorf("states = %v, want 10.0.0.3 down", states)
+	}
+
+	event.Type = serverEventOK
+	tracker.Observe(event, nil, "", time.Unix(1100, 0))
+	if _, ok := tracker.states(nil)["10.0.0.3"]; ok {
+		t.Error("recovered server without mounts should not be exported")
+	}
+}
+
+func TestServerHealthExpire(t *testing.T) {
+	tracker := NewServerHealthTracker()
+
+	event := binary.NFSTraceServerEvent{Xprt: 1, Type: serverEventNotResponding}
+	copy(event.Addr[:], []int8{'1', '0', '.', '0', '.', '0', '.', '1'})
+	tracker.Observe(event, []string{"nfs.example.com"}, "", time.Unix(1000, 0))
+
+	// 传输层仍在 dev_xprt 中时保留
+	tracker.expire(map[uint64]struct{}{1: {}}, nil)
+	if !tracker.Down("nfs.example.com") {
+		t.Fatal("outage of a live transport should be kept")
+	}
+
+	// 服务端仍挂载时保留
+	tracker.expire(nil, []string{"nfs.example.com"})
+	if !tracker.Down("nfs.example.com") {
+		t.Fatal("outage of a mounted server should be kept")
+	}
+
+	tracker.expire(nil, nil)
+	if tracker.Down("nfs.example.com") {
+		t.Error("outage of a released transport should expire")
+	}
+	if _, ok := tracker.states(nil)["nfs.example.com"]; ok {
+		t.Error("expired server without mounts should not be exported")
+	}
+}
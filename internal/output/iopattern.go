@@ -0,0 +1,339 @@
+package output
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/binary"
+	"github.com/cen-ngc5139/nfs-trace/internal/cache"
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
+	"github.com/cilium/ebpf"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const NFSIOSizeBytes = "nfs_io_size_bytes"
+
+// 与 bpf/trace.c 中 enum io_flag 保持一致
+const (
+	IOFlagAsync  uint8 = 1
+	IOFlagDirect uint8 = 2
+	IOFlagStable uint8 = 4
+)
+
+// 与 bpf/trace.c 中 enum io_phase 保持一致
+const (
+	IOPhaseDone uint8 = iota
+	IOPhaseSubmit
+)
+
+const (
+	PatternSequential = "sequential"
+	PatternStrided    = "strided"
+	PatternRandom     = "random"
+	// PatternUnknown 没有发起记录的请求，如 agent 启动前发起的请求
+	PatternUnknown = "unknown"
+)
+
+// maxPatternFiles 访问模式统计最多跟踪的文件数，超过时随机淘汰
+const maxPatternFiles = 65536
+
+// maxPendingRequests 等待完成的请求数上限，超过时随机淘汰
+const maxPendingRequests = 65536
+
+var ioSizeBytes = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name: NFSIOSizeBytes,
+		Help: "NFS read/write request size in bytes by access pattern",
+		// 512B - 4MiB
+		Buckets: prometheus.ExponentialBuckets(512, 2, 14),
+	},
+	[]string{"op", "pattern", "mode", "node_name", "nfs_server", "mount_path", "nfs_pod", "nfs_container"},
+)
+
+// ioMode 请求的同步方式，direct IO 优先
+func ioMode(flags uint8) string {
+	switch {
+	case flags&IOFlagDirect != 0:
+		return "direct"
+	case flags&IOFlagAsync != 0:
+		return "async"
+	}
+	return "sync"
+}
+
+// accessState 文件上一次请求的位置
+type accessState struct {
+	offset uint64
+	end    uint64
+	stride int64
+	seen   bool
+}
+
+// classify 与上一次请求比较：紧接上次结束位置为顺序访问，
+// 与上次的偏移间隔和再上一次相同为跨步访问，其余为随机访问
+func (s *accessState) classify(offset, size uint64) string {
+	pattern := PatternRandom
+	stride := int64(offset - s.offset)
+	switch {
+	case !s.seen:
+		if offset == 0 {
+			pattern = PatternSequential
+		}
+	case offset == s.end:
+		pattern = PatternSequential
+	case stride != 0 && stride == s.stride:
+		pattern = PatternStrided
+	}
+
+	if s.seen {
+		s.stride = stride
+	}
+	s.offset = offset
+	s.end = offset + size
+	s.seen = true
+
+	return pattern
+}
+
+// FilePattern 文件的访问模式统计
+type FilePattern struct {
+	Key        binary.NFSTraceFileKey `json:"-"`
+	DevID      uint64                 `json:"dev_id"`
+	FileID     uint64                 `json:"file_id"`
+	FilePath   string                 `json:"file_path"`
+	MountPath  string                 `json:"mount_path"`
+	Pod        string                 `json:"pod"`
+	Container  string                 `json:"container"`
+	Requests   uint64                 `json:"requests"`
+	Bytes      uint64                 `json:"bytes"`
+	AvgSize    float64                `json:"avg_size"`
+	Sequential uint64                 `json:"sequential"`
+	Strided    uint64                 `json:"strided"`
+	Random     uint64                 `json:"random"`
+	Pattern    string                 `json:"pattern"`
+}
+
+type filePatternState struct {
+	// 读写分别判断
+	read, write accessState
+	pattern     FilePattern
+}
+
+// pendingRequest 已发起、尚未完成的请求
+type pendingRequest struct {
+	key    binary.NFSTraceFileKey
+	op     uint8
+	offset uint64
+}
+
+// AccessPatternTracker 根据每个请求发起时的偏移和大小统计文件的访问模式，
+// 同一文件上的并发请求可能乱序完成，按完成顺序判断会把顺序访问计为随机访问
+type AccessPatternTracker struct {
+	mu    sync.Mutex
+	files map[binary.NFSTraceFileKey]*filePatternState
+	// pending 发起时判断的访问模式，完成时取出用于请求大小分布
+	pending map[pendingRequest]string
+}
+
+// AccessPatterns 全局的文件访问模式统计
+var AccessPatterns = NewAccessPatternTracker()
+
+func NewAccessPatternTracker() *AccessPatternTracker {
+	return &AccessPatternTracker{
+		files:   make(map[binary.NFSTraceFileKey]*filePatternState),
+		pending: make(map[pendingRequest]string),
+	}
+}
+
+func (t *AccessPatternTracker) fileState(key binary.NFSTraceFileKey) *filePatternState {
+	state, ok := t.files[key]
+	if !ok {
+		if len(t.files) >= maxPatternFiles {
+			for key := range t.files {
+				delete(t.files, key)
+				break
+			}
+		}
+		state = &filePatternState{}
+		t.files[key] = state
+	}
+	return state
+}
+
+// Observe 记录一个请求事件：发起时按偏移和大小判断访问模式，完成时累加字节数，
+// 返回该请求的访问模式，完成的请求没有发起记录时返回 PatternUnknown
+func (t *AccessPatternTracker) Observe(event binary.NFSTraceIoEvent) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state := t.fileState(event.Key)
+	req := pendingRequest{key: event.Key, op: event.Op, offset: event.Offset}
+
+	if event.Phase != IOPhaseSubmit {
+		state.pattern.Bytes += uint64(event.ResCount)
+
+		pattern, ok := t.pending[req]
+		if !ok {
+			return PatternUnknown
+		}
+		delete(t.pending, req)
+		return pattern
+	}
+
+	access := &state.read
+	if event.Op == RPCErrorOpWrite {
+		access = &state.write
+	}
+	pattern := access.classify(event.Offset, uint64(event.ReqCount))
+
+	p := &state.pattern
+	p.Requests++
+	switch pattern {
+	case PatternSequential:
+		p.Sequential++
+	case PatternStrided:
+		p.Strided++
+	default:
+		p.Random++
+	}
+
+	if len(t.pending) >= maxPendingRequests {
+		for key := range t.pending {
+			delete(t.pending, key)
+			break
+		}
+	}
+	t.pending[req] = pattern
+
+	return pattern
+}
+
+// Top 返回请求数最多的 k 个文件，k <= 0 时返回全部
+func (t *AccessPatternTracker) Top(k int) []FilePattern {
+	t.mu.Lock()
+	files := make([]FilePattern, 0, len(t.files))
+	for key, state := range t.files {
+		p := state.pattern
+		p.Key = key
+		files = append(files, p)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Requests > files[j].Requests })
+	if k > 0 && len(files) > k {
+		files = files[:k]
+	}
+
+	for i := range files {
+		f := &files[i]
+		f.DevID = f.Key.DevId
+		f.FileID = f.Key.FileId
+		f.Pattern = dominantPattern(*f)
+		if f.Requests > 0 {
+			f.AvgSize = float64(f.Bytes) / float64(f.Requests)
+		}
+		fillPatternFileInfo(f)
+	}
+
+	return files
+}
+
+// dominantPattern 返回占比最高的访问模式
+func dominantPattern(p FilePattern) string {
+	switch {
+	case p.Sequential >= p.Strided && p.Sequential >= p.Random:
+		return PatternSequential
+	case p.Strided >= p.Random:
+		return PatternStrided
+	}
+	return PatternRandom
+}
+
+func fillPatternFileInfo(f *FilePattern) {
+	if v, ok := cache.NFSDevIDFileIDFileInfoMap.Load(f.Key); ok {
+		file := v.(metadata.NFSFile)
+		f.MountPath = file.MountPath
+		f.Pod = file.Pod
+		f.Container = file.Container
+	}
+
+	if v, ok := cache.NFSFileDetailMap.Load(f.Key); ok {
+		f.FilePath = v.(metadata.FilePath).Path
+	}
+}
+
+// Handler 返回文件访问模式查询接口，参数: k
+func (t *AccessPatternTracker) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		k, _ := strconv.Atoi(c.DefaultQuery("k", "100"))
+		c.JSON(http.StatusOK, t.Top(k))
+	}
+}
+
+// observeIOSize 在请求完成时按发起时判断的访问模式统计请求大小
+func observeIOSize(event binary.NFSTraceIoEvent, pattern, nodeName string) {
+	var file metadata.NFSFile
+	if fileInfo, ok := cache.NFSDevIDFileIDFileInfoMap.Load(event.Key); ok {
+		file = fileInfo.(metadata.NFSFile)
+	}
+	if pod := sanitizeString(convertInt8ToString(event.Pod[:])); pod != "" {
+		file.Pod = pod
+		file.Container = sanitizeString(convertInt8ToString(event.Container[:]))
+	}
+
+	ioSizeBytes.WithLabelValues(rpcErrorOpName(event.Op, ""), pattern, ioMode(event.Flags), nodeName,
+		file.RemoteNFSAddr, file.MountPath, file.Pod, file.Container).Observe(float64(event.ResCount))
+}
+
+func ProcessIOEvents(coll *ebpf.Collection, ctx context.Context, cfg config.Configuration) {
+	events := coll.Maps["io_events"]
+	// Set up a ringbuf or perf reader to read events from the eBPF program
+	rd, err := newEventReader(events, cfg)
+	if err != nil {
+		log.Fatalf("Creating event reader failed: %v\n", err)
+	}
+	defer rd.Close()
+
+	nodeName, err := os.Hostname()
+	if err != nil {
+		nodeName = "default_node"
+	}
+
+	var event binary.NFSTraceIoEvent
+	for {
+		for {
+			if err := parseEvent(rd, &event); err == nil {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				log.Infof("退出读写请求处理")
+				return
+			case <-time.After(time.Microsecond):
+				continue
+			}
+		}
+
+		pattern := AccessPatterns.Observe(event)
+		if event.Phase != IOPhaseSubmit {
+			observeIOSize(event, pattern, nodeName)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Infof("退出读写请求处理")
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package output
+
+import (
+	"testing"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/binary"
+)
+
+func TestAccessStateClassify(t *testing.T) {
+	type request struct {
+		offset, size uint64
+	}
+	tests := []struct {
+		name     string
+		requests []request
+		want     []string
+	}{
+		{
+			name:     "sequential",
+			requests: []request{{0, 4096}, {4096, 4096}, {8192, 4096}},
+			want:     []string{PatternSequential, PatternSequential, PatternSequential},
+		},
+		{
+			name:     "strided",
+			requests: []request{{0, 4096}, {65536, 4096}, {131072, 4096}, {196608, 4096}},
+			want:     []string{PatternSequential, PatternRandom, PatternStrided, PatternStrided},
+		},
+		{
+			name:     "random",
+			requests: []request{{8192, 4096}, {1 << 20, 4096}, {4096, 4096}},
+			want:     []string{PatternRandom, PatternRandom, PatternRandom},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s accessState
+			for i, r := range tt.requests {
+				if got := s.classify(r.offset, r.size); got != tt.want[i] {
+					t.Errorf("request %d: classify() = %s, want %s", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAccessPatternTrackerOrder(t *testing.T) {
+	tracker := NewAccessPatternTracker()
+	key := binary.NFSTraceFileKey{DevId: 1, FileId: 2}
+	event := func(phase uint8, offset uint64) binary.NFSTraceIoEvent {
+		return binary.NFSTraceIoEvent{Key: key, Offset: offset, ReqCount: 4096, ResCount: 4096,
+			Op: RPCErrorOpRead, Phase: phase}
+	}
+
+	// 顺序发起的请求乱序完成，仍按发起顺序判断为顺序访问
+	for _, offset := range []uint64{0, 4096, 8192} {
+		if got := tracker.Observe(event(IOPhaseSubmit, offset)); got != PatternSequential {
+			t.Errorf("submit %d: pattern = %s, want %s", offset, got, PatternSequential)
+		}
+	}
+	for _, offset := range []uint64{8192, 0, 4096} {
+		if got := tracker.Observe(event(IOPhaseDone, offset)); got != PatternSequential {
+			t.Errorf("done %d: pattern = %s, want %s", offset, got, PatternSequential)
+		}
+	}
+
+	if got := tracker.Observe(event(IOPhaseDone, 1<<20)); got != PatternUnknown {
+		t.Errorf("done without submit: pattern = %s, want %s", got, PatternUnknown)
+	}
+
+	files := tracker.Top(0)
+	if len(files) != 1 || files[0].Requests != 3 || files[0].Sequential != 3 || files[0].Bytes != 4*4096 {
+		t.Fatalf("files = %+v, want 3 sequential requests", files)
+	}
+}
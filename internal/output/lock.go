@@ -0,0 +1,450 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/binary"
+	"github.com/cen-ngc5139/nfs-trace/internal/cache"
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
+	"github.com/cilium/ebpf"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const NFSLockWaitDuration = "nfs_lock_wait_seconds"
+
+const (
+	LockResultGranted     = "granted"
+	LockResultDenied      = "denied"
+	LockResultInterrupted = "interrupted"
+	LockResultDeadlock    = "deadlock"
+	LockResultError       = "error"
+
+	// maxLockFiles 锁表中最多记录的文件数，超过后丢弃新文件的锁
+	maxLockFiles = 10000
+	// erestartsys 内核中被信号中断的返回码，不会返回给用户态
+	erestartsys = 512
+)
+
+// 与 bpf/trace.c 中 enum lock_kind、enum lock_op、enum lock_phase 保持一致
+var (
+	lockKindNames = []string{"posix", "ofd", "flock"}
+	lockOpNames   = []string{"lock", "unlock", "test"}
+)
+
+const (
+	LockOpLock uint8 = iota
+	LockOpUnlock
+	LockOpTest
+)
+
+const (
+	LockPhaseWait uint8 = iota
+	LockPhaseDone
+)
+
+// 与内核 F_RDLCK、F_WRLCK、F_UNLCK 保持一致
+var lockTypeNames = []string{"read", "write", "unlock"}
+
+const lockTypeUnlock uint8 = 2
+
+var lockWaitDuration = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name: NFSLockWaitDuration,
+		Help: "NFS file lock acquisition time in seconds, including the wait for conflicting holders",
+		// 100us - 7min
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 12),
+	},
+	[]string{"kind", "type", "result", "node_name", "nfs_server", "mount_path", "nfs_pod"},
+)
+
+// lockOwnerSeed 每次运行随机生成，属主只导出哈希值，不暴露内核 fl_owner 指针
+var lockOwnerSeed = maphash.MakeSeed()
+
+func lockOwnerID(owner uint64) string {
+	return fmt.Sprintf("%016x", maphash.String(lockOwnerSeed, strconv.FormatUint(owner, 16)))
+}
+
+func lockName(names []string, v uint8) string {
+	if int(v) < len(names) {
+		return names[v]
+	}
+	return "unknown"
+}
+
+// lockResult 根据加锁请求的返回码分类，非阻塞请求冲突时返回 EAGAIN 或 EACCES
+func lockResult(status int32) string {
+	switch syscall.Errno(-status) {
+	case 0:
+		return LockResultGranted
+	case syscall.EAGAIN, syscall.EACCES:
+		return LockResultDenied
+	case syscall.EINTR, erestartsys:
+		return LockResultInterrupted
+	case syscall.EDEADLK:
+		return LockResultDeadlock
+	}
+	return LockResultError
+}
+
+// LockEntry 锁的持有者或等待者，End 为 -1 表示锁到文件末尾
+type LockEntry struct {
+	Kind      string    `json:"kind"`
+	Type      string    `json:"type"`
+	Start     int64     `json:"start"`
+	End       int64     `json:"end"`
+	Owner     string    `json:"owner"`
+	Pid       int32     `json:"pid"`
+	Comm      string    `json:"comm"`
+	Pod       string    `json:"pod,omitempty"`
+	Container string    `json:"container,omitempty"`
+	Since     time.Time `json:"since"`
+
+	owner uint64
+}
+
+// FileLocks 文件当前的持有者和等待者，只包含本节点上的进程
+type FileLocks struct {
+	DevID     uint64      `json:"dev_id"`
+	FileID    uint64      `json:"file_id"`
+	FilePath  string      `json:"file_path"`
+	MountPath string      `json:"mount_path"`
+	Holders   []LockEntry `json:"holders"`
+	Waiters   []LockEntry `json:"waiters"`
+}
+
+type fileLockState struct {
+	holders []LockEntry
+	waiters []LockEntry
+}
+
+// LockTable 根据加锁、解锁事件维护各文件当前的锁，关闭文件或进程退出时内核同样通过 nfs_lock/nfs_flock 解锁
+type LockTable struct {
+	mu    sync.Mutex
+	files map[binary.NFSTraceFileKey]*fileLockState
+}
+
+// Locks 全局的文件锁表
+var Locks = NewLockTable()
+
+func NewLockTable() *LockTable {
+	return &LockTable{files: make(map[binary.NFSTraceFileKey]*fileLockState)}
+}
+
+// lockRangeEnd 内核中锁到文件末尾时 fl_end 为 OFFSET_MAX，转换为 -1
+func lockRangeEnd(end int64) int64 {
+	if end == math.MaxInt64 {
+		return -1
+	}
+	return end
+}
+
+func newLockEntry(event binary.NFSTraceLockEvent, now time.Time) LockEntry {
+	return LockEntry{
+		Kind:      lockName(lockKindNames, event.Kind),
+		Type:      lockName(lockTypeNames, event.Type),
+		Start:     event.Start,
+		End:       lockRangeEnd(event.End),
+		Owner:     lockOwnerID(event.Owner),
+		Pid:       event.Pid,
+		Comm:      convertInt8ToString(event.Comm[:]),
+		Pod:       sanitizeString(convertInt8ToString(event.Pod[:])),
+		Container: sanitizeString(convertInt8ToString(event.Container[:])),
+		Since:     now,
+		owner:     event.Owner,
+	}
+}
+
+// lockEnd 返回范围的结束位置，-1 表示到文件末尾
+func lockEnd(e LockEntry) int64 {
+	if e.End < 0 {
+		return math.MaxInt64
+	}
+	return e.End
+}
+
+func lockOverlaps(a, b LockEntry) bool {
+	if a.Kind == "flock" || b.Kind == "flock" {
+		return true
+	}
+	return a.Start <= lockEnd(b) && b.Start <= lockEnd(a)
+}
+
+// lockConflicts 不同属主的锁范围重叠，且至少一个为写锁时冲突。flock 与 fcntl 锁互不影响
+func lockConflicts(a, b LockEntry) bool {
+	if a.owner == b.owner || (a.Kind == "flock") != (b.Kind == "flock") {
+		return false
+	}
+	return (a.Type == "write" || b.Type == "write") && lockOverlaps(a, b)
+}
+
+// releaseRange 释放属主在 r 范围内的锁，部分重叠的锁保留范围外的部分
+func releaseRange(holders []LockEntry, r LockEntry) []LockEntry {
+	kept := holders[:0:0]
+	for _, h := range holders {
+		if h.owner != r.owner || h.Kind != r.Kind || !lockOverlaps(h, r) {
+			kept = append(kept, h)
+			continue
+		}
+		if r.Kind == "flock" {
+			continue
+		}
+
+		if h.Start < r.Start {
+			left := h
+			left.End = r.Start - 1
+			kept = append(kept, left)
+		}
+		if rEnd := lockEnd(r); rEnd < lockEnd(h) {
+			right := h
+			right.Start = rEnd + 1
+			kept = append(kept, right)
+		}
+	}
+	return kept
+}
+
+func removeWaiter(waiters []LockEntry, e LockEntry) []LockEntry {
+	for i, w := range waiters {
+		if w.owner == e.owner && w.Pid == e.Pid && w.Kind == e.Kind {
+			return append(waiters[:i:i], waiters[i+1:]...)
+		}
+	}
+	return waiters
+}
+
+// Update 根据事件更新锁表，返回与请求冲突的本节点持有者
+func (t *LockTable) Update(event binary.NFSTraceLockEvent) []LockEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry := newLockEntry(event, time.Now())
+	state, ok := t.files[event.Key]
+	if !ok {
+		if event.Op == LockOpUnlock || len(t.files) >= maxLockFiles {
+			return nil
+		}
+		state = &fileLockState{}
+		t.files[event.Key] = state
+	}
+
+	// F_GETLK 返回后事件中为冲突的锁，只检查加锁请求
+	var conflicts []LockEntry
+	if event.Op == LockOpLock {
+		for _, h := range state.holders {
+			if lockConflicts(h, entry) {
+				conflicts = append(conflicts, h)
+			}
+		}
+	}
+
+	switch {
+	case event.Phase == LockPhaseWait:
+		state.waiters = append(removeWaiter(state.waiters, entry), entry)
+	case event.Op == LockOpLock:
+		state.waiters = removeWaiter(state.waiters, entry)
+		if event.Status == 0 {
+			// 同一属主重复加锁时替换范围内原有的锁，如读锁升级为写锁
+			state.holders = append(releaseRange(state.holders, entry), entry)
+		}
+	case event.Op == LockOpUnlock && event.Status == 0:
+		state.holders = releaseRange(state.holders, entry)
+	}
+
+	if len(state.holders) == 0 && len(state.waiters) == 0 {
+		delete(t.files, event.Key)
+	}
+
+	return conflicts
+}
+
+// Snapshot 返回当前的锁，path 不为空时只返回路径包含 path 的文件
+func (t *LockTable) Snapshot(path string) []FileLocks {
+	t.mu.Lock()
+	files := make([]FileLocks, 0, len(t.files))
+	for key, state := range t.files {
+		files = append(files, FileLocks{
+			DevID:   key.DevId,
+			FileID:  key.FileId,
+			Holders: append([]LockEntry{}, state.holders...),
+			Waiters: append([]LockEntry{}, state.waiters...),
+		})
+	}
+	t.mu.Unlock()
+
+	filtered := files[:0]
+	for _, f := range files {
+		key := binary.NFSTraceFileKey{DevId: f.DevID, FileId: f.FileID}
+		if v, ok := cache.NFSFileDetailMap.Load(key); ok {
+			f.FilePath = v.(metadata.FilePath).Path
+		}
+		if mount, ok := metadata.GetMountInfoByDev(uint32(f.DevID)); ok {
+			f.MountPath = mount.LocalMountDir
+		}
+		if path != "" && !strings.Contains(f.FilePath, path) {
+			continue
+		}
+		filtered = append(filtered, f)
+	}
+
+	// 等待者多的文件排在前面
+	sort.Slice(filtered, func(i, j int) bool {
+		if len(filtered[i].Waiters) != len(filtered[j].Waiters) {
+			return len(filtered[i].Waiters) > len(filtered[j].Waiters)
+		}
+		return filtered[i].FilePath < filtered[j].FilePath
+	})
+	return filtered
+}
+
+// Handler 返回当前锁的查询接口，参数: path
+func (t *LockTable) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, t.Snapshot(c.Query("path")))
+	}
+}
+
+func lockFile(event binary.NFSTraceLockEvent) metadata.NFSFile {
+	var file metadata.NFSFile
+	if fileInfo, ok := cache.NFSDevIDFileIDFileInfoMap.Load(event.Key); ok {
+		file = fileInfo.(metadata.NFSFile)
+	}
+	if filePath, ok := cache.NFSFileDetailMap.Load(event.Key); ok {
+		file.FilePath = filePath.(metadata.FilePath).Path
+		file.PathTruncated = filePath.(metadata.FilePath).Truncated
+	}
+	if mount, ok := metadata.GetMountInfoByDev(uint32(event.Key.DevId)); ok {
+		file.MountPath = mount.LocalMountDir
+		file.LocalMountDir = mount.LocalMountDir
+		file.RemoteNFSAddr = mount.RemoteNFSAddr
+	}
+
+	if pod := sanitizeString(convertInt8ToString(event.Pod[:])); pod != "" {
+		file.Pod = pod
+		file.Container = sanitizeString(convertInt8ToString(event.Container[:]))
+	}
+
+	return file
+}
+
+func lockHolderNames(holders []LockEntry) []string {
+	names := make([]string, 0, len(holders))
+	for _, h := range holders {
+		name := fmt.Sprintf("%d/%s", h.Pid, h.Comm)
+		if h.Pod != "" {
+			name += "@" + h.Pod
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func ProcessLockEvents(coll *ebpf.Collection, ctx context.Context, cfg config.Configuration) {
+	events := coll.Maps["lock_events"]
+	// Set up a ringbuf or perf reader to read events from the eBPF program
+	rd, err := newEventReader(events, cfg)
+	if err != nil {
+		log.Fatalf("Creating event reader failed: %v\n", err)
+	}
+	defer rd.Close()
+
+	nodeName, err := os.Hostname()
+	if err != nil {
+		nodeName = "default_node"
+	}
+
+	var event binary.NFSTraceLockEvent
+	for {
+		for {
+			if err := parseEvent(rd, &event); err == nil {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				log.Infof("退出文件锁事件处理")
+				return
+			case <-time.After(time.Microsecond):
+				continue
+			}
+		}
+
+		conflicts := Locks.Update(event)
+		file := lockFile(event)
+		kind := lockName(lockKindNames, event.Kind)
+		op := lockName(lockOpNames, event.Op)
+		typ := lockName(lockTypeNames, event.Type)
+		latency := time.Duration(event.Latency)
+
+		var result string
+		emit := false
+		switch {
+		case event.Phase == LockPhaseWait:
+			result = "waiting"
+			emit = true
+		case event.Op == LockOpLock:
+			result = lockResult(event.Status)
+			lockWaitDuration.WithLabelValues(kind, typ, result, nodeName, file.RemoteNFSAddr, file.MountPath,
+				file.Pod).Observe(latency.Seconds())
+			emit = event.Status != 0 || latency >= cfg.Lock.WaitThreshold
+		case event.Op == LockOpTest:
+			// F_GETLK 返回冲突锁的类型，没有冲突时为 unlock
+			result = "free"
+			if event.Type != lockTypeUnlock {
+				result = "conflict"
+			}
+			emit = cfg.Lock.WaitThreshold == 0 || event.Status != 0
+		default:
+			result = StatusName(event.Status)
+			emit = cfg.Lock.WaitThreshold == 0 || event.Status != 0
+		}
+
+		if emit {
+			devID, fileID := GetDevIDFileID(event.Key)
+			fields := map[string]interface{}{
+				"event":      "nfs_lock",
+				"kind":       kind,
+				"op":         op,
+				"type":       typ,
+				"result":     result,
+				"errno":      event.Status,
+				"blocking":   event.Blocking != 0,
+				"start":      event.Start,
+				"end":        lockRangeEnd(event.End),
+				"latency_us": latency.Microseconds(),
+				"pid":        event.Pid,
+				"comm":       convertInt8ToString(event.Comm[:]),
+				"dev_id":     devID,
+				"file_id":    fileID,
+			}
+			if event.ConflictPid != 0 {
+				fields["conflict_pid"] = event.ConflictPid
+			}
+			if len(conflicts) != 0 {
+				fields["blocked_by"] = lockHolderNames(conflicts)
+			}
+			log.StdoutOrFile(cfg.Output.Type, file, fields)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Infof("退出文件锁事件处理")
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package output
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/binary"
+)
+
+func lockEvent(op, phase, typ uint8, owner uint64, start, end int64, status int32) binary.NFSTraceLockEvent {
+	return binary.NFSTraceLockEvent{
+		Key:    binary.NFSTraceFileKey{DevId: 1, FileId: 2},
+		Owner:  owner,
+		Start:  start,
+		End:    end,
+		Status: status,
+		Op:     op,
+		Type:   typ,
+		Phase:  phase,
+	}
+}
+
+func TestLockTableUpdate(t *testing.T) {
+	table := NewLockTable()
+
+	// 属主 1 持有 [0, 99] 的写锁
+	table.Update(lockEvent(LockOpLock, LockPhaseDone, 1, 1, 0, 99, 0))
+
+	// 属主 2 等待 [50, EOF] 的读锁，与属主 1 冲突
+	conflicts := table.Update(lockEvent(LockOpLock, LockPhaseWait, 0, 2, 50, math.MaxInt64, 0))
+	if len(conflicts) != 1 || conflicts[0].owner != 1 {
+		t.Fatalf("conflicts = %+v, want owner 1", conflicts)
+	}
+
+	files := table.Snapshot("")
+	if len(files) != 1 || len(files[0].Holders) != 1 || len(files[0].Waiters) != 1 {
+		t.Fatalf("snapshot = %+v, want 1 holder and 1 waiter", files)
+	}
+	if files[0].Waiters[0].End != -1 {
+		t.Errorf("waiter end = %d, want -1", files[0].Waiters[0].End)
+	}
+
+	// 属主 1 解锁 [0, 49]，保留 [50, 99]
+	table.Update(lockEvent(LockOpUnlock, LockPhaseDone, 2, 1, 0, 49, 0))
+	files = table.Snapshot("")
+	if h := files[0].Holders; len(h) != 1 || h[0].Start != 50 || h[0].End != 99 {
+		t.Fatalf("holders = %+v, want [50, 99]", h)
+	}
+
+	// 属主 1 解锁全部后属主 2 获得锁
+	table.Update(lockEvent(LockOpUnlock, LockPhaseDone, 2, 1, 0, math.MaxInt64, 0))
+	conflicts = table.Update(lockEvent(LockOpLock, LockPhaseDone, 0, 2, 50, math.MaxInt64, 0))
+	if len(conflicts) != 0 {
+		t.Errorf("conflicts = %+v, want none", conflicts)
+	}
+	files = table.Snapshot("")
+	if len(files) != 1 || len(files[0].Holders) != 1 || len(files[0].Waiters) != 0 || files[0].Holders[0].owner != 2 {
+		t.Fatalf("snapshot = %+v, want owner 2 holding", files)
+	}
+
+	table.Update(lockEvent(LockOpUnlock, LockPhaseDone, 2, 2, 0, math.MaxInt64, 0))
+	if files := table.Snapshot(""); len(files) != 0 {
+		t.Errorf("snapshot = %+v, want empty", files)
+	}
+}
+
+func TestLockConflicts(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b LockEntry
+		want bool
+	}{
+		{
+			name: "read read",
+			a:    LockEntry{Kind: "posix", Type: "read", Start: 0, End: -1, owner: 1},
+			b:    LockEntry{Kind: "posix", Type: "read", Start: 0, End: -1, owner: 2},
+			want: false,
+		},
+		{
+			name: "write disjoint",
+			a:    LockEntry{Kind: "posix", Type: "write", Start: 0, End: 9, owner: 1},
+			b:    LockEntry{Kind: "ofd", Type: "write", Start: 10, End: 19, owner: 2},
+			want: false,
+		},
+		{
+			name: "posix ofd overlap",
+			a:    LockEntry{Kind: "posix", Type: "write", Start: 0, End: 9, owner: 1},
+			b:    LockEntry{Kind: "ofd", Type: "read", Start: 5, End: -1, owner: 2},
+			want: true,
+		},
+		{
+			name: "flock posix",
+			a:    LockEntry{Kind: "flock", Type: "write", owner: 1},
+			b:    LockEntry{Kind: "posix", Type: "write", End: -1, owner: 2},
+			want: false,
+		},
+		{
+			name: "same owner",
+			a:    LockEntry{Kind: "flock", Type: "write", owner: 1},
+			b:    LockEntry{Kind: "flock", Type: "write", owner: 1},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lockConflicts(tt.a, tt.b); got != tt.want {
+				t.Errorf("lockConflicts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLockResult(t *testing.T) {
+	tests := map[int32]string{
+		0:    LockResultGranted,
+		-11:  LockResultDenied,
+		-13:  LockResultDenied,
+		-4:   LockResultInterrupted,
+		-512: LockResultInterrupted,
+		-35:  LockResultDeadlock,
+		-37:  LockResultError,
+	}
+
+	for status, want := range tests {
+		if got := lockResult(status); got != want {
+			t.Errorf("lockResult(%d) = %s, want %s", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package output
+
+import (
+	"context"
+	"os"
+	"path"
+	"time"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/binary"
+	"github.com/cen-ngc5139/nfs-trace/internal/cache"
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
+	"github.com/cilium/ebpf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const NFSMetaOpDuration = "nfs_meta_op_duration_seconds"
+
+// 与 bpf/trace.c 中 enum meta_op 保持一致
+var metaOpNames = []string{"lookup", "open", "getattr", "setattr", "create", "unlink", "rename"}
+
+var metaOpDuration = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name: NFSMetaOpDuration,
+		Help: "NFS metadata operation latency in seconds",
+		// 100us - 26s
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	},
+	[]string{"op", "status", "node_name", "nfs_server", "mount_path", "nfs_pod", "nfs_container"},
+)
+
+func metaOpName(op uint8) string {
+	if int(op) < len(metaOpNames) {
+		return metaOpNames[op]
+	}
+	return "unknown"
+}
+
+// metaFile 根据父目录的路径和文件名组合出操作的文件，路径相对于 NFS 文件系统的根目录
+func metaFile(event binary.NFSTraceMetaEvent) metadata.NFSFile {
+	var file metadata.NFSFile
+	if mount, ok := metadata.GetMountInfoByDev(uint32(event.Dir.DevId)); ok {
+		file.MountPath = mount.LocalMountDir
+		file.LocalMountDir = mount.LocalMountDir
+		file.RemoteNFSAddr = mount.RemoteNFSAddr
+	}
+
+	name := convertInt8ToString(event.Name[:])
+	file.FilePath = name
+	if v, ok := cache.NFSFileDetailMap.Load(event.Dir); ok {
+		dir := v.(metadata.FilePath)
+		file.FilePath = path.Join(dir.Path, name)
+		file.PathTruncated = dir.Truncated
+	}
+
+	if pod := sanitizeString(convertInt8ToString(event.Pod[:])); pod != "" {
+		file.Pod = pod
+		file.Container = sanitizeString(convertInt8ToString(event.Container[:]))
+	}
+
+	return file
+}
+
+func ProcessMetaEvents(coll *ebpf.Collection, ctx context.Context, cfg config.Configuration) {
+	events := coll.Maps["meta_events"]
+	// Set up a ringbuf or perf reader to read events from the eBPF program
+	rd, err := newEventReader(events, cfg)
+	if err != nil {
+		log.Fatalf("Creating event reader failed: %v\n", err)
+	}
+	defer rd.Close()
+
+	nodeName, err := os.Hostname()
+	if err != nil {
+		nodeName = "default_node"
+	}
+
+	var event binary.NFSTraceMetaEvent
+	for {
+		for {
+			if err := parseEvent(rd, &event); err == nil {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				log.Infof("退出元数据操作处理")
+				return
+			case <-time.After(time.Microsecond):
+				continue
+			}
+		}
+
+		file := metaFile(event)
+		op := metaOpName(event.Op)
+		status := StatusName(event.Status)
+		latency := time.Duration(event.Latency)
+
+		metaOpDuration.WithLabelValues(op, status, nodeName, file.RemoteNFSAddr, file.MountPath,
+			file.Pod, file.Container).Observe(latency.Seconds())
+
+		// 只输出失败或慢的操作，避免 getattr 等高频操作刷屏
+		if event.Status != 0 || latency >= cfg.Meta.SlowThreshold {
+			devID, fileID := GetDevIDFileID(event.Key)
+			log.StdoutOrFile(cfg.Output.Type, file, map[string]interface{}{
+				"event":      "meta_op",
+				"op":         op,
+				"status":     status,
+				"errno":      event.Status,
+				"latency_us": latency.Microseconds(),
+				"pid":        event.Pid,
+				"comm":       convertInt8ToString(event.Comm[:]),
+				"dev_id":     devID,
+				"file_id":    fileID,
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Infof("退出元数据操作处理")
+			return
+		default:
+		}
+	}
+}
@@ -11,19 +11,12 @@ import (
 	"github.com/cilium/ebpf"
 )
 
-// 解析 key 为 dev id 和 file id
-func parseKey(key uint64) (devID uint32, fileID uint32) {
-	devID = uint32(key >> 32)
-	fileID = uint32(key & 0xFFFFFFFF)
-	return
-}
-
 func ProcessMetrics(coll *ebpf.Collection, ctx context.Context) {
 	events := coll.Maps["io_metrics"]
 	var event ebpfbinary.NFSTraceRawMetrics
 
 	for {
-		var nextKey uint64
+		var nextKey ebpfbinary.NFSTraceFileKey
 		var count int
 		iter := events.Iterate()
 		for iter.Next(&nextKey, &event) {
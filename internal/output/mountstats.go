@@ -0,0 +1,229 @@
+package output
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/cache"
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	MountStatsPollInterval = 5 * time.Second
+
+	NFSMountStatsAge          = "nfs_mountstats_age_seconds"
+	NFSMountStatsBytes        = "nfs_mountstats_bytes"
+	NFSMountStatsOps          = "nfs_mountstats_op_ops"
+	NFSMountStatsTrans        = "nfs_mountstats_op_transmissions"
+	NFSMountStatsTimeouts     = "nfs_mountstats_op_timeouts"
+	NFSMountStatsBytesSent    = "nfs_mountstats_op_bytes_sent"
+	NFSMountStatsBytesRecv    = "nfs_mountstats_op_bytes_received"
+	NFSMountStatsQueueMs      = "nfs_mountstats_op_queue_milliseconds"
+	NFSMountStatsRTTMs        = "nfs_mountstats_op_rtt_milliseconds"
+	NFSMountStatsExecuteMs    = "nfs_mountstats_op_execute_milliseconds"
+	NFSMountStatsErrors       = "nfs_mountstats_op_errors"
+	NFSMountStatsXprtConnects = "nfs_mountstats_xprt_connects"
+	NFSMountStatsXprtSends    = "nfs_mountstats_xprt_sends"
+	NFSMountStatsXprtRecvs    = "nfs_mountstats_xprt_receives"
+	NFSMountStatsXprtBadXIDs  = "nfs_mountstats_xprt_bad_xids"
+	NFSMountStatsXprtBklogU   = "nfs_mountstats_xprt_backlog_utilization"
+	NFSMountStatsXprtSendingU = "nfs_mountstats_xprt_sending_utilization"
+	NFSMountStatsXprtPendingU = "nfs_mountstats_xprt_pending_utilization"
+)
+
+var mountStatsLabels = []string{"node_name", "nfs_server", "mount_path", "nfs_version"}
+
+// MountStatsMetrics holds metrics parsed from the host /proc/1/mountstats
+type MountStatsMetrics struct {
+	Age       *prometheus.GaugeVec
+	Bytes     *prometheus.GaugeVec
+	Ops       *prometheus.GaugeVec
+	Trans     *prometheus.GaugeVec
+	Timeouts  *prometheus.GaugeVec
+	BytesSent *prometheus.GaugeVec
+	BytesRecv *prometheus.GaugeVec
+	QueueMs   *prometheus.GaugeVec
+	RTTMs     *prometheus.GaugeVec
+	ExecuteMs *prometheus.GaugeVec
+	Errors    *prometheus.GaugeVec
+	Connects  *prometheus.GaugeVec
+	Sends     *prometheus.GaugeVec
+	Recvs     *prometheus.GaugeVec
+	BadXIDs   *prometheus.GaugeVec
+	BklogU    *prometheus.GaugeVec
+	SendingU  *prometheus.GaugeVec
+	PendingU  *prometheus.GaugeVec
+	statsMap  *sync.Map
+
+	mu sync.Mutex
+	// exported 已导出序列的挂载点标签，挂载点卸载或标签变化时删除旧序列
+	exported map[string]prometheus.Labels
+}
+
+func createMountStatsGaugeVec(name, help string, extra ...string) *prometheus.GaugeVec {
+	labels := append(append([]string{}, mountStatsLabels...), extra...)
+	return promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: name,
+			Help: help,
+		},
+		labels,
+	)
+}
+
+// NewMountStatsMetrics 创建并注册 mountstats 指标
+func NewMountStatsMetrics(statsMap *sync.Map) *MountStatsMetrics {
+	return &MountStatsMetrics{
+		Age:       createMountStatsGaugeVec(NFSMountStatsAge, "NFS mount age"),
+		Bytes:     createMountStatsGaugeVec(NFSMountStatsBytes, "NFS mount bytes by type", "type"),
+		Ops:       createMountStatsGaugeVec(NFSMountStatsOps, "NFS operations", "op"),
+		Trans:     createMountStatsGaugeVec(NFSMountStatsTrans, "NFS RPC transmissions", "op"),
+		Timeouts:  createMountStatsGaugeVec(NFSMountStatsTimeouts, "NFS RPC major timeouts", "op"),
+		BytesSent: createMountStatsGaugeVec(NFSMountStatsBytesSent, "NFS RPC bytes sent", "op"),
+		BytesRecv: createMountStatsGaugeVec(NFSMountStatsBytesRecv, "NFS RPC bytes received", "op"),
+		QueueMs:   createMountStatsGaugeVec(NFSMountStatsQueueMs, "NFS RPC cumulative queue time", "op"),
+		RTTMs:     createMountStatsGaugeVec(NFSMountStatsRTTMs, "NFS RPC cumulative round trip time", "op"),
+		ExecuteMs: createMountStatsGaugeVec(NFSMountStatsExecuteMs, "NFS RPC cumulative execute time", "op"),
+		Errors:    createMountStatsGaugeVec(NFSMountStatsErrors, "NFS RPC errors", "op"),
+		Connects:  createMountStatsGaugeVec(NFSMountStatsXprtConnects, "NFS transport connects", "protocol"),
+		Sends:     createMountStatsGaugeVec(NFSMountStatsXprtSends, "NFS transport sends", "protocol"),
+		Recvs:     createMountStatsGaugeVec(NFSMountStatsXprtRecvs, "NFS transport receives", "protocol"),
+		BadXIDs:   createMountStatsGaugeVec(NFSMountStatsXprtBadXIDs, "NFS transport bad XIDs", "protocol"),
+		BklogU:    createMountStatsGaugeVec(NFSMountStatsXprtBklogU, "NFS transport cumulative backlog queue length", "protocol"),
+		SendingU:  createMountStatsGaugeVec(NFSMountStatsXprtSendingU, "NFS transport cumulative sending queue length", "protocol"),
+		PendingU:  createMountStatsGaugeVec(NFSMountStatsXprtPendingU, "NFS transport cumulative pending queue length", "protocol"),
+		statsMap:  statsMap,
+		exported:  make(map[string]prometheus.Labels),
+	}
+}
+
+func (m *MountStatsMetrics) vecs() []*prometheus.GaugeVec {
+	return []*prometheus.GaugeVec{
+		m.Age, m.Bytes, m.Ops, m.Trans, m.Timeouts, m.BytesSent, m.BytesRecv, m.QueueMs, m.RTTMs,
+		m.ExecuteMs, m.Errors, m.Connects, m.Sends, m.Recvs, m.BadXIDs, m.BklogU, m.SendingU, m.PendingU,
+	}
+}
+
+// deleteSeries 删除挂载点的所有 mountstats 序列
+func (m *MountStatsMetrics) deleteSeries(labels prometheus.Labels) {
+	for _, vec := range m.vecs() {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+// UpdateMetricsFromCache updates the Prometheus metrics from the MountStatsMap
+func (m *MountStatsMetrics) UpdateMetricsFromCache(nodeName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mounted := make(map[string]struct{})
+	m.statsMap.Range(func(key, value interface{}) bool {
+		stats := value.(metadata.MountStats)
+		labels := []string{nodeName, stats.Device, stats.MountPoint, stats.NFSVersion}
+		mounted[stats.MountPoint] = struct{}{}
+
+		current := prometheus.Labels{}
+		for i, name := range mountStatsLabels {
+			current[name] = labels[i]
+		}
+		if old, ok := m.exported[stats.MountPoint]; ok && !reflect.DeepEqual(old, current) {
+			m.deleteSeries(old)
+		}
+		m.exported[stats.MountPoint] = current
+		with := func(extra string) []string {
+			return append(append([]string{}, labels...), extra)
+		}
+
+		m.Age.WithLabelValues(labels...).Set(float64(stats.Age))
+
+		bytes := map[string]uint64{
+			"normal_read":  stats.Bytes.NormalRead,
+			"normal_write": stats.Bytes.NormalWrite,
+			"direct_read":  stats.Bytes.DirectRead,
+			"direct_write": stats.Bytes.DirectWrite,
+			"server_read":  stats.Bytes.ServerRead,
+			"server_write": stats.Bytes.ServerWrite,
+		}
+		for typ, v := range bytes {
+			m.Bytes.WithLabelValues(with(typ)...).Set(float64(v))
+		}
+
+		for _, op := range stats.Ops {
+			if op.Ops == 0 {
+				continue
+			}
+
+			m.Ops.WithLabelValues(with(op.Op)...).Set(float64(op.Ops))
+			m.Trans.WithLabelValues(with(op.Op)...).Set(float64(op.Trans))
+			m.Timeouts.WithLabelValues(with(op.Op)...).Set(float64(op.Timeouts))
+			m.BytesSent.WithLabelValues(with(op.Op)...).Set(float64(op.BytesSent))
+			m.BytesRecv.WithLabelValues(with(op.Op)...).Set(float64(op.BytesRecv))
+			m.QueueMs.WithLabelValues(with(op.Op)...).Set(float64(op.QueueMs))
+			m.RTTMs.WithLabelValues(with(op.Op)...).Set(float64(op.RTTMs))
+			m.ExecuteMs.WithLabelValues(with(op.Op)...).Set(float64(op.ExecuteMs))
+			m.Errors.WithLabelValues(with(op.Op)...).Set(float64(op.Errors))
+		}
+
+		for _, xprt := range stats.Xprts {
+			m.Connects.WithLabelValues(with(xprt.Protocol)...).Set(float64(xprt.ConnectCount))
+			m.Sends.WithLabelValues(with(xprt.Protocol)...).Set(float64(xprt.Sends))
+			m.Recvs.WithLabelValues(with(xprt.Protocol)...).Set(float64(xprt.Recvs))
+			m.BadXIDs.WithLabelValues(with(xprt.Protocol)...).Set(float64(xprt.BadXIDs))
+			m.BklogU.WithLabelValues(with(xprt.Protocol)...).Set(float64(xprt.BklogU))
+			m.SendingU.WithLabelValues(with(xprt.Protocol)...).Set(float64(xprt.SendingU))
+			m.PendingU.WithLabelValues(with(xprt.Protocol)...).Set(float64(xprt.PendingU))
+		}
+
+		return true
+	})
+
+	for mountPoint, labels := range m.exported {
+		if _, ok := mounted[mountPoint]; !ok {
+			m.deleteSeries(labels)
+			delete(m.exported, mountPoint)
+		}
+	}
+}
+
+// ProcessMountStats 定期解析 mountstats 并写入 cache.MountStatsMap，不依赖 eBPF
+func ProcessMountStats(ctx context.Context) {
+	for {
+		// self 为 agent 容器自身的挂载命名空间，读取宿主机 init 进程以覆盖宿主机和 kubelet 的 NFS 挂载
+		stats, err := metadata.ParseMountStats(config.GetProcPath("1/mountstats"))
+		if err != nil {
+			log.Errorf("解析 mountstats 失败: %v", err)
+		} else {
+			updateMountStatsCache(stats)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Infof("退出 mountstats 处理")
+			return
+		case <-time.After(MountStatsPollInterval):
+			continue
+		}
+	}
+}
+
+func updateMountStatsCache(stats []metadata.MountStats) {
+	mounted := make(map[string]struct{}, len(stats))
+	for _, s := range stats {
+		mounted[s.MountPoint] = struct{}{}
+		cache.MountStatsMap.Store(s.MountPoint, s)
+	}
+
+	// 删除已卸载的挂载点
+	cache.MountStatsMap.Range(func(key, value interface{}) bool {
+		if _, ok := mounted[key.(string)]; !ok {
+			cache.MountStatsMap.Delete(key)
+		}
+		return true
+	})
+}
@@ -0,0 +1,143 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/binary"
+	"github.com/cen-ngc5139/nfs-trace/internal/cache"
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
+	"github.com/cilium/ebpf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const NFS4StateEventsTotal = "nfs4_state_events_total"
+
+// 与 bpf/trace.c 中 enum nfs4_event_type 保持一致
+var nfs4EventNames = []string{
+	"delegation_grant",
+	"delegation_return",
+	"delegation_recall",
+	"open_reclaim",
+	"open_expired",
+	"state_recovery",
+	"state_recovery_failed",
+	"lease_renew",
+	"sequence_error",
+	"slot_exhausted",
+}
+
+var nfs4StateEventsTotal = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: NFS4StateEventsTotal,
+		Help: "NFSv4 state management events by type",
+	},
+	[]string{"type", "status", "node_name", "nfs_server", "mount_path"},
+)
+
+func nfs4EventName(typ uint8) string {
+	if int(typ) < len(nfs4EventNames) {
+		return nfs4EventNames[typ]
+	}
+	return "unknown"
+}
+
+// nfs4File 关联事件涉及的文件、挂载点和 Pod，服务端优先使用 tracepoint 中的地址
+func nfs4File(event binary.NFSTraceNfs4Event) metadata.NFSFile {
+	var file metadata.NFSFile
+	if event.Key != (binary.NFSTraceFileKey{}) {
+		if fileInfo, ok := cache.NFSDevIDFileIDFileInfoMap.Load(event.Key); ok {
+			file = fileInfo.(metadata.NFSFile)
+		}
+		if filePath, ok := cache.NFSFileDetailMap.Load(event.Key); ok {
+			file.FilePath = filePath.(metadata.FilePath).Path
+			file.PathTruncated = filePath.(metadata.FilePath).Truncated
+		}
+		if mount, ok := metadata.GetMountInfoByDev(uint32(event.Key.DevId)); ok {
+			file.MountPath = mount.LocalMountDir
+			file.LocalMountDir = mount.LocalMountDir
+			file.RemoteNFSAddr = mount.RemoteNFSAddr
+		}
+	}
+
+	if server := convertInt8ToString(event.Server[:]); server != "" {
+		file.RemoteNFSAddr = server
+	}
+
+	if pod := sanitizeString(convertInt8ToString(event.Pod[:])); pod != "" {
+		file.Pod = pod
+		file.Container = sanitizeString(convertInt8ToString(event.Container[:]))
+	}
+
+	return file
+}
+
+func ProcessNFS4Events(coll *ebpf.Collection, ctx context.Context, cfg config.Configuration) {
+	events := coll.Maps["nfs4_events"]
+	// Set up a ringbuf or perf reader to read events from the eBPF program
+	rd, err := newEventReader(events, cfg)
+	if err != nil {
+		log.Fatalf("Creating event reader failed: %v\n", err)
+	}
+	defer rd.Close()
+
+	nodeName, err := os.Hostname()
+	if err != nil {
+		nodeName = "default_node"
+	}
+
+	var event binary.NFSTraceNfs4Event
+	for {
+		for {
+			if err := parseEvent(rd, &event); err == nil {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				log.Infof("退出 NFSv4 状态事件处理")
+				return
+			case <-time.After(time.Microsecond):
+				continue
+			}
+		}
+
+		file := nfs4File(event)
+		typ := nfs4EventName(event.Type)
+		status := StatusName(event.Error)
+
+		fields := map[string]interface{}{
+			"event":   "nfs4_state",
+			"type":    typ,
+			"status":  status,
+			"errno":   event.Error,
+			"pid":     event.Pid,
+			"session": fmt.Sprintf("%#x", event.Session),
+			"count":   event.Count,
+		}
+		if event.Key != (binary.NFSTraceFileKey{}) {
+			fields["dev_id"], fields["file_id"] = GetDevIDFileID(event.Key)
+		}
+		if event.Slot != 0 {
+			fields["slot"] = event.Slot
+		}
+		if event.StatusFlags != 0 {
+			fields["status_flags"] = fmt.Sprintf("%#x", event.StatusFlags)
+		}
+		log.StdoutOrFile(cfg.Output.Type, file, fields)
+
+		nfs4StateEventsTotal.WithLabelValues(typ, status, nodeName, file.RemoteNFSAddr, file.MountPath).Add(float64(event.Count))
+
+		select {
+		case <-ctx.Done():
+			log.Infof("退出 NFSv4 状态事件处理")
+			return
+		default:
+		}
+	}
+}
@@ -1,10 +1,11 @@
 package output
 
 import (
-	"fmt"
 	"os"
+	"strconv"
 	"sync"
 
+	"github.com/cen-ngc5139/nfs-trace/internal/binary"
 	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
@@ -19,6 +20,10 @@ const (
 	NFSWriteSize      = "nfs_write_size"
 	NFSReadLatencies  = "nfs_read_latencies"
 	NFSWriteLatencies = "nfs_write_latencies"
+	NFSReadQueueLat   = "nfs_read_queue_latencies"
+	NFSWriteQueueLat  = "nfs_write_queue_latencies"
+	NFSReadRtt        = "nfs_read_rtt"
+	NFSWriteRtt       = "nfs_write_rtt"
 	// NFSFileDetail     = "nfs_file_detail"
 )
 
@@ -30,6 +35,10 @@ type NFSMetrics struct {
 	WriteSize      *prometheus.GaugeVec
 	ReadLatencies  *prometheus.GaugeVec
 	WriteLatencies *prometheus.GaugeVec
+	ReadQueueLat   *prometheus.GaugeVec
+	WriteQueueLat  *prometheus.GaugeVec
+	ReadRtt        *prometheus.GaugeVec
+	WriteRtt       *prometheus.GaugeVec
 	NFSFileDetail  *prometheus.GaugeVec
 	performanceMap *sync.Map
 	fileInfoMap    *sync.Map
@@ -55,21 +64,18 @@ func NewNFSMetrics(performanceMap *sync.Map, fileInfoMap *sync.Map) *NFSMetrics
 		WriteSize:      createCounterVec(NFSWriteSize, "NFS write size"),
 		ReadLatencies:  createCounterVec(NFSReadLatencies, "NFS read latencies"),
 		WriteLatencies: createCounterVec(NFSWriteLatencies, "NFS write latencies"),
+		ReadQueueLat:   createCounterVec(NFSReadQueueLat, "NFS read latencies spent before the last transmit"),
+		WriteQueueLat:  createCounterVec(NFSWriteQueueLat, "NFS write latencies spent before the last transmit"),
+		ReadRtt:        createCounterVec(NFSReadRtt, "NFS read round trip time to the server"),
+		WriteRtt:       createCounterVec(NFSWriteRtt, "NFS write round trip time to the server"),
 		// NFSFileDetail:  createCounterVec(NFSFileDetail, "NFS file detail"),
 		performanceMap: performanceMap,
 		fileInfoMap:    fileInfoMap,
 	}
 }
 
-func GetDevIDFileID(keyStr uint64) (string, string) {
-	// 假设 keyStr 的格式是 "devID:fileID"
-	devID, fileID := parseKey(keyStr)
-
-	// devID fileID 转换成字符串
-	devIDStr := fmt.Sprintf("%d", devID)
-	fileIDStr := fmt.Sprintf("%d", fileID)
-
-	return devIDStr, fileIDStr
+func GetDevIDFileID(key binary.NFSTraceFileKey) (string, string) {
+	return strconv.FormatUint(key.DevId, 10), strconv.FormatUint(key.FileId, 10)
 }
 
 // UpdateMetricsFromCache updates the Prometheus metrics from the NFSPerformanceMap
@@ -81,7 +87,6 @@ func (m *NFSMetrics) UpdateMetricsFromCache(nodeName string) {
 	// })
 
 	m.performanceMap.Range(func(key, value interface{}) bool {
-		keyStr := key.(uint64)
 		info := value.(metadata.NFSTraceInfo)
 		metrics := info.Traffic
 		file := info.File
@@ -89,12 +94,7 @@ func (m *NFSMetrics) UpdateMetricsFromCache(nodeName string) {
 		filePath := file.FilePath
 		mountPath := file.MountPath
 
-		// 假设 keyStr 的格式是 "devID:fileID"
-		devID, fileID := parseKey(keyStr)
-
-		// devID fileID 转换成字符串
-		devIDStr := fmt.Sprintf("%d", devID)
-		fileIDStr := fmt.Sprintf("%d", fileID)
+		devIDStr, fileIDStr := GetDevIDFileID(key.(binary.NFSTraceFileKey))
 		pod := file.Pod
 		container := file.Container
 
@@ -117,12 +117,34 @@ func (m *NFSMetrics) UpdateMetricsFromCache(nodeName string) {
 		if metrics.WriteLat > 0 {
 			m.WriteLatencies.WithLabelValues(devIDStr, fileIDStr, nodeName, nfsServer, filePath, mountPath, pod, container).Set(float64(metrics.WriteLat))
 		}
+		if metrics.ReadQueueLat > 0 {
+			m.ReadQueueLat.WithLabelValues(devIDStr, fileIDStr, nodeName, nfsServer, filePath, mountPath, pod, container).Set(float64(metrics.ReadQueueLat))
+		}
+		if metrics.WriteQueueLat > 0 {
+			m.WriteQueueLat.WithLabelValues(devIDStr, fileIDStr, nodeName, nfsServer, filePath, mountPath, pod, container).Set(float64(metrics.WriteQueueLat))
+		}
+		if metrics.ReadRtt > 0 {
+			m.ReadRtt.WithLabelValues(devIDStr, fileIDStr, nodeName, nfsServer, filePath, mountPath, pod, container).Set(float64(metrics.ReadRtt))
+		}
+		if metrics.WriteRtt > 0 {
+			m.WriteRtt.WithLabelValues(devIDStr, fileIDStr, nodeName, nfsServer, filePath, mountPath, pod, container).Set(float64(metrics.WriteRtt))
+		}
 
 		return true
 	})
 }
 
-func (m *NFSMetrics) MetricsHandler() gin.HandlerFunc {
+// MetricsUpdater 在每次抓取前从缓存刷新指标
+type MetricsUpdater interface {
+	UpdateMetricsFromCache(nodeName string)
+}
+
+func (m *NFSMetrics) MetricsHandler(updaters ...MetricsUpdater) gin.HandlerFunc {
+	return MetricsHandler(append([]MetricsUpdater{m}, updaters...)...)
+}
+
+// MetricsHandler 在每次抓取前依次刷新 updaters 中的指标
+func MetricsHandler(updaters ...MetricsUpdater) gin.HandlerFunc {
 	h := promhttp.Handler()
 
 	nodeName, err := os.Hostname()
@@ -131,7 +153,9 @@ func (m *NFSMetrics) MetricsHandler() gin.HandlerFunc {
 	}
 
 	return func(c *gin.Context) {
-		m.UpdateMetricsFromCache(nodeName)
+		for _, u := range updaters {
+			u.UpdateMetricsFromCache(nodeName)
+		}
 		h.ServeHTTP(c.Writer, c.Request)
 	}
 }
@@ -0,0 +1,60 @@
+package output
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const NFSServerInfo = "nfs_server_info"
+
+const (
+	afInet  = 2
+	afInet6 = 10
+)
+
+// serverInfo 挂载使用的服务端地址和 NFS 版本，值恒为 1
+var serverInfo = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: NFSServerInfo,
+		Help: "NFS server address and version resolved from the RPC client in kernel",
+	},
+	[]string{"node_name", "nfs_server", "server_addr", "nfs_version", "mount_path"},
+)
+
+// formatSockaddr 将内核中的 sockaddr_in/sockaddr_in6 转换为 ip:port，
+// sa_family 为主机字节序，端口和地址为网络字节序
+func formatSockaddr(raw [28]uint8) string {
+	port := binary.BigEndian.Uint16(raw[2:4])
+	switch binary.NativeEndian.Uint16(raw[0:2]) {
+	case afInet:
+		addr := netip.AddrFrom4([4]byte(raw[4:8]))
+		return netip.AddrPortFrom(addr, port).String()
+	case afInet6:
+		addr := netip.AddrFrom16([16]byte(raw[8:24])).Unmap()
+		return netip.AddrPortFrom(addr, port).String()
+	}
+	return ""
+}
+
+// nfsVersion 返回 3、4.1 形式的版本号，NFSv4 带上 minor 版本
+func nfsVersion(major, minor uint8) string {
+	switch {
+	case major == 0:
+		return ""
+	case major >= 4:
+		return fmt.Sprintf("%d.%d", major, minor)
+	}
+	return fmt.Sprintf("%d", major)
+}
+
+// serverHost 去掉 ip:port 中的端口
+func serverHost(addr string) string {
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return ap.Addr().String()
+	}
+	return addr
+}
@@ -0,0 +1,55 @@
+package output
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func sockaddr(family uint16, port uint16, addr []byte, offset int) (raw [28]uint8) {
+	binary.NativeEndian.PutUint16(raw[0:2], family)
+	binary.BigEndian.PutUint16(raw[2:4], port)
+	copy(raw[offset:], addr)
+	return
+}
+
+func TestFormatSockaddr(t *testing.T) {
+	v6 := []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	mapped := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 192, 168, 100, 204}
+
+	tests := []struct {
+		name string
+		raw  [28]uint8
+		want string
+	}{
+		{"ipv4", sockaddr(afInet, 2049, []byte{192, 168, 100, 204}, 4), "192.168.100.204:2049"},
+		{"ipv6", sockaddr(afInet6, 2049, v6, 8), "[2001:db8::1]:2049"},
+		{"ipv4 mapped", sockaddr(afInet6, 2049, mapped, 8), "192.168.100.204:2049"},
+		{"unknown family", [28]uint8{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatSockaddr(tt.raw); got != tt.want {
+				t.Errorf("formatSockaddr() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNFSVersion(t *testing.T) {
+	tests := []struct {
+		major, minor uint8
+		want         string
+	}{
+		{0, 0, ""},
+		{3, 0, "3"},
+		{4, 0, "4.0"},
+		{4, 2, "4.2"},
+	}
+
+	for _, tt := range tests {
+		if got := nfsVersion(tt.major, tt.minor); got != tt.want {
+			t.Errorf("nfsVersion(%d, %d) = %q, want %q", tt.major, tt.minor, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package output
+
+import (
+	"strings"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/bpf"
+	"github.com/cilium/ebpf"
+	"k8s.io/klog/v2"
+)
+
+// 与 bpf/trace.c 中 MAX_STACK_DEPTH 保持一致
+const maxStackDepth = 127
+
+const (
+	AccessRead   = "read"
+	AccessWrite  = "write"
+	AccessMmap   = "mmap"
+	AccessSplice = "splice"
+	AccessDirect = "direct"
+)
+
+// accessPathFuncs 按优先级排列，调用栈中出现对应前缀的函数即认为是该访问路径
+var accessPathFuncs = []struct {
+	path     string
+	prefixes []string
+}{
+	{AccessDirect, []string{"nfs_file_direct_", "nfs_direct_"}},
+	{AccessSplice, []string{"do_splice", "splice_", "filemap_splice_read", "generic_file_splice_read"}},
+	{AccessMmap, []string{"filemap_fault", "nfs_vm_page_mkwrite", "do_page_mkwrite", "handle_mm_fault"}},
+	{AccessRead, []string{"vfs_read", "vfs_iter_read", "io_read"}},
+	{AccessWrite, []string{"vfs_write", "vfs_iter_write", "io_write"}},
+}
+
+// readStack 读取 stack_traces 中的调用栈，返回符号化后的栈帧，由内向外排列。
+// 内核以 BPF_F_REUSE_STACKID 获取调用栈，相同的调用栈共享 stack id，读取后不能删除
+func readStack(stacks *ebpf.Map, stackID int32, addr2name bpf.Addr2Name) []string {
+	if stacks == nil || stackID < 0 {
+		return nil
+	}
+
+	var ips [maxStackDepth]uint64
+	id := uint32(stackID)
+	if err := stacks.Lookup(id, &ips); err != nil {
+		klog.V(2).Infof("Failed to lookup stack %d: %v", stackID, err)
+		return nil
+	}
+
+	frames := make([]string, 0, 16)
+	for _, ip := range ips {
+		if ip == 0 {
+			break
+		}
+		frames = append(frames, addr2name.Symbolize(ip))
+	}
+
+	return frames
+}
+
+// accessPath 根据调用栈判断触发访问的路径，无法判断时返回空
+func accessPath(frames []string) string {
+	for _, p := range accessPathFuncs {
+		for _, frame := range frames {
+			fn, _, _ := strings.Cut(frame, "+")
+			for _, prefix := range p.prefixes {
+				if strings.HasPrefix(fn, prefix) {
+					return p.path
+				}
+			}
+		}
+	}
+
+	return ""
+}
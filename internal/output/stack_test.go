@@ -0,0 +1,44 @@
+package output
+
+import "testing"
+
+func TestAccessPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []string
+		want   string
+	}{
+		{
+			name:   "read",
+			frames: []string{"nfs_file_read+0x0 [nfs]", "vfs_read+0x9c", "ksys_read+0x67"},
+			want:   AccessRead,
+		},
+		{
+			name:   "direct io",
+			frames: []string{"nfs_file_direct_read+0x5 [nfs]", "nfs_file_read+0x3a [nfs]", "vfs_read+0x9c"},
+			want:   AccessDirect,
+		},
+		{
+			name:   "splice",
+			frames: []string{"nfs_file_read+0x0 [nfs]", "filemap_splice_read+0x1f1", "splice_direct_to_actor+0xb4", "do_sendfile+0x2ce"},
+			want:   AccessSplice,
+		},
+		{
+			name:   "mmap",
+			frames: []string{"nfs_vm_page_mkwrite+0x0 [nfs]", "do_page_mkwrite+0x4f", "handle_mm_fault+0x12b"},
+			want:   AccessMmap,
+		},
+		{
+			name:   "unknown",
+			frames: []string{"0xffffffffc0a1b2c3"},
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := accessPath(tt.frames); got != tt.want {
+				t.Errorf("accessPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/binary"
+	"github.com/cen-ngc5139/nfs-trace/internal/cache"
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	NFSHotFileIOPS    = "nfs_hot_file_iops"
+	NFSHotFileBytes   = "nfs_hot_file_bytes_per_second"
+	NFSHotFileLatency = "nfs_hot_file_avg_latency"
+
+	TopKSampleInterval = 10 * time.Second
+	DefaultTopKSize    = 10
+)
+
+const (
+	TopKByIOPS    = "iops"
+	TopKByBytes   = "bytes"
+	TopKByLatency = "latency"
+)
+
+// TopKWindows 滑动窗口及其对应的采样桶数
+var TopKWindows = map[string]int{
+	"1m":  int(time.Minute / TopKSampleInterval),
+	"5m":  int(5 * time.Minute / TopKSampleInterval),
+	"15m": int(15 * time.Minute / TopKSampleInterval),
+}
+
+// HotFiles 全局的热点文件统计
+var HotFiles = NewTopKTracker(cache.NFSPerformanceMap, cache.NFSFileDetailMap, DefaultTopKSize)
+
+// HotFile 窗口内的热点文件
+type HotFile struct {
+	Key       binary.NFSTraceFileKey `json:"-"`
+	DevID     uint64                 `json:"dev_id"`
+	FileID    uint64                 `json:"file_id"`
+	FilePath  string                 `json:"file_path"`
+	MountPath string                 `json:"mount_path"`
+	NFSServer string                 `json:"nfs_server"`
+	Pod       string                 `json:"pod"`
+	Container string                 `json:"container"`
+	IOPS      float64                `json:"iops"`
+	Bytes     float64                `json:"bytes_per_second"`
+	Latency   float64                `json:"avg_latency_ns"`
+}
+
+type fileCounters struct {
+	count   uint64
+	bytes   uint64
+	latency uint64
+}
+
+func (c fileCounters) sub(prev fileCounters) fileCounters {
+	// LRU 淘汰后重新计数时累计值会变小，此时直接使用当前值
+	if c.count < prev.count || c.bytes < prev.bytes || c.latency < prev.latency {
+		return c
+	}
+	return fileCounters{
+		count:   c.count - prev.count,
+		bytes:   c.bytes - prev.bytes,
+		latency: c.latency - prev.latency,
+	}
+}
+
+// TopKTracker 按固定间隔对 NFSPerformanceMap 采样，保存每个间隔的增量，
+// 用于计算 1m/5m/15m 滑动窗口内的热点文件。桶数量固定，内存占用与 io_metrics 大小成正比
+type TopKTracker struct {
+	mu             sync.RWMutex
+	k              int
+	performanceMap *sync.Map
+	fileDetailMap  *sync.Map
+	last           map[binary.NFSTraceFileKey]fileCounters
+	buckets        []map[binary.NFSTraceFileKey]fileCounters
+	pos            int
+}
+
+func NewTopKTracker(performanceMap, fileDetailMap *sync.Map, k int) *TopKTracker {
+	maxBuckets := 0
+	for _, n := range TopKWindows {
+		if n > maxBuckets {
+			maxBuckets = n
+		}
+	}
+
+	return &TopKTracker{
+		k:              k,
+		performanceMap: performanceMap,
+		fileDetailMap:  fileDetailMap,
+		last:           make(map[binary.NFSTraceFileKey]fileCounters),
+		buckets:        make([]map[binary.NFSTraceFileKey]fileCounters, maxBuckets),
+	}
+}
+
+// SetK 设置导出的热点文件数量
+func (t *TopKTracker) SetK(k int) {
+	if k <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.k = k
+}
+
+// Sample 采样一次 NFSPerformanceMap，记录与上次采样的增量
+func (t *TopKTracker) Sample() {
+	current := make(map[binary.NFSTraceFileKey]fileCounters)
+	t.performanceMap.Range(func(key, value interface{}) bool {
+		info := value.(metadata.NFSTraceInfo)
+		current[key.(binary.NFSTraceFileKey)] = fileCounters{
+			count:   info.Traffic.ReadCount + info.Traffic.WriteCount,
+			bytes:   info.Traffic.ReadSize + info.Traffic.WriteSize,
+			latency: info.Traffic.ReadLat + info.Traffic.WriteLat,
+		}
+		return true
+	})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// 首次出现的文件只记录基线，其累计值包含上次采样之前（如 agent 重启前由 pinned map 保留）的流量
+	bucket := make(map[binary.NFSTraceFileKey]fileCounters)
+	for key, c := range current {
+		last, ok := t.last[key]
+		if !ok {
+			continue
+		}
+
+		delta := c.sub(last)
+		if delta.count == 0 && delta.bytes == 0 {
+			continue
+		}
+		bucket[key] = delta
+	}
+
+	t.buckets[t.pos] = bucket
+	t.pos = (t.pos + 1) % len(t.buckets)
+	t.last = current
+}
+
+// Top 返回窗口内按 by 排序的前 k 个文件，k <= 0 时使用默认值
+func (t *TopKTracker) Top(window, by string, k int) ([]HotFile, error) {
+	n, ok := TopKWindows[window]
+	if !ok {
+		return nil, fmt.Errorf("unknown window %q", window)
+	}
+
+	t.mu.RLock()
+	if k <= 0 {
+		k = t.k
+	}
+
+	sum := make(map[binary.NFSTraceFileKey]fileCounters)
+	for i := 1; i <= n; i++ {
+		bucket := t.buckets[(t.pos-i+len(t.buckets))%len(t.buckets)]
+		for key, c := range bucket {
+			s := sum[key]
+			s.count += c.count
+			s.bytes += c.bytes
+			s.latency += c.latency
+			sum[key] = s
+		}
+	}
+	t.mu.RUnlock()
+
+	seconds := (time.Duration(n) * TopKSampleInterval).Seconds()
+	files := make([]HotFile, 0, len(sum))
+	for key, c := range sum {
+		f := HotFile{
+			Key:    key,
+			DevID:  key.DevId,
+			FileID: key.FileId,
+			IOPS:   float64(c.count) / seconds,
+			Bytes:  float64(c.bytes) / seconds,
+		}
+		if c.count > 0 {
+			f.Latency = float64(c.latency) / float64(c.count)
+		}
+		files = append(files, f)
+	}
+
+	var less func(i, j int) bool
+	switch by {
+	case TopKByIOPS:
+		less = func(i, j int) bool { return files[i].IOPS > files[j].IOPS }
+	case TopKByBytes:
+		less = func(i, j int) bool { return files[i].Bytes > files[j].Bytes }
+	case TopKByLatency:
+		less = func(i, j int) bool { return files[i].Latency > files[j].Latency }
+	default:
+		return nil, fmt.Errorf("unknown order %q", by)
+	}
+	sort.Slice(files, less)
+
+	if len(files) > k {
+		files = files[:k]
+	}
+
+	for i := range files {
+		t.fillFileInfo(&files[i])
+	}
+
+	return files, nil
+}
+
+func (t *TopKTracker) fillFileInfo(f *HotFile) {
+	if v, ok := t.performanceMap.Load(f.Key); ok {
+		file := v.(metadata.NFSTraceInfo).File
+		f.FilePath = file.FilePath
+		f.MountPath = file.MountPath
+		f.NFSServer = file.RemoteNFSAddr
+		f.Pod = file.Pod
+		f.Container = file.Container
+	}
+
+	if f.FilePath == "" {
+		if v, ok := t.fileDetailMap.Load(f.Key); ok {
+			f.FilePath = v.(metadata.FilePath).Path
+		}
+	}
+}
+
+// Handler 返回热点文件查询接口，参数: window=1m|5m|15m, by=iops|bytes|latency, k
+func (t *TopKTracker) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		k, _ := strconv.Atoi(c.Query("k"))
+		files, err := t.Top(c.DefaultQuery("window", "1m"), c.DefaultQuery("by", TopKByIOPS), k)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, files)
+	}
+}
+
+// Run 按固定间隔采样
+func (t *TopKTracker) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infof("退出热点文件统计")
+			return
+		case <-time.After(TopKSampleInterval):
+			t.Sample()
+		}
+	}
+}
+
+// TopKMetrics 只导出热点文件的指标，避免 Prometheus 基数随文件数增长
+type TopKMetrics struct {
+	IOPS    *prometheus.GaugeVec
+	Bytes   *prometheus.GaugeVec
+	Latency *prometheus.GaugeVec
+	tracker *TopKTracker
+}
+
+func createTopKGaugeVec(name, help string) *prometheus.GaugeVec {
+	return promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: name,
+			Help: help,
+		},
+		[]string{"window", "dev_id", "file_id", "node_name", "nfs_server", "file_path", "mount_path", "nfs_pod", "nfs_container"},
+	)
+}
+
+// NewTopKMetrics 创建并注册热点文件指标
+func NewTopKMetrics(tracker *TopKTracker) *TopKMetrics {
+	return &TopKMetrics{
+		IOPS:    createTopKGaugeVec(NFSHotFileIOPS, "Top-K NFS files by IOPS"),
+		Bytes:   createTopKGaugeVec(NFSHotFileBytes, "Top-K NFS files by throughput"),
+		Latency: createTopKGaugeVec(NFSHotFileLatency, "Top-K NFS files by average latency in nanoseconds"),
+		tracker: tracker,
+	}
+}
+
+// UpdateMetricsFromCache updates the Prometheus metrics from the TopKTracker
+func (m *TopKMetrics) UpdateMetricsFromCache(nodeName string) {
+	m.IOPS.Reset()
+	m.Bytes.Reset()
+	m.Latency.Reset()
+
+	for window := range TopKWindows {
+		m.set(m.IOPS, window, TopKByIOPS, nodeName, func(f HotFile) float64 { return f.IOPS })
+		m.set(m.Bytes, window, TopKByBytes, nodeName, func(f HotFile) float64 { return f.Bytes })
+		m.set(m.Latency, window, TopKByLatency, nodeName, func(f HotFile) float64 { return f.Latency })
+	}
+}
+
+func (m *TopKMetrics) set(vec *prometheus.GaugeVec, window, by, nodeName string, value func(HotFile) float64) {
+	files, err := m.tracker.Top(window, by, 0)
+	if err != nil {
+		log.Errorf("获取热点文件失败: %v", err)
+		return
+	}
+
+	for _, f := range files {
+		devIDStr, fileIDStr := GetDevIDFileID(f.Key)
+		vec.WithLabelValues(window, devIDStr, fileIDStr, nodeName, f.NFSServer, f.FilePath, f.MountPath, f.Pod, f.Container).Set(value(f))
+	}
+}
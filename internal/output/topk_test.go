@@ -0,0 +1,82 @@
+package output
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/binary"
+	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
+)
+
+func TestTopKTracker(t *testing.T) {
+	performanceMap := new(sync.Map)
+	fileDetailMap := new(sync.Map)
+	tracker := NewTopKTracker(performanceMap, fileDetailMap, 2)
+
+	store := func(key, count, size, lat uint64) {
+		performanceMap.Store(binary.NFSTraceFileKey{DevId: 1 << 40, FileId: key}, metadata.NFSTraceInfo{
+			Traffic: binary.NFSTraceRawMetrics{ReadCount: count, ReadSize: size, ReadLat: lat},
+		})
+	}
+
+	store(1, 10, 100, 10)
+	store(2, 10, 100, 10)
+	store(3, 10, 100, 10)
+	tracker.Sample()
+
+	// 首次采样只记录基线，不计入窗口
+	if files, _ := tracker.Top("1m", TopKByIOPS, 0); len(files) != 0 {
+		t.Fatalf("Top() after first sample = %+v, want empty", files)
+	}
+
+	store(1, 70, 160, 130)
+	store(2, 16, 6100, 16)
+	store(3, 13, 130, 3010)
+	fileDetailMap.Store(binary.NFSTraceFileKey{DevId: 1 << 40, FileId: 2}, metadata.FilePath{Path: "/data/2"})
+	tracker.Sample()
+
+	tests := []struct {
+		name string
+		by   string
+		want []uint64
+	}{
+		{name: "iops", by: TopKByIOPS, want: []uint64{1, 2}},
+		{name: "bytes", by: TopKByBytes, want: []uint64{2, 1}},
+		{name: "latency", by: TopKByLatency, want: []uint64{3, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := tracker.Top("1m", tt.by, 0)
+			if err != nil {
+				t.Fatalf("Top() error = %v", err)
+			}
+			if len(files) != len(tt.want) {
+				t.Fatalf("Top() got %d files, want %d", len(files), len(tt.want))
+			}
+			for i, f := range files {
+				if f.FileID != tt.want[i] {
+					t.Errorf("Top()[%d] = %d, want %d", i, f.FileID, tt.want[i])
+				}
+			}
+		})
+	}
+
+	files, _ := tracker.Top("1m", TopKByBytes, 1)
+	if files[0].FilePath != "/data/2" {
+		t.Errorf("Top() file path = %q, want /data/2", files[0].FilePath)
+	}
+
+	// 窗口内只有两次采样之间的增量：文件 1 为 60 次请求、120 纳秒延迟
+	files, _ = tracker.Top("1m", TopKByIOPS, 1)
+	if want := 60 / time.Minute.Seconds(); files[0].IOPS != want {
+		t.Errorf("Top() iops = %v, want %v", files[0].IOPS, want)
+	}
+	if files[0].Latency != 2 {
+		t.Errorf("Top() latency = %v, want 2", files[0].Latency)
+	}
+
+	if _, err := tracker.Top("2m", TopKByIOPS, 0); err == nil {
+		t.Errorf("Top() with unknown window should fail")
+	}
+}
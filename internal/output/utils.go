@@ -2,24 +2,157 @@ package output
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"fmt"
 	"strings"
+	"time"
 
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/perf"
+	"github.com/cilium/ebpf/ringbuf"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-func parseEvent(rd *perf.Reader, data interface{}) error {
-	record, err := rd.Read()
+// eventReader 屏蔽 ringbuf 与 perf event array 的差异
+type eventReader interface {
+	readSample() ([]byte, error)
+	Close() error
+}
+
+const NFSTraceLostSamples = "nfs_trace_lost_samples_total"
+
+// lostSamples 缓冲区写满时丢弃的事件数，按 map 名称区分。perf 由读取时的 LostSamples 统计，
+// ringbuf 由内核写入 submit_errors
+var lostSamples = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: NFSTraceLostSamples,
+	Help: "Number of events lost because the perf event buffer or ring buffer was full",
+}, []string{"map"})
+
+// eventChannelMaps 与 bpf/trace.c 中 enum event_channel 保持一致
+var eventChannelMaps = []string{
+	"nfs_trace_map",
+	"path_ringbuf",
+	"io_events",
+	"rpc_error_events",
+	"xprt_events",
+	"meta_events",
+	"nfs4_events",
+	"lock_events",
+	"server_events",
+	"dns_events",
+}
+
+// sumPerCPU 汇总 per-CPU array 中 key 在各 CPU 上的值
+func sumPerCPU(m *ebpf.Map, key uint32) (uint64, error) {
+	var values []uint64
+	if err := m.Lookup(key, &values); err != nil {
+		return 0, err
+	}
+
+	var total uint64
+	for _, v := range values {
+		total += v
+	}
+	return total, nil
+}
+
+// ProcessSubmitErrors 定期读取 submit_errors，将 ringbuf 写满丢弃的事件计入 nfs_trace_lost_samples_total
+func ProcessSubmitErrors(coll *ebpf.Collection, ctx context.Context) {
+	submitErrors := coll.Maps["submit_errors"]
+	last := make([]uint64, len(eventChannelMaps))
+
+	for {
+		for i, name := range eventChannelMaps {
+			total, err := sumPerCPU(submitErrors, uint32(i))
+			if err != nil {
+				log.Errorf("读取 submit_errors 失败: %v", err)
+				break
+			}
+			if total > last[i] {
+				lostSamples.WithLabelValues(name).Add(float64(total - last[i]))
+				last[i] = total
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Infof("退出丢弃事件统计")
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+type perfEventReader struct {
+	*perf.Reader
+	lost prometheus.Counter
+}
+
+func (r perfEventReader) readSample() ([]byte, error) {
+	record, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if record.LostSamples > 0 {
+		r.lost.Add(float64(record.LostSamples))
+		return nil, fmt.Errorf("lost %d samples", record.LostSamples)
+	}
+
+	return record.RawSample, nil
+}
+
+type ringBufEventReader struct {
+	*ringbuf.Reader
+}
+
+func (r ringBufEventReader) readSample() ([]byte, error) {
+	record, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	return record.RawSample, nil
+}
+
+// newEventReader 根据 map 类型创建读取器，map 类型由 bpf.SetupEventMaps 在加载前确定
+func newEventReader(events *ebpf.Map, cfg config.Configuration) (eventReader, error) {
+	if events.Type() == ebpf.RingBuf {
+		rd, err := ringbuf.NewReader(events)
+		if err != nil {
+			return nil, err
+		}
+		return ringBufEventReader{rd}, nil
+	}
+
+	rd, err := perf.NewReader(events, cfg.Transport.PerfBufferSize)
+	if err != nil {
+		return nil, err
+	}
+
+	name := events.String()
+	if info, err := events.Info(); err == nil {
+		name = info.Name
+	}
+	return perfEventReader{Reader: rd, lost: lostSamples.WithLabelValues(name)}, nil
+}
+
+func parseEvent(rd eventReader, data interface{}) error {
+	sample, err := rd.readSample()
 	if err != nil {
 		return err
 	}
 
-	if record.RawSample == nil {
+	if sample == nil {
 		return errors.New("record.RawSample is nil")
 	}
 
-	if err := binary.Read(bytes.NewBuffer(record.RawSample), binary.LittleEndian, data); err != nil {
+	if err := binary.Read(bytes.NewBuffer(sample), binary.LittleEndian, data); err != nil {
 		return err
 	}
 
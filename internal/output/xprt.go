@@ -0,0 +1,326 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/binary"
+	"github.com/cen-ngc5139/nfs-trace/internal/cache"
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
+	"github.com/cen-ngc5139/nfs-trace/internal/metadata"
+	"github.com/cilium/ebpf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	NFSRPCRetransmits    = "nfs_rpc_retransmits"
+	NFSXprtConnects      = "nfs_xprt_connects"
+	NFSXprtConnectErrors = "nfs_xprt_connect_errors"
+	NFSXprtDisconnects   = "nfs_xprt_disconnects"
+	NFSXprtBacklogWaits  = "nfs_xprt_backlog_waits"
+	NFSXprtSendingWaits  = "nfs_xprt_sending_waits"
+	NFSXprtMountInfo     = "nfs_xprt_mount_info"
+)
+
+// 与 bpf/trace.c 中 enum xprt_event_type 保持一致
+const (
+	xprtEventRetransmit uint8 = iota
+	xprtEventConnect
+	xprtEventConnectError
+	xprtEventDisconnect
+	xprtEventBacklogWait
+	xprtEventSendingWait
+)
+
+var xprtEventNames = map[uint8]string{
+	xprtEventRetransmit:   "retransmit",
+	xprtEventConnect:      "connect",
+	xprtEventConnectError: "connect_error",
+	xprtEventDisconnect:   "disconnect",
+	xprtEventBacklogWait:  "backlog_wait",
+	xprtEventSendingWait:  "sending_wait",
+}
+
+// XprtMetrics holds sunrpc transport health metrics
+type XprtMetrics struct {
+	Retransmits   *prometheus.GaugeVec
+	Connects      *prometheus.GaugeVec
+	ConnectErrors *prometheus.GaugeVec
+	Disconnects   *prometheus.GaugeVec
+	BacklogWaits  *prometheus.GaugeVec
+	SendingWaits  *prometheus.GaugeVec
+	MountInfo     *prometheus.GaugeVec
+	statsMap      *sync.Map
+	devXprtMap    *sync.Map
+
+	mu sync.Mutex
+	// exported 已导出的序列，传输层释放或挂载对应关系变化时删除
+	exported      map[xprtSeries]struct{}
+	exportedMount map[xprtMountSeries]struct{}
+}
+
+// xprtSeries 传输层指标的标识，使用服务端地址和 rpc_clnt 的 cl_clid，不导出内核指针
+type xprtSeries struct {
+	clientID string
+	server   string
+}
+
+type xprtMountSeries struct {
+	xprtSeries
+	dev       string
+	mountPath string
+}
+
+func createXprtGaugeVec(name, help string) *prometheus.GaugeVec {
+	return promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: name,
+			Help: help,
+		},
+		[]string{"client_id", "node_name", "nfs_server"},
+	)
+}
+
+// NewXprtMetrics 创建并注册 sunrpc 传输层指标
+func NewXprtMetrics(statsMap *sync.Map, devXprtMap *sync.Map) *XprtMetrics {
+	return &XprtMetrics{
+		Retransmits:   createXprtGaugeVec(NFSRPCRetransmits, "NFS RPC retransmits per transport"),
+		Connects:      createXprtGaugeVec(NFSXprtConnects, "NFS transport connect attempts"),
+		ConnectErrors: createXprtGaugeVec(NFSXprtConnectErrors, "NFS transport connect errors"),
+		Disconnects:   createXprtGaugeVec(NFSXprtDisconnects, "NFS transport disconnects"),
+		BacklogWaits:  createXprtGaugeVec(NFSXprtBacklogWaits, "NFS tasks waiting for a transport slot"),
+		SendingWaits:  createXprtGaugeVec(NFSXprtSendingWaits, "NFS tasks waiting for the transport lock"),
+		MountInfo: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: NFSXprtMountInfo,
+				Help: "NFS mount to transport mapping, joins with dev_id of per-file metrics",
+			},
+			[]string{"client_id", "dev_id", "node_name", "nfs_server", "mount_path"},
+		),
+		statsMap:      statsMap,
+		devXprtMap:    devXprtMap,
+		exported:      make(map[xprtSeries]struct{}),
+		exportedMount: make(map[xprtMountSeries]struct{}),
+	}
+}
+
+func newXprtSeries(stats binary.NFSTraceXprtStats) xprtSeries {
+	return xprtSeries{
+		clientID: strconv.FormatUint(uint64(stats.ClientId), 10),
+		server:   convertInt8ToString(stats.Addr[:]),
+	}
+}
+
+func (m *XprtMetrics) vecs() []*prometheus.GaugeVec {
+	return []*prometheus.GaugeVec{m.Retransmits, m.Connects, m.ConnectErrors, m.Disconnects, m.BacklogWaits, m.SendingWaits}
+}
+
+// UpdateMetricsFromCache updates the Prometheus metrics from the XprtStatsMap
+func (m *XprtMetrics) UpdateMetricsFromCache(nodeName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// 同一客户端切换传输层后标识相同，按标识累加
+	series := make(map[xprtSeries]binary.NFSTraceXprtStats)
+	m.statsMap.Range(func(key, value interface{}) bool {
+		stats := value.(binary.NFSTraceXprtStats)
+		id := newXprtSeries(stats)
+
+		sum := series[id]
+		sum.Retransmits += stats.Retransmits
+		sum.Connects += stats.Connects
+		sum.ConnectErrors += stats.ConnectErrors
+		sum.Disconnects += stats.Disconnects
+		sum.BacklogWaits += stats.BacklogWaits
+		sum.SendingWaits += stats.SendingWaits
+		series[id] = sum
+
+		return true
+	})
+
+	for id, stats := range series {
+		m.Retransmits.WithLabelValues(id.clientID, nodeName, id.server).Set(float64(stats.Retransmits))
+		m.Connects.WithLabelValues(id.clientID, nodeName, id.server).Set(float64(stats.Connects))
+		m.ConnectErrors.WithLabelValues(id.clientID, nodeName, id.server).Set(float64(stats.ConnectErrors))
+		m.Disconnects.WithLabelValues(id.clientID, nodeName, id.server).Set(float64(stats.Disconnects))
+		m.BacklogWaits.WithLabelValues(id.clientID, nodeName, id.server).Set(float64(stats.BacklogWaits))
+		m.SendingWaits.WithLabelValues(id.clientID, nodeName, id.server).Set(float64(stats.SendingWaits))
+	}
+
+	for id := range m.exported {
+		if _, ok := series[id]; !ok {
+			for _, vec := range m.vecs() {
+				vec.DeleteLabelValues(id.clientID, nodeName, id.server)
+			}
+			delete(m.exported, id)
+		}
+	}
+	for id := range series {
+		m.exported[id] = struct{}{}
+	}
+
+	mounts := make(map[xprtMountSeries]struct{})
+	m.devXprtMap.Range(func(key, value interface{}) bool {
+		dev := key.(uint32)
+		xprtID := value.(uint64)
+
+		// 传输层已释放或还没有统计时只导出挂载点
+		var id xprtSeries
+		if v, ok := m.statsMap.Load(xprtID); ok {
+			id = newXprtSeries(v.(binary.NFSTraceXprtStats))
+		}
+
+		var mountPath string
+		if mount, ok := metadata.GetMountInfoByDev(dev); ok {
+			mountPath = mount.LocalMountDir
+		}
+
+		mounts[xprtMountSeries{xprtSeries: id, dev: fmt.Sprintf("%d", dev), mountPath: mountPath}] = struct{}{}
+		return true
+	})
+
+	for s := range mounts {
+		m.MountInfo.WithLabelValues(s.clientID, s.dev, nodeName, s.server, s.mountPath).Set(1)
+	}
+	for s := range m.exportedMount {
+		if _, ok := mounts[s]; !ok {
+			m.MountInfo.DeleteLabelValues(s.clientID, s.dev, nodeName, s.server, s.mountPath)
+			delete(m.exportedMount, s)
+		}
+	}
+	for s := range mounts {
+		m.exportedMount[s] = struct{}{}
+	}
+}
+
+// syncDevXprt 将 dev_xprt 中挂载与传输层的对应关系同步到 cache.DevXprtMap，
+// 删除已卸载的挂载点，内核不会删除 dev_xprt 中的记录
+func syncDevXprt(devMap *ebpf.Map) {
+	if devMap == nil {
+		return
+	}
+
+	// 挂载信息还未加载时不删除
+	loaded := false
+	cache.MountInfoMap.Range(func(key, value interface{}) bool {
+		loaded = true
+		return false
+	})
+
+	var dev uint32
+	var id uint64
+	var unmounted []uint32
+	iter := devMap.Iterate()
+	for iter.Next(&dev, &id) {
+		if _, ok := metadata.GetMountInfoByDev(dev); loaded && !ok {
+			unmounted = append(unmounted, dev)
+			continue
+		}
+		cache.DevXprtMap.Store(dev, id)
+	}
+	if err := iter.Err(); err != nil {
+		log.Errorf("遍历 dev_xprt 时发生错误: %v", err)
+		return
+	}
+
+	for _, dev := range unmounted {
+		cache.DevXprtMap.Delete(dev)
+		if err := devMap.Delete(dev); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			log.Errorf("删除 dev_xprt 中的挂载 %d 失败: %v", dev, err)
+		}
+	}
+}
+
+func ProcessXprtMetrics(coll *ebpf.Collection, ctx context.Context) {
+	statsMap := coll.Maps["xprt_metrics"]
+	devMap := coll.Maps["dev_xprt"]
+
+	for {
+		var xprtID uint64
+		var stats binary.NFSTraceXprtStats
+		seen := make(map[uint64]struct{})
+		iter := statsMap.Iterate()
+		for iter.Next(&xprtID, &stats) {
+			cache.XprtStatsMap.Store(xprtID, stats)
+			seen[xprtID] = struct{}{}
+		}
+		if err := iter.Err(); err != nil {
+			log.Errorf("遍历 xprt_metrics 时发生错误: %v", err)
+		} else {
+			// 删除已释放的传输层
+			cache.XprtStatsMap.Range(func(key, value interface{}) bool {
+				if _, ok := seen[key.(uint64)]; !ok {
+					cache.XprtStatsMap.Delete(key)
+				}
+				return true
+			})
+		}
+
+		syncDevXprt(devMap)
+
+		select {
+		case <-ctx.Done():
+			log.Infof("退出传输层指标处理")
+			return
+		case <-time.After(time.Second):
+			continue
+		}
+	}
+}
+
+func ProcessXprtEvents(coll *ebpf.Collection, ctx context.Context, cfg config.Configuration) {
+	events := coll.Maps["xprt_events"]
+	// Set up a ringbuf or perf reader to read events from the eBPF program
+	rd, err := newEventReader(events, cfg)
+	if err != nil {
+		log.Fatalf("Creating event reader failed: %v\n", err)
+	}
+	defer rd.Close()
+
+	var event binary.NFSTraceXprtEvent
+	for {
+		for {
+			if err := parseEvent(rd, &event); err == nil {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				log.Infof("退出传输层事件处理")
+				return
+			case <-time.After(time.Microsecond):
+				continue
+			}
+		}
+
+		data := map[string]interface{}{
+			"event":      "xprt",
+			"type":       xprtEventNames[event.Type],
+			"nfs_server": convertInt8ToString(event.Addr[:]),
+			"client_id":  event.ClientId,
+			"task_id":    event.TaskId,
+		}
+
+		switch event.Type {
+		case xprtEventRetransmit:
+			data["ntrans"] = event.Status
+		case xprtEventConnectError:
+			data["status"] = StatusName(event.Status)
+		}
+
+		log.StdoutOrFile(cfg.Output.Type, data)
+
+		select {
+		case <-ctx.Done():
+			log.Infof("退出传输层事件处理")
+			return
+		default:
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/cen-ngc5139/nfs-trace/internal/bpf"
 	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cen-ngc5139/nfs-trace/internal/kernelbtf"
 
 	ebpfbinary "github.com/cen-ngc5139/nfs-trace/internal/binary"
 	"github.com/cen-ngc5139/nfs-trace/internal/log"
@@ -44,15 +45,34 @@ func Run(cfg config.Configuration) {
 	monitor.Start()
 	defer monitor.Stop()
 
-	// 移除 eBPF 程序的内存限制
+	// 移除 eBPF 程序的内存限制。5.11 以前的内核按 RLIMIT_MEMLOCK 计费 map 内存，
+	// 记录移除前的限制，加载前检查 map 容量配置；按 memcg 计费的内核上 RemoveMemlock 不修改限制
+	memlock, err := bpf.MemlockLimit()
+	if err != nil {
+		log.Fatalf("Failed to get memlock limit: %v\n", err)
+	}
+	memlockRemoved := true
 	if err := rlimit.RemoveMemlock(); err != nil {
-		log.Fatalf("Failed to remove memlock limit: %v\n", err)
-		os.Exit(1)
+		log.Warningf("Failed to remove memlock limit: %v", err)
+		memlockRemoved = false
+	}
+	memlockAccounting := !memlockRemoved
+	if current, err := bpf.MemlockLimit(); err == nil && current != memlock {
+		memlockAccounting = true
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	// eBPF 不可用、加载或附加失败时回退到仅采集 mountstats，
+	// 在其余 defer 卸载已附加的程序、关闭程序集之后再运行
+	var fallback bool
+	defer func() {
+		if fallback {
+			runMountStats(ctx, cfg)
+		}
+	}()
+
 	// 设置临时 rlimit
 	if err := unix.Setrlimit(unix.RLIMIT_NOFILE, &unix.Rlimit{
 		Cur: 8192,
@@ -63,15 +83,36 @@ func Run(cfg config.Configuration) {
 
 	// 获取 BPF 程序的入口函数名
 	var btfSpec *btf.Spec
-	var err error
+	// embeddedBTF 使用内置的精简 BTF，其中没有 FUNC/FUNC_PROTO，依赖函数原型的功能不可用
+	var embeddedBTF bool
+	// 外部或内置的 vmlinux BTF 与 /sys/kernel/btf 下的模块 BTF 不配套，未指定模块 BTF 目录时不扫描内核模块
+	scanKMods := cfg.BTF.ModelDir != ""
 	if cfg.BTF.Kernel != "" {
 		btfSpec, err = btf.LoadSpec(cfg.BTF.Kernel)
 	} else {
 		// 从 /sys/kernel/btf/vmlinux 加载内核 BTF 规范
 		btfSpec, err = btf.LoadKernelSpec()
+		if err == nil {
+			scanKMods = true
+		} else {
+			// 内核没有 BTF 时使用内置的精简 BTF，精简 BTF 不包含函数原型，需要通过 add_funcs 指定追踪的函数
+			embedded, name, embeddedErr := kernelbtf.Load()
+			if embeddedErr == nil {
+				log.Infof("Kernel BTF is not available (%v), using embedded BTF %s", err, name)
+				btfSpec, err = embedded, nil
+				embeddedBTF = true
+			} else {
+				log.Warningf("Failed to load embedded BTF: %v", embeddedErr)
+			}
+		}
 	}
 
 	if err != nil {
+		if cfg.Features.MountStats {
+			log.Warningf("Failed to load BTF spec: %s, falling back to mountstats collector", err)
+			fallback = true
+			return
+		}
 		log.Fatalf("Failed to load BTF spec: %s", err)
 	}
 
@@ -79,9 +120,16 @@ func Run(cfg config.Configuration) {
 		cfg.BTF.ModelDir = "/sys/kernel/btf"
 	}
 
+	if embeddedBTF {
+		disableFuncBTFFeatures(&cfg)
+	}
+
 	// 获取所有内核模块
 	kmods := make([]string, 0)
-	if cfg.Probing.AllKMods {
+	if cfg.Probing.AllKMods && !scanKMods {
+		log.Warningf("Kernel module BTF is not available with external or embedded BTF, ignoring all_kmods")
+	}
+	if cfg.Probing.AllKMods && scanKMods {
 		// 获取所有内核模块
 		files, err := os.ReadDir(cfg.BTF.ModelDir)
 		if err != nil {
@@ -102,10 +150,28 @@ func Run(cfg config.Configuration) {
 		addFuncs = bpf.SplitCustomFunList(cfg.Probing.AddFuncs)
 	}
 
-	// 获取需要过滤的函数
-	funcs, err := bpf.GetFuncs(cfg.Filter.Func, cfg.Filter.Struct, cfg.BTF.ModelDir, btfSpec, kmods, false)
+	// 按内核支持情况选择 kprobe-multi 或 kprobe，fentry 需显式指定
+	attachMode, err := bpf.ResolveAttachMode(cfg.Probing.AttachMode)
 	if err != nil {
-		log.Fatalf("Failed to get skb-accepting functions: %s", err)
+		log.Fatalf("Failed to get attach mode: %s", err)
+	}
+	// fentry 需要内核自身的 BTF
+	if embeddedBTF && attachMode == bpf.AttachModeFentry {
+		log.Warningf("fentry requires kernel BTF, which is not available with embedded BTF, falling back to kprobe")
+		if attachMode, err = bpf.ResolveAttachMode(bpf.AttachModeAuto); err != nil {
+			log.Fatalf("Failed to get attach mode: %s", err)
+		}
+	}
+	useKprobeMulti := attachMode == bpf.AttachModeKprobeMulti
+	useFentry := attachMode == bpf.AttachModeFentry
+
+	// 获取需要过滤的函数，内置 BTF 没有函数原型，只使用 add_funcs
+	funcs := bpf.Funcs{}
+	if !embeddedBTF {
+		funcs, err = bpf.GetFuncs(cfg.Filter.Func, cfg.Filter.Struct, cfg.BTF.ModelDir, btfSpec, kmods, useKprobeMulti)
+		if err != nil {
+			log.Fatalf("Failed to get skb-accepting functions: %s", err)
+		}
 	}
 
 	// 添加函数
@@ -117,6 +183,9 @@ func Run(cfg config.Configuration) {
 
 	if cfg.Probing.SkipAttach {
 		log.Info("Skipping attaching kprobes")
+		if cfg.Features.MountStats {
+			runMountStats(ctx, cfg)
+		}
 		return
 	}
 
@@ -132,19 +201,91 @@ func Run(cfg config.Configuration) {
 
 	// 加载 ebpf 程序集
 	var bpfSpec *ebpf.CollectionSpec
-	bpfSpec, err = ebpfbinary.LoadNFSTrace()
+	// 5.3 以前的内核不支持有界循环，加载展开路径遍历的程序集
+	boundedLoops := bpf.HaveBoundedLoops()
+	if boundedLoops {
+		bpfSpec, err = ebpfbinary.LoadNFSTrace()
+	} else {
+		log.Warningf("内核不支持有界循环，文件路径最多解析 %d 层", bpf.LegacyMaxPathDepth)
+		bpfSpec, err = ebpfbinary.LoadNFSTraceLegacy()
+	}
 	if err != nil {
 		log.Fatalf("Failed to load bpf spec: %v", err)
 	}
 
+	if err := bpf.CheckMapNames(bpfSpec, cfg.Maps); err != nil {
+		log.Fatalf("Invalid maps config: %v", err)
+	}
+
 	// 根据 flag 更新 bpfSpec
 	upateBpfSpecWithFlags(bpfSpec, cfg)
 
+	// 内核提供读写完成 tracepoint 时使用 tracepoint，不依赖 nfs_readpage_done 等函数的参数顺序
+	var useDoneTracepoint bool
+	if cfg.Features.NFSMetrics {
+		if err := bpf.SetupRPCTaskTracepoints(bpfSpec); err != nil {
+			log.Fatalf("Failed to setup rpc task tracepoints: %v", err)
+		}
+		useDoneTracepoint, err = bpf.SetupNFSDoneTracepoints(bpfSpec, cfg.Probing.CompletionProbe)
+		if err != nil {
+			log.Fatalf("Failed to setup nfs completion tracepoints: %v", err)
+		}
+	}
+	// 元数据操作函数的参数位置随内核版本变化，根据 BTF 确定
+	var metaKprobes, metaKretprobes map[string]string
+	if cfg.Features.MetaOps {
+		metaKprobes, metaKretprobes, err = bpf.SetupMetaProbes(bpfSpec, btfSpec, cfg.BTF.ModelDir)
+		if err != nil {
+			log.Fatalf("Failed to setup nfs metadata probes: %v", err)
+		}
+	}
+	// nfs4 tracepoint 的格式随内核版本变化，解析 tracefs 中的 format 获得字段位置
+	var nfs4Tracepoints map[string]map[string]string
+	if cfg.Features.NFS4State {
+		nfs4Tracepoints, err = bpf.SetupNFS4Tracepoints(bpfSpec)
+		if err != nil {
+			log.Fatalf("Failed to setup nfs4 tracepoints: %v", err)
+		}
+	}
+	bpf.SetKprobeAttachType(bpfSpec, useKprobeMulti)
+	tracingSpecs := bpf.TakeTracingSpecs(bpfSpec)
+
+	// 设置事件通道，内核不支持 ringbuf 时回退到 perf event array
+	useRingBuf, err := bpf.SetupEventMaps(bpfSpec, cfg.Transport)
+	if err != nil {
+		log.Fatalf("Failed to setup event maps: %v", err)
+	}
+
+	// 按配置调整 map 容量和淘汰策略
+	if err := bpf.SetupMapSizes(bpfSpec, cfg.Maps); err != nil {
+		log.Fatalf("Failed to setup map sizes: %v", err)
+	}
+
+	if memlockAccounting {
+		if err := bpf.CheckMemlock(bpfSpec, memlock); err != nil {
+			if !memlockRemoved {
+				log.Fatalf("%v, raise the memlock limit or reduce maps.sizes", err)
+			}
+			log.Warningf("%v, the limit has been removed for loading", err)
+		}
+	}
+
+	// 固定需要跨重启保留的 map，复用上次运行的计数
+	if cfg.Pin.Enabled {
+		if err := bpf.SetupPinning(bpfSpec, cfg.Pin.Path); err != nil {
+			log.Fatalf("Failed to setup map pinning: %v", err)
+		}
+		opts.Maps.PinPath = cfg.Pin.Path
+	}
+
 	// 获取配置
-	traceConfig, err := bpf.GetConfig(cfg)
+	traceConfig, err := bpf.GetConfig(cfg, useRingBuf, useFentry && bpf.HaveFuncIP())
 	if err != nil {
 		log.Fatalf("Failed to get trace config: %v", err)
 	}
+	if !boundedLoops && traceConfig.MaxPathDepth > bpf.LegacyMaxPathDepth {
+		traceConfig.MaxPathDepth = bpf.LegacyMaxPathDepth
+	}
 
 	// 将配置写入到 bpf 程序中
 	if err := bpfSpec.RewriteConstants(map[string]interface{}{
@@ -164,17 +305,56 @@ func Run(cfg config.Configuration) {
 			verifierLog = fmt.Sprintf("Verifier error: %+v\n", ve)
 		}
 
+		if cfg.Features.MountStats {
+			log.Warningf("Failed to load objects: %s\n%+v, falling back to mountstats collector", verifierLog, err)
+			fallback = true
+			return
+		}
 		log.Fatalf("Failed to load objects: %s\n%+v", verifierLog, err)
 	}
 	defer coll.Close()
 
+	// 关联内核态事件过滤 map，并写入配置中的规则
+	bpf.Filters.WithCollection(coll)
+	if err := bpf.Filters.Apply(cfg.EventFilter); err != nil {
+		log.Fatalf("Failed to apply event filter: %v", err)
+	}
+
 	// 根据 flag 获取 kprobe 附加关系
 	kprobeFuncs, kretprobeFuncs := getKprobeAttachMap(cfg)
+	if useDoneTracepoint {
+		delete(kprobeFuncs, "kb_nfs_read_d")
+		delete(kprobeFuncs, "kb_nfs_write_d")
+	}
+
+	// fentry 模式下读写完成函数使用 fexit，附加失败时回退到 kprobe
+	var tracer *bpf.Tracer
+	if useFentry {
+		tracer = bpf.NewTracer(bpfSpec, tracingSpecs, coll, opts.Programs)
+		defer tracer.Detach()
+
+		for fexit, kprobe := range bpf.FexitKprobeProgs {
+			if _, ok := kprobeFuncs[kprobe]; !ok {
+				continue
+			}
+
+			if err := tracer.Attach(fexit, ""); err != nil {
+				log.Warningf("Failed to attach %s, falling back to kprobe: %v", fexit, err)
+				continue
+			}
+			delete(kprobeFuncs, kprobe)
+		}
+	}
 
 	// 如果启用 NFS 指标，则附加 tracepoint
 	if cfg.Features.NFSMetrics {
 		trace, hasError, err := bpf.AttachTracepoint(coll)
 		if err != nil {
+			if cfg.Features.MountStats {
+				log.Warningf("Failed to attach tracepoint: %v, falling back to mountstats collector", err)
+				fallback = true
+				return
+			}
 			log.Fatalf("Failed to attach tracepoint: %v", err)
 		}
 		defer trace.Detach()
@@ -190,21 +370,102 @@ func Run(cfg config.Configuration) {
 		}
 	}
 
-	// 将 NFS 追踪的 kprobe 附加到内核
-	k := bpf.NewKprober(ctx, funcs, coll, addr2name, false, 10)
-	defer k.DetachKprobes()
+	// 将 NFS 追踪的 kprobe 附加到内核，fentry 附加失败的函数逐个回退到 kprobe
+	kprobeTargets := funcs
+	var uncounted bpf.Funcs
+	var funcLinks []bpf.FuncLink
+	if tracer != nil {
+		kprobeTargets = tracer.AttachFuncs(ctx, funcs)
+		funcLinks = append(funcLinks, tracer.FuncLinks()...)
+
+		// 不支持 bpf_get_func_ip 时 fentry 程序无法区分被追踪的函数，不统计命中次数
+		if !bpf.HaveFuncIP() {
+			uncounted = bpf.Funcs{}
+			for fn, pos := range funcs {
+				if _, ok := kprobeTargets[fn]; !ok {
+					uncounted[fn] = pos
+				}
+			}
+		}
+	}
+	if len(kprobeTargets) != 0 {
+		k := bpf.NewKprober(ctx, kprobeTargets, coll, addr2name, useKprobeMulti, 10)
+		defer k.DetachKprobes()
+		funcLinks = append(funcLinks, k.FuncLinks()...)
+	}
+
+	// 运行时通过 /probes 接口附加的函数使用 kprobe_skb_X，与启动时的过滤条件一致
+	bpf.Probes.WithCollection(coll, bpf.ProbeEnv{
+		BTF:         btfSpec,
+		ModelDir:    cfg.BTF.ModelDir,
+		KMods:       kmods,
+		Struct:      cfg.Filter.Struct,
+		KprobeMulti: useKprobeMulti,
+		NoFuncBTF:   embeddedBTF,
+		Addr2Name:   addr2name,
+		Funcs:       funcs,
+		Uncounted:   uncounted,
+		Links:       funcLinks,
+	})
+	defer bpf.Probes.Close()
 
 	if len(kprobeFuncs) != 0 {
 		// 将 NFS 追踪的 kprobe 附加到内核
-		c := bpf.NewCustomFuncsKprober(kprobeFuncs, coll)
+		c, err := bpf.NewCustomFuncsKprober(kprobeFuncs, coll)
+		if err != nil {
+			if cfg.Features.MountStats {
+				log.Warningf("Failed to attach kprobes: %v, falling back to mountstats collector", err)
+				fallback = true
+				return
+			}
+			log.Fatalf("Failed to attach kprobes: %v", err)
+		}
 		defer c.DetachKprobes()
 	}
 
 	if len(kretprobeFuncs) != 0 {
-		kret := bpf.NewCustomKretprobes(kretprobeFuncs, coll)
+		kret, err := bpf.NewCustomKretprobes(kretprobeFuncs, coll)
+		if err != nil {
+			if cfg.Features.MountStats {
+				log.Warningf("Failed to attach kretprobes: %v, falling back to mountstats collector", err)
+				fallback = true
+				return
+			}
+			log.Fatalf("Failed to attach kretprobes: %v", err)
+		}
 		defer kret.DetachKprobes()
 	}
 
+	// 附加可选的 kprobe，内核中不存在的函数会被跳过
+	optKprobeFuncs, optKretprobeFuncs := getOptionalKprobeAttachMap(cfg)
+	if len(optKprobeFuncs) != 0 {
+		o := bpf.NewOptionalKprober(optKprobeFuncs, coll, false)
+		defer o.DetachKprobes()
+	}
+
+	if len(optKretprobeFuncs) != 0 {
+		oret := bpf.NewOptionalKprober(optKretprobeFuncs, coll, true)
+		defer oret.DetachKprobes()
+	}
+
+	if len(metaKprobes) != 0 {
+		m := bpf.NewOptionalKprober(metaKprobes, coll, false)
+		defer m.DetachKprobes()
+
+		mret := bpf.NewOptionalKprober(metaKretprobes, coll, true)
+		defer mret.DetachKprobes()
+	}
+
+	for group, progs := range nfs4Tracepoints {
+		nfs4Trace := bpf.AttachTracepointGroup(coll, group, progs)
+		defer nfs4Trace.Detach()
+	}
+
+	if cfg.Features.Xprt {
+		xprtTrace := bpf.AttachXprtTracepoint(coll)
+		defer xprtTrace.Detach()
+	}
+
 	log.Info("Listening for events..")
 
 	defer func() {
@@ -237,11 +498,59 @@ func Run(cfg config.Configuration) {
 	// 添加任务
 
 	tm.Add("处理事件", func() error { output.ProcessEvents(coll, ctx, addr2name, cfg); return nil })
-	tm.Add("处理文件", func() error { output.ProcessFiles(coll, ctx); return nil })
+	tm.Add("处理文件", func() error { output.ProcessFiles(coll, ctx, cfg); return nil })
+
+	if cfg.Features.NFSMetrics || cfg.Features.Xprt || cfg.Features.MountStats || cfg.Features.AccessMethod || cfg.Features.MetaOps ||
+		cfg.Features.NFS4State || cfg.Features.Locks || cfg.Features.ServerHealth || cfg.Probing.RuntimeProbes || cfg.EventFilter.Runtime {
+		tm.Add("服务器", func() error { return server.NewServer(cfg).Start() })
+	}
+
+	tm.Add("统计 map 使用情况", func() error { bpf.WatchMapUsage(ctx, coll, cfg.Maps.WarnPercent, 10*time.Second); return nil })
+	tm.Add("统计丢弃的事件", func() error { output.ProcessSubmitErrors(coll, ctx); return nil })
+	if cfg.Probing.DedupInterval > 0 {
+		tm.Add("统计去重的事件", func() error { output.ProcessSuppressedEvents(coll, ctx); return nil })
+	}
+
+	if cfg.Features.MountStats {
+		tm.Add("处理 mountstats", func() error { output.ProcessMountStats(ctx); return nil })
+	}
+
+	if cfg.Features.NFSMetrics && cfg.Features.TopK {
+		output.HotFiles.SetK(cfg.TopK.Size)
+		tm.Add("热点文件统计", func() error { output.HotFiles.Run(ctx); return nil })
+	}
 
 	if cfg.Features.NFSMetrics {
-		tm.Add("服务器", func() error { return server.NewServer().Start() })
 		tm.Add("处理指标", func() error { output.ProcessMetrics(coll, ctx); return nil })
+		tm.Add("处理 RPC 错误", func() error { output.ProcessRPCErrors(coll, ctx, cfg); return nil })
+		if cfg.Features.IOPattern {
+			tm.Add("处理读写请求", func() error { output.ProcessIOEvents(coll, ctx, cfg); return nil })
+		}
+	}
+
+	if cfg.Features.AccessMethod {
+		tm.Add("处理访问方式统计", func() error { output.ProcessAccessMetrics(coll, ctx); return nil })
+	}
+
+	if cfg.Features.MetaOps {
+		tm.Add("处理元数据操作", func() error { output.ProcessMetaEvents(coll, ctx, cfg); return nil })
+	}
+
+	if cfg.Features.NFS4State {
+		tm.Add("处理 NFSv4 状态事件", func() error { output.ProcessNFS4Events(coll, ctx, cfg); return nil })
+	}
+
+	if cfg.Features.Locks {
+		tm.Add("处理文件锁事件", func() error { output.ProcessLockEvents(coll, ctx, cfg); return nil })
+	}
+
+	if cfg.Features.ServerHealth {
+		tm.Add("处理服务端可用性事件", func() error { output.ProcessServerEvents(coll, ctx, cfg); return nil })
+	}
+
+	if cfg.Features.Xprt {
+		tm.Add("处理传输层指标", func() error { output.ProcessXprtMetrics(coll, ctx); return nil })
+		tm.Add("处理传输层事件", func() error { output.ProcessXprtEvents(coll, ctx, cfg); return nil })
 	}
 
 	if cfg.Features.DNS {
@@ -253,3 +562,16 @@ func Run(cfg config.Configuration) {
 		fmt.Printf("错误: %v\n", err)
 	}
 }
+
+// runMountStats 在无法使用 eBPF 时仅运行 mountstats 采集和指标服务
+func runMountStats(ctx context.Context, cfg config.Configuration) {
+	log.Info("Running mountstats collector without eBPF")
+
+	tm := NewTaskManager()
+	tm.Add("服务器", func() error { return server.NewServer(cfg).Start() })
+	tm.Add("处理 mountstats", func() error { output.ProcessMountStats(ctx); return nil })
+
+	if err := tm.Run(); err != nil {
+		fmt.Printf("错误: %v\n", err)
+	}
+}
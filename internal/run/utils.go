@@ -1,10 +1,24 @@
 package run
 
 import (
+	"github.com/cen-ngc5139/nfs-trace/internal/bpf"
 	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/cen-ngc5139/nfs-trace/internal/log"
 	"github.com/cilium/ebpf"
 )
 
+// disableFuncBTFFeatures 内置的精简 BTF 不包含函数原型（FUNC/FUNC_PROTO），
+// filter.func 无法匹配函数，元数据操作函数的参数位置也无法确定
+func disableFuncBTFFeatures(cfg *config.Configuration) {
+	if cfg.Filter.Func != "" {
+		log.Warningf("Embedded BTF has no function prototypes, ignoring filter.func %q, only add_funcs are traced", cfg.Filter.Func)
+	}
+	if cfg.Features.MetaOps {
+		log.Warningf("Embedded BTF has no function prototypes, disabling meta_ops")
+		cfg.Features.MetaOps = false
+	}
+}
+
 func upateBpfSpecWithFlags(bpfSpec *ebpf.CollectionSpec, cfg config.Configuration) {
 	if !cfg.Features.NFSMetrics {
 		delete(bpfSpec.Programs, "kb_nfs_write_d")
@@ -15,11 +29,99 @@ func upateBpfSpecWithFlags(bpfSpec *ebpf.CollectionSpec, cfg config.Configuratio
 		delete(bpfSpec.Programs, "nfs_init_write")
 		delete(bpfSpec.Programs, "rpc_task_begin")
 		delete(bpfSpec.Programs, "rpc_task_done")
+		delete(bpfSpec.Programs, "fexit_nfs_read_d")
+		delete(bpfSpec.Programs, "fexit_nfs_write_d")
+		delete(bpfSpec.Programs, "tp_nfs_read_d")
+		delete(bpfSpec.Programs, "tp_nfs_write_d")
 
 		delete(bpfSpec.Maps, "waiting_RPC")
+		delete(bpfSpec.Maps, "task_done")
 		delete(bpfSpec.Maps, "link_begin")
-		delete(bpfSpec.Maps, "link_end")
 		delete(bpfSpec.Maps, "io_metrics")
+		delete(bpfSpec.Maps, "link_file")
+		delete(bpfSpec.Maps, "rpc_error_events")
+		delete(bpfSpec.Maps, "io_events")
+	}
+
+	if !cfg.Features.Xprt {
+		delete(bpfSpec.Programs, "kb_xprt_transmit")
+		delete(bpfSpec.Programs, "kb_xprt_connect")
+		delete(bpfSpec.Programs, "kb_xprt_disconnect")
+		delete(bpfSpec.Programs, "kb_xprt_destroy")
+		delete(bpfSpec.Programs, "kb_xprt_add_backlog")
+		delete(bpfSpec.Programs, "kb_xprt_reserve_xprt")
+		delete(bpfSpec.Programs, "kretb_xprt_reserve_xprt")
+		delete(bpfSpec.Programs, "rpc_connect_status")
+
+		delete(bpfSpec.Maps, "xprt_metrics")
+		delete(bpfSpec.Maps, "clnt_xprt")
+		delete(bpfSpec.Maps, "reserve_xprt_args")
+		delete(bpfSpec.Maps, "xprt_events")
+
+		// dev_xprt 被文件访问程序引用，始终保留
+	}
+
+	// 未启用调用栈时 stack_traces 仍被程序引用，只保留最小的 map
+	if !cfg.Features.StackTrace {
+		if m, ok := bpfSpec.Maps["stack_traces"]; ok {
+			m.MaxEntries = 1
+		}
+	}
+
+	if !cfg.Features.AccessMethod {
+		for _, prog := range []string{
+			"kb_nfs_file_read", "kretb_nfs_file_read", "kb_nfs_file_write", "kretb_nfs_file_write",
+			"kb_splice_read_begin", "kretb_splice_read_end", "kb_splice_write_begin", "kretb_splice_write_end",
+			"kb_nfs_file_splice_read", "kretb_nfs_file_splice_read", "kb_filemap_fault", "kretb_filemap_fault",
+			"kb_nfs_vm_page_mkwrite", "kretb_nfs_vm_page_mkwrite",
+		} {
+			delete(bpfSpec.Programs, prog)
+		}
+
+		delete(bpfSpec.Maps, "access_start")
+		delete(bpfSpec.Maps, "fault_start")
+		delete(bpfSpec.Maps, "in_splice")
+		delete(bpfSpec.Maps, "access_metrics")
+	}
+
+	if !cfg.Features.MetaOps {
+		for _, fn := range bpf.MetaFuncs {
+			kprobe, kretprobe := bpf.MetaPrograms(fn.Name)
+			delete(bpfSpec.Programs, kprobe)
+			delete(bpfSpec.Programs, kretprobe)
+		}
+
+		delete(bpfSpec.Maps, "meta_start")
+		delete(bpfSpec.Maps, "meta_path_seen")
+		delete(bpfSpec.Maps, "meta_events")
+	}
+
+	if !cfg.Features.NFS4State {
+		for _, tp := range bpf.NFS4Tracepoints {
+			delete(bpfSpec.Programs, tp.Prog)
+		}
+
+		delete(bpfSpec.Maps, "nfs4_slot_seen")
+		delete(bpfSpec.Maps, "nfs4_events")
+	}
+
+	if !cfg.Features.Locks {
+		delete(bpfSpec.Programs, "kb_nfs_lock")
+		delete(bpfSpec.Programs, "kretb_nfs_lock")
+		delete(bpfSpec.Programs, "kb_nfs_flock")
+		delete(bpfSpec.Programs, "kretb_nfs_flock")
+		delete(bpfSpec.Maps, "lock_start")
+		delete(bpfSpec.Maps, "lock_path_seen")
+		delete(bpfSpec.Maps, "lock_events")
+	}
+
+	if !cfg.Features.ServerHealth {
+		delete(bpfSpec.Programs, "kb_xprt_adjust_timeout")
+		delete(bpfSpec.Programs, "kretb_xprt_adjust_timeout")
+		delete(bpfSpec.Programs, "kb_xprt_complete_rqst")
+		delete(bpfSpec.Maps, "server_state")
+		delete(bpfSpec.Maps, "adjust_timeout_args")
+		delete(bpfSpec.Maps, "server_events")
 	}
 
 	if !cfg.Features.DNS {
@@ -40,6 +142,15 @@ func getKprobeAttachMap(cfg config.Configuration) (kprobeFuncs, kretprobeFuncs m
 		kprobeFuncs["rpc_execute"] = "rpc_make_runnable"
 	}
 
+	if cfg.Features.AccessMethod {
+		kprobeFuncs["kb_nfs_file_read"] = "nfs_file_read"
+		kprobeFuncs["kb_nfs_file_write"] = "nfs_file_write"
+		kprobeFuncs["kb_filemap_fault"] = "filemap_fault"
+		kretprobeFuncs["kretb_nfs_file_read"] = "nfs_file_read"
+		kretprobeFuncs["kretb_nfs_file_write"] = "nfs_file_write"
+		kretprobeFuncs["kretb_filemap_fault"] = "filemap_fault"
+	}
+
 	// 如果未启用 DNS 模式，则删除 kprobe_udp_sendmsg 的 kprobe
 	if cfg.Features.DNS {
 		kprobeFuncs["kprobe_udp_sendmsg"] = "udp_sendmsg"
@@ -49,3 +160,47 @@ func getKprobeAttachMap(cfg config.Configuration) (kprobeFuncs, kretprobeFuncs m
 
 	return kprobeFuncs, kretprobeFuncs
 }
+
+// getOptionalKprobeAttachMap 返回可选的 kprobe 附加关系，内核中不存在的函数会被跳过
+func getOptionalKprobeAttachMap(cfg config.Configuration) (kprobeFuncs, kretprobeFuncs map[string]string) {
+	kprobeFuncs = make(map[string]string)
+	kretprobeFuncs = make(map[string]string)
+
+	if cfg.Features.Xprt {
+		kprobeFuncs["kb_xprt_transmit"] = "xprt_transmit"
+		kprobeFuncs["kb_xprt_connect"] = "xprt_connect"
+		kprobeFuncs["kb_xprt_disconnect"] = "xprt_disconnect_done"
+		kprobeFuncs["kb_xprt_destroy"] = "xprt_destroy"
+		kprobeFuncs["kb_xprt_add_backlog"] = "xprt_add_backlog"
+		kprobeFuncs["kb_xprt_reserve_xprt"] = "xprt_reserve_xprt"
+		kretprobeFuncs["kretb_xprt_reserve_xprt"] = "xprt_reserve_xprt"
+	}
+
+	// splice 相关函数随内核版本变化，nfs_vm_page_mkwrite 为静态函数
+	if cfg.Features.AccessMethod {
+		kprobeFuncs["kb_splice_read_begin"] = "generic_file_splice_read"
+		kprobeFuncs["kb_splice_write_begin"] = "iter_file_splice_write"
+		kprobeFuncs["kb_nfs_file_splice_read"] = "nfs_file_splice_read"
+		kprobeFuncs["kb_nfs_vm_page_mkwrite"] = "nfs_vm_page_mkwrite"
+		kretprobeFuncs["kretb_splice_read_end"] = "generic_file_splice_read"
+		kretprobeFuncs["kretb_splice_write_end"] = "iter_file_splice_write"
+		kretprobeFuncs["kretb_nfs_file_splice_read"] = "nfs_file_splice_read"
+		kretprobeFuncs["kretb_nfs_vm_page_mkwrite"] = "nfs_vm_page_mkwrite"
+	}
+
+	// nfs_lock 和 nfs_flock 在 nfs 模块中，未加载时跳过
+	if cfg.Features.Locks {
+		kprobeFuncs["kb_nfs_lock"] = "nfs_lock"
+		kprobeFuncs["kb_nfs_flock"] = "nfs_flock"
+		kretprobeFuncs["kretb_nfs_lock"] = "nfs_lock"
+		kretprobeFuncs["kretb_nfs_flock"] = "nfs_flock"
+	}
+
+	if cfg.Features.ServerHealth {
+		kprobeFuncs["kb_xprt_adjust_timeout"] = "xprt_adjust_timeout"
+		kprobeFuncs["kb_xprt_complete_rqst"] = "xprt_complete_rqst"
+		kretprobeFuncs["kretb_xprt_adjust_timeout"] = "xprt_adjust_timeout"
+	}
+
+	return kprobeFuncs, kretprobeFuncs
+}
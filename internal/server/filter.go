@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/bpf"
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// InitEventFilter 注册内核态事件过滤规则的查询接口，启用 event_filter.runtime 时注册增删接口
+func InitEventFilter(r *gin.Engine, cfg config.Configuration) {
+	r.GET("/filters", func(c *gin.Context) {
+		c.JSON(http.StatusOK, bpf.Filters.List())
+	})
+
+	if !cfg.EventFilter.Runtime {
+		return
+	}
+	r.POST("/filters", updateEventFilter(bpf.Filters.Add))
+	r.DELETE("/filters", updateEventFilter(bpf.Filters.Delete))
+}
+
+func updateEventFilter(update func(bpf.FilterRule) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rule bpf.FilterRule
+		if err := c.ShouldBindJSON(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := update(rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, bpf.Filters.List())
+	}
+}
@@ -2,11 +2,40 @@ package server
 
 import (
 	"github.com/cen-ngc5139/nfs-trace/internal/cache"
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
 	"github.com/cen-ngc5139/nfs-trace/internal/output"
 	"github.com/gin-gonic/gin"
 )
 
-func InitPrometheusMetrics(r *gin.Engine) {
+func InitPrometheusMetrics(r *gin.Engine, cfg config.Configuration) {
 	nfsMetrics := output.NewNFSMetrics(cache.NFSPerformanceMap, cache.NFSFileDetailMap)
-	r.GET("/metrics", nfsMetrics.MetricsHandler())
+	xprtMetrics := output.NewXprtMetrics(cache.XprtStatsMap, cache.DevXprtMap)
+	mountStatsMetrics := output.NewMountStatsMetrics(cache.MountStatsMap)
+	mapUsageMetrics := output.NewMapUsageMetrics(cache.BPFMapUsageMap)
+
+	if cfg.Features.IOPattern {
+		r.GET("/io-pattern", output.AccessPatterns.Handler())
+	}
+
+	if cfg.Features.Locks {
+		r.GET("/locks", output.Locks.Handler())
+	}
+
+	updaters := []output.MetricsUpdater{xprtMetrics, mountStatsMetrics, mapUsageMetrics}
+	if cfg.Features.ServerHealth {
+		updaters = append(updaters, output.ServerHealth)
+	}
+	if cfg.Features.AccessMethod {
+		updaters = append(updaters, output.NewAccessMetrics(cache.NFSAccessMetricsMap))
+	}
+
+	// 启用热点文件统计时只导出 Top-K 文件的指标
+	if cfg.Features.TopK {
+		topKMetrics := output.NewTopKMetrics(output.HotFiles)
+		r.GET("/metrics", output.MetricsHandler(append([]output.MetricsUpdater{topKMetrics}, updaters...)...))
+		r.GET("/topk", output.HotFiles.Handler())
+		return
+	}
+
+	r.GET("/metrics", nfsMetrics.MetricsHandler(updaters...))
 }
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/cen-ngc5139/nfs-trace/internal/bpf"
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// InitProbeControl 注册查询命中次数的接口，启用 runtime_probes 时注册运行时附加、卸载函数的接口
+func InitProbeControl(r *gin.Engine, cfg config.Configuration) {
+	r.GET("/probes", func(c *gin.Context) {
+		c.JSON(http.StatusOK, bpf.Probes.List())
+	})
+
+	if !cfg.Probing.RuntimeProbes {
+		return
+	}
+	r.POST("/probes", updateProbes(bpf.Probes.Attach))
+	r.DELETE("/probes", updateProbes(bpf.Probes.Detach))
+}
+
+func updateProbes(update func(bpf.ProbeRequest) (bpf.ProbeResult, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req bpf.ProbeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := update(req)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/cen-ngc5139/nfs-trace/internal/config"
 	"github.com/cen-ngc5139/nfs-trace/internal/log"
 	"k8s.io/klog/v2"
 
@@ -36,7 +37,7 @@ func Ping(c *gin.Context) {
 	})
 }
 
-func NewServer(middleware ...gin.HandlerFunc) *Server {
+func NewServer(cfg config.Configuration, middleware ...gin.HandlerFunc) *Server {
 	// 设置 Gin 的模式为发布模式
 	gin.SetMode(gin.ReleaseMode)
 
@@ -50,7 +51,9 @@ func NewServer(middleware ...gin.HandlerFunc) *Server {
 	r.GET("/ping", Ping)
 
 	InitProbe(r)
-	InitPrometheusMetrics(r)
+	InitPrometheusMetrics(r, cfg)
+	InitEventFilter(r, cfg)
+	InitProbeControl(r, cfg)
 	pprof.Register(r, "pprof")
 
 	r.Use(middleware...)
@@ -0,0 +1,25 @@
+#!/usr/bin/env bash
+# 从 btfhub-archive 下载内核 BTF，解压为 tools/btfgen 的输入布局 <ID>/<VERSION_ID>/<arch>/<uname -r>.btf
+# 用法: fetch-btfs.sh <输出目录> <ID/VERSION_ID/arch>...
+set -euo pipefail
+
+out=${1:?usage: $0 <output-dir> <ID/VERSION_ID/arch>...}
+shift
+
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+git clone --depth 1 --filter=blob:none --sparse https://github.com/aquasecurity/btfhub-archive "$tmp/archive"
+git -C "$tmp/archive" sparse-checkout set "$@"
+
+for dir in "$@"; do
+    if [ ! -d "$tmp/archive/$dir" ]; then
+        echo "skip $dir: not found in btfhub-archive" >&2
+        continue
+    fi
+
+    mkdir -p "$out/$dir"
+    for f in "$tmp/archive/$dir"/*.btf.tar.xz; do
+        tar -xJf "$f" -C "$out/$dir"
+    done
+done
@@ -0,0 +1,111 @@
+// btfgen 从本地的内核 BTF 生成精简后的 BTF 归档，供 internal/kernelbtf 内置使用。
+//
+// 输入目录布局为 <ID>/<VERSION_ID>/<arch>/<uname -r>.btf，依赖 bpftool gen min_core_btf（bpftool 7.0+）
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// bpf2go 按架构生成的 eBPF 对象，精简后的 BTF 需要同时满足有界循环和展开两个版本
+var objects = map[string][]string{
+	"x86_64": {"internal/binary/nfstrace_x86_bpfel.o", "internal/binary/nfstracelegacy_x86_bpfel.o"},
+	"arm64":  {"internal/binary/nfstrace_arm64_bpfel.o", "internal/binary/nfstracelegacy_arm64_bpfel.o"},
+}
+
+func main() {
+	input := flag.String("input", "", "directory of kernel BTF files laid out as <ID>/<VERSION_ID>/<arch>/<release>.btf")
+	output := flag.String("output", "internal/kernelbtf/archive/btfs.tar.gz", "output archive")
+	bpftool := flag.String("bpftool", "bpftool", "path of bpftool")
+	flag.Parse()
+
+	if *input == "" {
+		log.Fatal("-input is required")
+	}
+
+	var files []string
+	err := filepath.WalkDir(*input, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(p, ".btf") {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("walk %s: %v", *input, err)
+	}
+	sort.Strings(files)
+
+	tmp, err := os.MkdirTemp("", "btfgen")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	out, err := os.Create(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	for _, file := range files {
+		name, err := filepath.Rel(*input, file)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		arch := filepath.Base(filepath.Dir(file))
+		obj, ok := objects[arch]
+		if !ok {
+			log.Printf("skip %s: unknown arch %s", name, arch)
+			continue
+		}
+
+		minimized := filepath.Join(tmp, strings.ReplaceAll(name, string(filepath.Separator), "_"))
+		cmd := exec.Command(*bpftool, append([]string{"gen", "min_core_btf", file, minimized}, obj...)...)
+		if msg, err := cmd.CombinedOutput(); err != nil {
+			log.Fatalf("minimize %s: %v\n%s", name, err, msg)
+		}
+
+		if err := addFile(tw, filepath.ToSlash(name), minimized); err != nil {
+			log.Fatalf("add %s: %v", name, err)
+		}
+		fmt.Println(name)
+	}
+
+	if err := tw.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func addFile(tw *tar.Writer, name, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data))}); err != nil {
+		return err
+	}
+
+	_, err = tw.Write(data)
+	return err
+}